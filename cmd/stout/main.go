@@ -2,10 +2,11 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	apexlog "github.com/apex/log"
@@ -18,9 +19,11 @@ import (
 	_ "github.com/noxiouz/stout/isolate/process"
 
 	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/events"
 	"github.com/noxiouz/stout/pkg/exportmetrics"
 	"github.com/noxiouz/stout/pkg/log"
 	"github.com/noxiouz/stout/pkg/logutils"
+	"github.com/noxiouz/stout/pkg/secret"
 	"github.com/noxiouz/stout/version"
 
 	flag "github.com/ogier/pflag"
@@ -64,6 +67,21 @@ func sendEvery(ctx context.Context, sender exportmetrics.Sender, d time.Duration
 	}
 }
 
+// onSignal calls fn every time one of signals is received until ctx is cancelled
+func onSignal(ctx context.Context, fn func(), signals ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ch:
+			fn()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func main() {
 	if showVersion {
 		printVersion()
@@ -71,12 +89,7 @@ func main() {
 	}
 
 	// Read configuration
-	data, err := ioutil.ReadFile(configpath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "unable to read config: %v\n", err)
-		os.Exit(1)
-	}
-	config, err := isolate.Parse(data)
+	config, err := isolate.ParseFile(configpath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "config is invalid: %v\n", err)
 		os.Exit(1)
@@ -87,33 +100,53 @@ func main() {
 	}
 
 	// Create logger
-	output, err := logutils.NewLogFileOutput(config.Logger.Output)
+	handler, output, err := logutils.NewHandler(config.Logger.Format, config.Logger.Output)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "unable to open logfile output: %v\n", err)
 		os.Exit(1)
 	}
 	defer output.Close()
 
+	levels, err := logutils.NewComponentLevels(config.Logger.Level, config.Logger.Components)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logger configuration: %v\n", err)
+		os.Exit(1)
+	}
+	// levels are checked by the handler as they can be lowered at runtime
 	logger := &apexlog.Logger{
-		Level:   apexlog.Level(config.Logger.Level),
-		Handler: logutils.NewLogHandler(output),
+		Level:   apexlog.DebugLevel,
+		Handler: logutils.NewComponentHandler(logutils.NewRedactHandler(handler), levels),
 	}
+	http.Handle("/debug/loglevel", levels)
 
-	ctx := log.WithLogger(context.Background(), apexlog.NewEntry(logger))
+	ctx := log.WithLogger(context.Background(), apexlog.NewEntry(logger).WithField(log.ComponentKey, "daemon"))
 	ctx, cancelFunc := context.WithCancel(ctx)
 	defer cancelFunc()
 
-	// Initialize metrics sender
-	sender, err := exportmetrics.New(ctx, config)
+	// Initialize metrics senders
+	exporters, err := exportmetrics.New(ctx, config)
 	if err != nil {
 		logger.Fatalf("unable to create metrics exporter %v", err)
 	}
-	period := time.Duration(config.Metrics.Period)
-	if period < minimalPeriod {
-		logger.Warnf("metrics: specified period is too low. Set %s", minimalPeriod)
-		period = minimalPeriod
+	for _, exporter := range exporters {
+		period := exporter.Period
+		if period < minimalPeriod {
+			logger.WithField("exporter", exporter.Type).Warnf("metrics: specified period is too low. Set %s", minimalPeriod)
+			period = minimalPeriod
+		}
+		go sendEvery(ctx, exporter.Sender, period)
+	}
+
+	prometheusExporter, err := exportmetrics.NewPrometheus(ctx, config, metrics.DefaultRegistry)
+	if err != nil {
+		logger.Fatalf("unable to create prometheus exporter %v", err)
+	}
+	http.Handle("/metrics/prometheus", prometheusExporter)
+
+	if err = events.Start(ctx, &config.Events, events.DefaultBus); err != nil {
+		logger.Fatalf("unable to start event sinks %v", err)
 	}
-	go sendEvery(ctx, sender, period)
+	http.Handle("/events", events.SSEHandler(events.DefaultBus))
 
 	// create isolateDaemon
 	isolateDaemon, err := daemon.New(ctx, config)
@@ -122,12 +155,29 @@ func main() {
 	}
 	defer isolateDaemon.Close()
 	isolateDaemon.RegisterHTTPHandlers(ctx, http.DefaultServeMux)
+	go onSignal(ctx, func() {
+		logger.Info("SIGHUP: reload TLS certificates")
+		isolateDaemon.ReloadCertificates(ctx)
+	}, syscall.SIGHUP)
+	// SIGUSR1 is handled even if the output can't be reopened, otherwise it terminates the daemon
+	go onSignal(ctx, func() {
+		reopener, ok := output.(logutils.Reopener)
+		if !ok {
+			logger.Info("SIGUSR1: log output is not a file, nothing to reopen")
+			return
+		}
+		if err := reopener.Reopen(); err != nil {
+			logger.WithError(err).Error("SIGUSR1: unable to reopen log file")
+			return
+		}
+		logger.Info("SIGUSR1: log file has been reopened")
+	}, syscall.SIGUSR1)
 	go daemon.Collect(ctx, 30*time.Second)
 
 	if config.DebugServer != "" {
 		logger.WithField("endpoint", config.DebugServer).Info("start debug HTTP-server")
 		go func() {
-			logger.WithError(http.ListenAndServe(config.DebugServer, nil)).Error("debug server is listening")
+			logger.WithError(http.ListenAndServe(config.DebugServer, secret.Handler(http.DefaultServeMux))).Error("debug server is listening")
 		}()
 	}
 
@@ -21,6 +21,10 @@ type Daemon struct {
 	listeners []net.Listener
 	State     isolate.GlobalState
 
+	tlsEndpoints []*tlsEndpoint
+	// boxProbes are readiness probes of MTN and boxes. They are built once to keep caches of probes
+	boxProbes []isolate.Probe
+
 	muListeners sync.Mutex
 }
 
@@ -34,7 +38,7 @@ func New(ctx context.Context, configuration *isolate.Config) (*Daemon, error) {
 	}
 
 	if !d.State.Mtn.CfgInit(ctx, configuration) {
-		return nil, fmt.Errorf("%s ERROR: Cant do cfgInit() inside daemon.New() for MTN with configuration: %s", time.Now().UTC().Format(time.RFC3339), configuration)
+		return nil, fmt.Errorf("%s ERROR: Cant do cfgInit() inside daemon.New() for MTN with configuration: %+v", time.Now().UTC().Format(time.RFC3339), configuration.Mtn)
 	}
 	errInited := d.State.Mtn.PoolInit(ctx)
 	if errInited != nil {
@@ -54,7 +58,8 @@ func New(ctx context.Context, configuration *isolate.Config) (*Daemon, error) {
 		}
 		boxTypes[cfg.Type] = struct{}{}
 
-		boxCtx := log.WithLogger(ctx, log.G(ctx).WithField("box", name))
+		boxCtx := log.WithLogger(ctx, log.G(ctx).WithFields(apexlog.Fields{"box": name, log.ComponentKey: cfg.Type}))
+		boxCtx = isolate.WithBoxName(boxCtx, name)
 		box, err := isolate.ConstructBox(boxCtx, cfg.Type, cfg.Args, d.State)
 		if err != nil {
 			log.G(ctx).WithError(err).WithField("box", name).WithField("type", cfg.Type).Error("unable to create box")
@@ -63,11 +68,16 @@ func New(ctx context.Context, configuration *isolate.Config) (*Daemon, error) {
 		}
 		d.boxes[name] = box
 	}
+	d.boxProbes = d.buildBoxProbes()
 
 	return &d, nil
 }
 
 func (d *Daemon) RegisterHTTPHandlers(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", healthHandler(ctx, d.livenessProbes))
+	mux.HandleFunc("/readyz", healthHandler(ctx, d.readinessProbes))
+	mux.HandleFunc("/images", imagesHandler(ctx, d.boxes))
+
 	for name := range d.boxes {
 		http.HandleFunc("/inspect/"+name, func(name string) http.HandlerFunc {
 			return func(w http.ResponseWriter, r *http.Request) {
@@ -109,13 +119,30 @@ func (d *Daemon) RegisterHTTPHandlers(ctx context.Context, mux *http.ServeMux) {
 func (d *Daemon) Serve(ctx context.Context) error {
 	var listeners = make([]net.Listener, 0, len(d.cfg.Endpoints))
 	for _, endpoint := range d.cfg.Endpoints {
+		var tlsEndpoint *tlsEndpoint
+		if endpoint.TLS != nil {
+			var err error
+			if tlsEndpoint, err = newTLSEndpoint(endpoint); err != nil {
+				log.G(ctx).WithError(err).WithField("endpoint", endpoint).Error("unable to configure TLS")
+				closeListeners(listeners)
+				return err
+			}
+		}
+
 		log.G(ctx).WithField("endpoint", endpoint).Info("start TCP server")
-		ln, err := net.Listen("tcp", endpoint)
+		ln, err := net.Listen("tcp", endpoint.Addr)
 		if err != nil {
 			log.G(ctx).WithError(err).WithField("endpoint", endpoint).Error("unable to listen to")
 			closeListeners(listeners)
 			return err
 		}
+
+		if tlsEndpoint != nil {
+			d.muListeners.Lock()
+			d.tlsEndpoints = append(d.tlsEndpoints, tlsEndpoint)
+			d.muListeners.Unlock()
+			ln = &tlsListener{Listener: ln, endpoint: tlsEndpoint}
+		}
 		listeners = append(listeners, ln)
 	}
 
@@ -150,6 +177,10 @@ func (d *Daemon) ServeOnListeners(ctx context.Context, listeners []net.Listener)
 				lnLogger.WithFields(apexlog.Fields{"remote.addr": conn.RemoteAddr(), "conn.id": connID}).Info("accepted new connection")
 
 				go func() {
+					if err := handshake(ctx, conn); err != nil {
+						conn.Close()
+						return
+					}
 					conns.Inc(0)
 					defer conns.Dec(0)
 					isolate.NewConnectionHandler(context.WithValue(ctx, "conn.id", connID)).HandleConn(conn)
@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const probeTimeout = 5 * time.Second
+
+// healthchecksMu serializes runs of go-metrics healthchecks, they don't guard their results
+var healthchecksMu sync.Mutex
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type probeResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]probeResult `json:"checks"`
+}
+
+// healthcheckProbes converts go-metrics healthchecks of the registry into probes
+func healthcheckProbes(r metrics.Registry) []isolate.Probe {
+	var probes []isolate.Probe
+	r.Each(func(name string, value interface{}) {
+		hc, ok := value.(metrics.Healthcheck)
+		if !ok {
+			return
+		}
+		probes = append(probes, isolate.Probe{
+			Name: name,
+			Check: func(ctx context.Context) error {
+				healthchecksMu.Lock()
+				defer healthchecksMu.Unlock()
+				hc.Check()
+				return hc.Error()
+			},
+		})
+	})
+	return probes
+}
+
+// livenessProbes checks the daemon itself
+func (d *Daemon) livenessProbes() []isolate.Probe {
+	return healthcheckProbes(metrics.DefaultRegistry)
+}
+
+// readinessProbes checks that the daemon is able to spawn workers
+func (d *Daemon) readinessProbes() []isolate.Probe {
+	return append(d.livenessProbes(), d.boxProbes...)
+}
+
+// buildBoxProbes collects probes of MTN and boxes prefixed by names of boxes
+func (d *Daemon) buildBoxProbes() []isolate.Probe {
+	probes := d.State.Mtn.Probes()
+
+	names := make([]string, 0, len(d.boxes))
+	for name := range d.boxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prober, ok := d.boxes[name].(isolate.Prober)
+		if !ok {
+			continue
+		}
+		for _, probe := range prober.Probes() {
+			probe.Name = name + "." + probe.Name
+			probes = append(probes, probe)
+		}
+	}
+	return probes
+}
+
+// runProbes runs probes concurrently. A probe which doesn't finish in time fails
+func runProbes(ctx context.Context, probes []isolate.Probe, timeout time.Duration) healthReport {
+	report := healthReport{
+		Status: statusOK,
+		Checks: make(map[string]probeResult, len(probes)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, probe := range probes {
+		wg.Add(1)
+		go func(probe isolate.Probe) {
+			defer wg.Done()
+			result := runProbe(ctx, probe, timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[probe.Name] = result
+			if result.Status != statusOK {
+				report.Status = statusFail
+			}
+		}(probe)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func runProbe(ctx context.Context, probe isolate.Probe, timeout time.Duration) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- probe.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = fmt.Errorf("probe has not finished in %s", timeout)
+	}
+
+	result := probeResult{
+		Status:   statusOK,
+		Duration: time.Since(start).String(),
+	}
+	if err != nil {
+		result.Status = statusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// healthHandler replies with 200 if all probes pass and with 503 otherwise
+func healthHandler(ctx context.Context, probes func() []isolate.Probe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD":
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := runProbes(ctx, probes(), probeTimeout)
+		for name, result := range report.Checks {
+			if result.Status != statusOK {
+				log.G(ctx).WithField("probe", name).WithField("error", result.Error).Warn("health probe failed")
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status == statusOK {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(report)
+		}
+	}
+}
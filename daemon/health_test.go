@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+)
+
+func TestRunProbes(t *testing.T) {
+	assertT := require.New(t)
+
+	dir, err := ioutil.TempDir("", "stout-health")
+	assertT.NoError(err)
+	defer os.RemoveAll(dir)
+
+	probes := []isolate.Probe{
+		isolate.DirWritableProbe("spool.writable", dir),
+		isolate.FreeSpaceProbe("spool.freespace", dir, 1),
+		{Name: "broken", Check: func(ctx context.Context) error { return errors.New("broken") }},
+		{Name: "hanging", Check: func(ctx context.Context) error { select {} }},
+	}
+
+	report := runProbes(context.Background(), probes, 100*time.Millisecond)
+	assertT.Equal(statusFail, report.Status)
+	assertT.Equal(statusOK, report.Checks["spool.writable"].Status)
+	assertT.Equal(statusOK, report.Checks["spool.freespace"].Status)
+	assertT.Equal(statusFail, report.Checks["broken"].Status)
+	assertT.Equal("broken", report.Checks["broken"].Error)
+	assertT.Equal(statusFail, report.Checks["hanging"].Status)
+
+	report = runProbes(context.Background(), probes[:2], time.Second)
+	assertT.Equal(statusOK, report.Status)
+}
+
+func TestHealthHandler(t *testing.T) {
+	assertT := require.New(t)
+
+	var healthy bool
+	handler := healthHandler(context.Background(), func() []isolate.Probe {
+		return []isolate.Probe{{Name: "probe", Check: func(ctx context.Context) error {
+			if healthy {
+				return nil
+			}
+			return errors.New("unhealthy")
+		}}}
+	})
+
+	for _, c := range []struct {
+		healthy bool
+		code    int
+		status  string
+	}{
+		{false, http.StatusServiceUnavailable, statusFail},
+		{true, http.StatusOK, statusOK},
+	} {
+		healthy = c.healthy
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		assertT.Equal(c.code, rec.Code)
+		assertT.Equal("application/json", rec.Header().Get("Content-Type"))
+
+		var report healthReport
+		assertT.NoError(json.Unmarshal(rec.Body.Bytes(), &report))
+		assertT.Equal(c.status, report.Status)
+		assertT.Equal(c.status, report.Checks["probe"].Status)
+	}
+}
+
+func TestCachedProbe(t *testing.T) {
+	assertT := require.New(t)
+
+	var checks int
+	probe := isolate.CachedProbe(isolate.Probe{Name: "probe", Check: func(ctx context.Context) error {
+		checks++
+		return errors.New("unhealthy")
+	}}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		assertT.EqualError(probe.Check(context.Background()), "unhealthy")
+	}
+	assertT.Equal(1, checks)
+}
+
+func TestReadinessCachesAllocatorProbe(t *testing.T) {
+	assertT := require.New(t)
+
+	var requests int32
+	allocator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer allocator.Close()
+
+	d := &Daemon{
+		boxes: make(isolate.Boxes),
+		State: isolate.GlobalState{Mtn: &isolate.MtnState{Cfg: isolate.MtnCfg{Enable: true, Url: allocator.URL}}},
+	}
+	d.boxProbes = d.buildBoxProbes()
+
+	handler := healthHandler(context.Background(), d.readinessProbes)
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var report healthReport
+		assertT.NoError(json.Unmarshal(rec.Body.Bytes(), &report))
+		assertT.Equal(statusOK, report.Checks["mtn.allocator"].Status)
+	}
+	assertT.EqualValues(1, atomic.LoadInt32(&requests))
+}
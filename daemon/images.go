@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+)
+
+// imagesHandler replies with images of apps per box. The box query arg selects one box
+func imagesHandler(ctx context.Context, boxes isolate.Boxes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD":
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		selected := r.URL.Query().Get("box")
+		if _, ok := boxes[selected]; selected != "" && !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Box %s is unavailable", selected)
+			return
+		}
+
+		reply := make(map[string][]isolate.Image)
+		for name, box := range boxes {
+			if selected != "" && name != selected {
+				continue
+			}
+			lister, ok := box.(isolate.ImageLister)
+			if !ok {
+				continue
+			}
+			images, err := lister.Images(ctx)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "Box.Images %s failed %v\n", name, err)
+				return
+			}
+			reply[name] = images
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(reply)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+)
+
+type imagesBox struct {
+	isolate.Box
+	images []isolate.Image
+}
+
+func (b *imagesBox) Images(ctx context.Context) ([]isolate.Image, error) {
+	return b.images, nil
+}
+
+func TestImagesHandler(t *testing.T) {
+	assertT := require.New(t)
+
+	spooled := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	porto := &imagesBox{images: []isolate.Image{{
+		App:       "app",
+		Reference: "registry.net/apps/app@sha256:0123",
+		Digest:    "sha256:0123",
+		Size:      42,
+		Spooled:   spooled,
+		Workers:   []isolate.Worker{{UUID: "uuid", Digest: "sha256:0123"}},
+	}}}
+	boxes := isolate.Boxes{"porto": porto, "process": &imagesBox{}}
+	// boxes without images are skipped
+	boxes["other"] = struct{ isolate.Box }{}
+	handler := imagesHandler(context.Background(), boxes)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/images"+query, nil))
+		return w
+	}
+
+	w := get("")
+	assertT.Equal(http.StatusOK, w.Code)
+	var reply map[string][]isolate.Image
+	assertT.NoError(json.NewDecoder(w.Body).Decode(&reply))
+	assertT.Len(reply, 2)
+	assertT.Equal(porto.images, reply["porto"])
+	assertT.Empty(reply["process"])
+
+	w = get("?box=porto")
+	assertT.Equal(http.StatusOK, w.Code)
+	reply = nil
+	assertT.NoError(json.NewDecoder(w.Body).Decode(&reply))
+	assertT.Len(reply, 1)
+
+	w = get("?box=unknown")
+	assertT.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/images", nil))
+	assertT.Equal(http.StatusMethodNotAllowed, w.Code)
+}
@@ -27,6 +27,8 @@ var (
 	threads    = metrics.NewGauge()
 	conns      = metrics.NewCounter()
 
+	tlsHandshakeErrors = metrics.NewCounter()
+
 	registry = metrics.NewPrefixedChildRegistry(metrics.DefaultRegistry, "daemon_")
 )
 
@@ -35,6 +37,7 @@ func init() {
 	registry.Register("goroutines", goroutines)
 	registry.Register("threads", threads)
 	registry.Register("connections", conns)
+	registry.Register("tls_handshake_errors", tlsHandshakeErrors)
 
 	registry.Register("hc_openfd", metrics.NewHealthcheck(fdHealthCheck))
 	registry.Register("hc_threads", metrics.NewHealthcheck(threadHealthCheck))
@@ -83,7 +86,9 @@ func Collect(ctx context.Context, period time.Duration) {
 		openFDs.Update(int64(count))
 		threads.Update(int64(pprof.Lookup("threadcreate").Count()))
 
+		healthchecksMu.Lock()
 		metrics.DefaultRegistry.RunHealthchecks()
+		healthchecksMu.Unlock()
 	}
 	collect()
 	for {
@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tlsEndpoint keeps TLS configuration of an endpoint.
+// The configuration is replaced on reload, established connections are not affected.
+type tlsEndpoint struct {
+	cfg     isolate.EndpointTLSConfig
+	addr    string
+	current atomic.Value // *tls.Config
+}
+
+func newTLSEndpoint(endpoint isolate.Endpoint) (*tlsEndpoint, error) {
+	t := &tlsEndpoint{
+		cfg:  *endpoint.TLS,
+		addr: endpoint.Addr,
+	}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reload reads certificates from disk. The previous configuration is kept on error
+func (t *tlsEndpoint) reload() error {
+	cert, err := tls.LoadX509KeyPair(t.cfg.Cert, t.cfg.Key)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate for %s: %v", t.addr, err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.cfg.ClientCA != "" {
+		pem, err := ioutil.ReadFile(t.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("unable to read client CA for %s: %v", t.addr, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA %s", t.cfg.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if len(t.cfg.AllowedSubjects) > 0 {
+			config.VerifyPeerCertificate = t.verifySubject
+		}
+	}
+
+	t.current.Store(config)
+	return nil
+}
+
+func (t *tlsEndpoint) config() *tls.Config {
+	return t.current.Load().(*tls.Config)
+}
+
+func (t *tlsEndpoint) verifySubject(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		if len(chain) == 0 {
+			continue
+		}
+		subject := chain[0].Subject
+		for _, allowed := range t.cfg.AllowedSubjects {
+			if allowed == subject.String() || allowed == subject.CommonName {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate subject is not allowed")
+}
+
+// tlsListener wraps accepted connections into TLS.
+// Handshake is left to a caller to log failures with the remote address.
+type tlsListener struct {
+	net.Listener
+	endpoint *tlsEndpoint
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(conn, l.endpoint.config()), nil
+}
+
+// handshake performs TLS handshake if conn is a TLS connection
+func handshake(ctx context.Context, conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		tlsHandshakeErrors.Inc(1)
+		log.G(ctx).WithError(err).WithField("remote.addr", conn.RemoteAddr()).Warn("TLS handshake failed")
+		return err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	if state := tlsConn.ConnectionState(); len(state.PeerCertificates) > 0 {
+		log.G(ctx).WithField("remote.addr", conn.RemoteAddr()).WithField("subject", state.PeerCertificates[0].Subject.CommonName).Info("TLS client authenticated")
+	}
+	return nil
+}
+
+// ReloadCertificates rereads certificates of all TLS endpoints
+func (d *Daemon) ReloadCertificates(ctx context.Context) {
+	d.muListeners.Lock()
+	endpoints := d.tlsEndpoints
+	d.muListeners.Unlock()
+
+	for _, endpoint := range endpoints {
+		if err := endpoint.reload(); err != nil {
+			log.G(ctx).WithError(err).WithField("endpoint", endpoint.addr).Error("unable to reload TLS certificates, keep the previous ones")
+			continue
+		}
+		log.G(ctx).WithField("endpoint", endpoint.addr).Info("TLS certificates have been reloaded")
+	}
+}
@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signerCert, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pair: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certPath, keyPath string) {
+	certPath = filepath.Join(dir, name+".crt")
+	keyPath = filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
+
+func TestTLSEndpointClientSubjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stout-tls")
+	require.NoError(t, err)
+
+	ca := newTestCert(t, "test-ca", nil)
+	caPath, _ := ca.writePEM(t, dir, "ca")
+	certPath, keyPath := newTestCert(t, "127.0.0.1", ca).writePEM(t, dir, "server")
+
+	endpoint, err := newTLSEndpoint(isolate.Endpoint{
+		Addr: "127.0.0.1:0",
+		TLS: &isolate.EndpointTLSConfig{
+			Cert:            certPath,
+			Key:             keyPath,
+			ClientCA:        caPath,
+			AllowedSubjects: []string{"runtime"},
+		},
+	})
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	tlsLn := &tlsListener{Listener: ln, endpoint: endpoint}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cases := []struct {
+		client *testCert
+		ok     bool
+	}{
+		{newTestCert(t, "runtime", ca), true},
+		{newTestCert(t, "intruder", ca), false},
+		{newTestCert(t, "runtime", nil), false},
+		{nil, false},
+	}
+
+	for _, c := range cases {
+		clientCfg := &tls.Config{RootCAs: roots}
+		if c.client != nil {
+			clientCfg.Certificates = []tls.Certificate{c.client.pair}
+		}
+
+		go func() {
+			conn, err := tls.Dial("tcp", ln.Addr().String(), clientCfg)
+			if err == nil {
+				// TLS 1.3 client finishes the handshake before the server verifies it
+				conn.Read(make([]byte, 1))
+				conn.Close()
+			}
+		}()
+
+		conn, err := tlsLn.Accept()
+		require.NoError(t, err)
+		err = handshake(context.Background(), conn)
+		conn.Close()
+		if c.ok {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
+	}
+}
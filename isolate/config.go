@@ -0,0 +1,287 @@
+package isolate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/noxiouz/stout/pkg/secret"
+)
+
+const (
+	// defaultIncludeDir is looked up next to the main configuration file
+	// if `include` is not specified explicitly
+	defaultIncludeDir = "conf.d"
+
+	fileRefPrefix = "@file:"
+)
+
+var includeExtensions = map[string]struct{}{
+	".json": {},
+	".conf": {},
+	".yaml": {},
+	".yml":  {},
+}
+
+// ParseFile reads the configuration file, merges the include directory into it
+// and decodes the result. A relative include directory is resolved against
+// the directory of the configuration file.
+func ParseFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(data, filepath.Dir(path), true)
+}
+
+// Parse decodes the configuration given as JSON or YAML.
+// Include directory and environment/file references are handled as well.
+func Parse(data []byte) (*Config, error) {
+	return parse(data, "", false)
+}
+
+func parse(data []byte, basedir string, lookupDefaultInclude bool) (*Config, error) {
+	tree, err := decodeConfigTree(data)
+	if err != nil {
+		return nil, err
+	}
+
+	includeDir, explicit := tree["include"].(string)
+	if !explicit && lookupDefaultInclude {
+		includeDir = defaultIncludeDir
+	}
+	if includeDir != "" {
+		if includeDir, err = interpolateEnv(includeDir); err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(includeDir) {
+			includeDir = filepath.Join(basedir, includeDir)
+		}
+		if err = mergeIncludeDir(tree, includeDir, explicit); err != nil {
+			return nil, err
+		}
+	}
+
+	resolved, err := resolveReferences(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	config.Mtn.Headers = make(map[string]secret.String)
+	if err = json.Unmarshal(body, &config); err != nil {
+		return nil, err
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// decodeConfigTree decodes a configuration document into a generic tree.
+// JSON is detected by a leading `{`, otherwise the document is treated as YAML.
+func decodeConfigTree(data []byte) (map[string]interface{}, error) {
+	var tree map[string]interface{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		if err := decoder.Decode(&tree); err != nil {
+			return nil, err
+		}
+		return tree, nil
+	}
+
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	switch converted := convertYAML(raw).(type) {
+	case map[string]interface{}:
+		return converted, nil
+	case nil:
+		return make(map[string]interface{}), nil
+	default:
+		return nil, fmt.Errorf("configuration must be a mapping, not %T", converted)
+	}
+}
+
+// convertYAML replaces map[interface{}]interface{} produced by YAML decoder
+// with map[string]interface{} to make the tree encodable to JSON
+func convertYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = convertYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertYAML(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func mergeIncludeDir(tree map[string]interface{}, dir string, mustExist bool) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			return nil
+		}
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if _, ok := includeExtensions[filepath.Ext(file.Name())]; ok {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		fragment, err := decodeConfigTree(data)
+		if err != nil {
+			return fmt.Errorf("include %s: %v", name, err)
+		}
+		mergeConfigTrees(tree, fragment)
+	}
+
+	return nil
+}
+
+// mergeConfigTrees merges src into dst. Nested mappings are merged recursively,
+// any other value from src replaces the value from dst.
+func mergeConfigTrees(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeConfigTrees(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// resolveReferences interpolates ${ENV} variables in every string value
+// and replaces `@file:/path` values with the content of the file
+func resolveReferences(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			resolved, err := resolveReferences(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			v[key] = resolved
+		}
+		return v, nil
+	case []interface{}:
+		for i, item := range v {
+			resolved, err := resolveReferences(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+		return v, nil
+	case string:
+		s, err := interpolateEnv(v)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(s, fileRefPrefix) {
+			return readFileRef(strings.TrimPrefix(s, fileRefPrefix))
+		}
+		return s, nil
+	default:
+		return v, nil
+	}
+}
+
+func readFileRef(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s%s: %v", fileRefPrefix, path, err)
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// interpolateEnv replaces ${NAME} and ${NAME:-default} with values of environment variables.
+// `$$` is an escape sequence for `$`.
+func interpolateEnv(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var buff bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			buff.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			buff.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			expr := s[i+2 : i+2+end]
+			name, defaultValue, hasDefault := expr, "", false
+			if idx := strings.Index(expr, ":-"); idx >= 0 {
+				name, defaultValue, hasDefault = expr[:idx], expr[idx+2:], true
+			}
+			if name == "" {
+				return "", fmt.Errorf("empty variable name in %q", s)
+			}
+
+			value, ok := os.LookupEnv(name)
+			switch {
+			case ok && value != "":
+			case hasDefault:
+				value = defaultValue
+			case !ok:
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			buff.WriteString(value)
+			i += end + 2
+		default:
+			buff.WriteByte(s[i])
+		}
+	}
+
+	return buff.String(), nil
+}
@@ -0,0 +1,124 @@
+package isolate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func init() {
+	Suite(&configSuite{})
+}
+
+type configSuite struct {
+	dir string
+}
+
+func (s *configSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *configSuite) writeFile(c *C, name, body string) string {
+	path := filepath.Join(s.dir, name)
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(path, []byte(body), 0600), IsNil)
+	return path
+}
+
+const baseJSONConfig = `{
+    "version": 2,
+    "endpoints": ["0.0.0.0:29042"],
+    "logger": {"level": "info", "output": "/dev/stderr"},
+    "mtn": {"headers": {"Authorization": "@file:%s"}},
+    "isolate": {
+        "porto": {
+            "type": "porto",
+            "args": {"layers": "${STOUT_TEST_LAYERS}", "journal": "${STOUT_TEST_UNSET:-/tmp/journal}"}
+        }
+    }
+}`
+
+func (s *configSuite) TestParseJSONWithReferences(c *C) {
+	secret := s.writeFile(c, "secret", "OAuth token\n")
+	os.Setenv("STOUT_TEST_LAYERS", "/var/tmp/layers")
+	defer os.Unsetenv("STOUT_TEST_LAYERS")
+
+	cfg, err := ParseFile(s.writeFile(c, "stout.conf", fmt.Sprintf(baseJSONConfig, secret)))
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Version, Equals, 2)
+	c.Assert(cfg.Mtn.Headers["Authorization"].Value(), Equals, "OAuth token")
+	c.Assert(fmt.Sprintf("%v", cfg.Mtn), Not(Matches), ".*OAuth token.*")
+	c.Assert(cfg.Isolate["porto"].Args["layers"], Equals, "/var/tmp/layers")
+	c.Assert(cfg.Isolate["porto"].Args["journal"], Equals, "/tmp/journal")
+}
+
+func (s *configSuite) TestParseUnsetVariable(c *C) {
+	os.Unsetenv("STOUT_TEST_LAYERS")
+	_, err := ParseFile(s.writeFile(c, "stout.conf", fmt.Sprintf(baseJSONConfig, "/dev/null")))
+	c.Assert(err, ErrorMatches, ".*STOUT_TEST_LAYERS is not set")
+}
+
+func (s *configSuite) TestParseYAMLWithIncludes(c *C) {
+	path := s.writeFile(c, "stout.yaml", `
+version: 2
+endpoints: ["0.0.0.0:29042"]
+metrics:
+  type: graphite
+  period: 10s
+isolate:
+  process:
+    type: process
+    args:
+      spool: /var/spool/cocaine
+`)
+	s.writeFile(c, "conf.d/10-porto.yaml", `
+isolate:
+  porto:
+    type: porto
+    args:
+      layers: /var/tmp/layers
+`)
+	s.writeFile(c, "conf.d/20-override.json", `{"endpoints": ["[::]:29042"], "isolate": {"process": {"args": {"locator": "localhost:10053"}}}}`)
+	s.writeFile(c, "conf.d/README", `this file is skipped`)
+
+	cfg, err := ParseFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Endpoints, DeepEquals, []Endpoint{{Addr: "[::]:29042"}})
+	c.Assert(cfg.Metrics.Type, Equals, "graphite")
+	c.Assert(cfg.Isolate, HasLen, 2)
+	c.Assert(cfg.Isolate["process"].Args["spool"], Equals, "/var/spool/cocaine")
+	c.Assert(cfg.Isolate["process"].Args["locator"], Equals, "localhost:10053")
+	c.Assert(cfg.Isolate["porto"].Args["layers"], Equals, "/var/tmp/layers")
+}
+
+func (s *configSuite) TestParseExplicitIncludeMustExist(c *C) {
+	_, err := Parse([]byte(`{"version": 2, "include": "/nonexistent/stout/conf.d"}`))
+	c.Assert(err, NotNil)
+}
+
+func (s *configSuite) TestInterpolateEnv(c *C) {
+	os.Setenv("STOUT_TEST_VAR", "value")
+	defer os.Unsetenv("STOUT_TEST_VAR")
+
+	cases := []struct {
+		in, out string
+	}{
+		{"plain", "plain"},
+		{"${STOUT_TEST_VAR}", "value"},
+		{"a-${STOUT_TEST_VAR}-b", "a-value-b"},
+		{"$${STOUT_TEST_VAR}", "${STOUT_TEST_VAR}"},
+		{"${STOUT_TEST_UNSET:-fallback}", "fallback"},
+		{"cost $5", "cost $5"},
+	}
+	for _, t := range cases {
+		out, err := interpolateEnv(t.in)
+		c.Assert(err, IsNil)
+		c.Assert(out, Equals, t.out)
+	}
+
+	_, err := interpolateEnv("${STOUT_TEST_VAR")
+	c.Assert(err, NotNil)
+}
@@ -67,7 +67,7 @@ func NewConnectionHandler(ctx context.Context) *ConnectionHandler {
 
 func newConnectionHandler(ctx context.Context, newDisp dispatcherInit) *ConnectionHandler {
 	connID := getID(ctx)
-	ctx = log.WithLogger(ctx, log.G(ctx).WithField("conn.id", connID))
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("conn.id", connID).WithField(log.ComponentKey, "connection"))
 
 	return &ConnectionHandler{
 		ctx:            ctx,
@@ -0,0 +1,167 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apexlog "github.com/apex/log"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	loadedImagePrefix   = "Loaded image: "
+	loadedImageIDPrefix = "Loaded image ID: "
+)
+
+// openSource opens a tarball or streams a directory as a tarball
+func openSource(path string) (io.ReadCloser, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return os.Open(path)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, path))
+	}()
+	return pr, nil
+}
+
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// decodeImageLoad returns references of images loaded by Docker
+func decodeImageLoad(r io.Reader) ([]string, error) {
+	var loaded []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, err
+		}
+		if msg.Error != "" {
+			return nil, fmt.Errorf("%s", msg.Error)
+		}
+		line := strings.TrimSpace(msg.Stream)
+		switch {
+		case strings.HasPrefix(line, loadedImagePrefix):
+			loaded = append(loaded, strings.TrimPrefix(line, loadedImagePrefix))
+		case strings.HasPrefix(line, loadedImageIDPrefix):
+			loaded = append(loaded, strings.TrimPrefix(line, loadedImageIDPrefix))
+		}
+	}
+	return loaded, scanner.Err()
+}
+
+// loadedImage picks the image of the app among loaded ones. The only loaded image is used for any name
+func loadedImage(image string, loaded []string) (string, error) {
+	if len(loaded) == 1 {
+		return loaded[0], nil
+	}
+	if !strings.Contains(image, "@") && strings.LastIndex(image, ":") <= strings.LastIndex(image, "/") {
+		image += ":latest"
+	}
+	for _, ref := range loaded {
+		if ref == image || strings.HasSuffix(ref, "/"+image) {
+			return ref, nil
+		}
+	}
+	return "", fmt.Errorf("image %s is not found among loaded %s", image, strings.Join(loaded, ", "))
+}
+
+// load spools the app from a local docker save archive with ImageLoad
+func (b *Box) load(ctx context.Context, name string, profile *Profile) (err error) {
+	defer log.G(ctx).WithField("source", profile.Source).Trace("loading an image").Stop(&err)
+	path, err := isolate.SourcePath(profile.Source)
+	if err != nil {
+		return err
+	}
+	source, err := openSource(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	resp, err := b.client.ImageLoad(ctx, source, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	loaded, err := decodeImageLoad(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	image := profile.Image
+	if image == "" {
+		image = name
+	}
+	ref, err := loadedImage(image, loaded)
+	if err != nil {
+		return err
+	}
+
+	record := imageRecord{Reference: profile.Source, Pulled: ref, Spooled: time.Now()}
+	if inspect, _, err := b.client.ImageInspectWithRaw(ctx, ref, false); err == nil {
+		record.Size = inspect.Size
+	} else {
+		log.G(ctx).WithError(err).WithField("ref", ref).Warn("unable to inspect the loaded image")
+	}
+	log.G(ctx).WithFields(apexlog.Fields{"source": profile.Source, "ref": ref}).Info("image has been loaded")
+	b.setImage(name, record)
+	return nil
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeImageLoad(t *testing.T) {
+	reply := `{"stream":"Loaded image: registry.net/app:1.0\n"}
+{"stream":"Loaded image ID: sha256:0123\n"}
+`
+	loaded, err := decodeImageLoad(strings.NewReader(reply))
+	require.NoError(t, err)
+	require.Equal(t, []string{"registry.net/app:1.0", "sha256:0123"}, loaded)
+
+	_, err = decodeImageLoad(strings.NewReader(`{"error":"invalid tar header"}`))
+	require.EqualError(t, err, "invalid tar header")
+}
+
+func TestLoadedImage(t *testing.T) {
+	loaded := []string{"registry.net/other:latest", "registry.net/app:latest"}
+	ref, err := loadedImage("app", loaded)
+	require.NoError(t, err)
+	require.Equal(t, "registry.net/app:latest", ref)
+
+	_, err = loadedImage("app:1.0", loaded)
+	require.EqualError(t, err, "image app:1.0 is not found among loaded registry.net/other:latest, registry.net/app:latest")
+
+	// the only image is used for any name
+	ref, err = loadedImage("app", []string{"sha256:0123"})
+	require.NoError(t, err)
+	require.Equal(t, "sha256:0123", ref)
+}
+
+func TestOpenSourceDirectory(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "layout")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	require.NoError(os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "index.json"), []byte("{}"), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "blobs", "sha256", "0123"), []byte("blob"), 0644))
+
+	source, err := openSource(dir)
+	require.NoError(err)
+	defer source.Close()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(source)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(err)
+		body, err := ioutil.ReadAll(tr)
+		require.NoError(err)
+		files[hdr.Name] = string(body)
+	}
+	require.Equal(map[string]string{"blobs": "", "blobs/sha256": "", "blobs/sha256/0123": "blob", "index.json": "{}"}, files)
+}
@@ -6,13 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/noxiouz/stout/pkg/log"
+	"github.com/noxiouz/stout/pkg/mirror"
 	"golang.org/x/net/context"
 
 	apexlog "github.com/apex/log"
@@ -22,6 +23,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 
 	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/secret"
 	"github.com/noxiouz/stout/pkg/semaphore"
 )
 
@@ -47,21 +49,31 @@ type Box struct {
 
 	client *client.Client
 
-	spawnSM semaphore.Semaphore
+	spawnSM      *semaphore.Weighted
+	spawnLimiter *semaphore.Adaptive
 
 	config *dockerBoxConfig
 
-	state   isolate.GlobalState
+	state isolate.GlobalState
 
 	muContainers sync.Mutex
 	containers   map[string]*process
+
+	muImages sync.Mutex
+	images   map[string]imageRecord
+
+	mirrors *mirror.Pool
 }
 
 type dockerBoxConfig struct {
-	DockerEndpoint   string            `json:"endpoint"`
-	APIVersion       string            `json:"version"`
-	SpawnConcurrency uint              `json:"concurrency"`
-	RegistryAuth     map[string]string `json:"registryauth"`
+	DockerEndpoint   string                   `json:"endpoint"`
+	APIVersion       string                   `json:"version"`
+	SpawnConcurrency uint                     `json:"concurrency"`
+	RegistryAuth     map[string]secret.String `json:"registryauth"`
+	// Mirrors of registries and breakers of unhealthy ones
+	RegistryMirrors mirror.Config `json:"registrymirrors"`
+	// Adjusts SpawnConcurrency according to latency and errors of spawns
+	AdaptiveConcurrency semaphore.AdaptiveConfig `json:"adaptiveconcurrency"`
 }
 
 // NewBox ...
@@ -75,6 +87,7 @@ func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalSta
 		WeaklyTypedInput: true,
 		Result:           config,
 		TagName:          "json",
+		DecodeHook:       secret.DecodeHook,
 	}
 
 	decoder, err := mapstructure.NewDecoder(&decoderConfig)
@@ -97,10 +110,20 @@ func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalSta
 		cancellation: cancellation,
 
 		client:     client,
-		spawnSM:    semaphore.New(config.SpawnConcurrency),
+		spawnSM:    semaphore.NewWeighted(int64(config.SpawnConcurrency), spawnSMMetrics),
 		config:     config,
-		state: gstate,
+		state:      gstate,
 		containers: make(map[string]*process),
+		images:     make(map[string]imageRecord),
+		mirrors:    mirror.NewPool(config.RegistryMirrors, mirrorMetrics),
+	}
+
+	if config.AdaptiveConcurrency.Enabled {
+		box.spawnLimiter, err = semaphore.NewAdaptive(box.spawnSM, config.AdaptiveConcurrency, spawnAdaptiveMetrics)
+		if err != nil {
+			box.Close()
+			return nil, err
+		}
 	}
 
 	body, err := json.Marshal(config)
@@ -214,19 +237,31 @@ func (b *Box) Spawn(ctx context.Context, config isolate.SpawnConfig, output io.W
 		spawningQueueSize.Dec(1)
 		return nil, syscall.EAGAIN
 	}
-	err = b.spawnSM.Acquire(ctx)
+	release, err := b.spawnSM.AcquireN(ctx, config.Weight, config.Priority)
 	spawningQueueSize.Dec(1)
 	if err != nil {
 		return nil, isolate.ErrSpawningCancelled
 	}
-	defer b.spawnSM.Release()
+	defer release()
+	defer func(started time.Time) {
+		b.spawnLimiter.Observe(ctx, time.Since(started), err)
+	}(time.Now())
 
 	containersCreatedCounter.Inc(1)
-	pr, err := newContainer(ctx, b.client, profile, config.Name, config.Executable, config.Args, config.Env)
+	image, spooled := b.image(config.Name)
+	containerImage := containerImage(config.Name, profile)
+	if spooled && image.Pulled != "" {
+		// a loaded image or a pinned one pulled from a mirror can't be tagged as the one of the registry
+		containerImage = image.Pulled
+	}
+	pr, err := newContainer(ctx, b.client, profile, containerImage, config.Name, config.Executable, config.Args, config.Env)
 	if err != nil {
 		containersErroredCounter.Inc(1)
 		return nil, err
 	}
+	if spooled {
+		pr.imageDigest = image.Digest
+	}
 
 	b.muContainers.Lock()
 	b.containers[pr.containerID] = pr
@@ -254,6 +289,19 @@ func (b *Box) Inspect(ctx context.Context, workeruuid string) ([]byte, error) {
 	return []byte("{}"), nil
 }
 
+// Probes checks that Docker daemon replies
+func (b *Box) Probes() []isolate.Probe {
+	return []isolate.Probe{
+		{
+			Name: "docker.ping",
+			Check: func(ctx context.Context) error {
+				_, err := b.client.ServerVersion(ctx)
+				return err
+			},
+		},
+	}
+}
+
 // Spool spools an image with a tag latest
 func (b *Box) Spool(ctx context.Context, name string, opts isolate.RawProfile) (err error) {
 	profile, err := decodeProfile(opts)
@@ -262,34 +310,88 @@ func (b *Box) Spool(ctx context.Context, name string, opts isolate.RawProfile) (
 		return err
 	}
 
+	if profile.Source != "" {
+		return b.load(ctx, name, profile)
+	}
+
 	if profile.Registry == "" {
 		log.G(ctx).WithField("name", name).Info("local image will be used")
 		return nil
 	}
 
-	ref := filepath.Join(profile.Registry, profile.Repository, name)
+	ref := imageRef(name, profile)
 
 	defer log.G(ctx).WithField("ref", ref).Trace("spooling an image").Stop(&err)
+	// mirrors are tried before the registry, a tag pulled from a mirror is tagged as the one of the registry
+	endpoints := b.mirrors.Endpoints(profile.Registry)
+	var pulled string
+	err = b.mirrors.Do(ctx, endpoints, func(i int) error {
+		endpointRef := ref
+		if !endpoints[i].Primary {
+			endpointRef = mirrorRef(ref, profile.Registry, endpoints[i].Host)
+		}
+		if err := b.pull(ctx, endpointRef, endpoints[i].Host); err != nil {
+			log.G(ctx).WithError(err).WithField("ref", endpointRef).Error("unable to pull an image")
+			return classifyPullError(err)
+		}
+		pulled = endpointRef
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	image := imageRecord{Reference: ref, Spooled: time.Now()}
+	if pulled != ref {
+		if isDigestRef(ref) {
+			image.Pulled = pulled
+		} else if err = b.client.ImageTag(ctx, pulled, ref, types.ImageTagOptions{Force: true}); err != nil {
+			log.G(ctx).WithError(err).WithFields(apexlog.Fields{"ref": ref, "mirror": pulled}).Error("unable to tag the image pulled from a mirror")
+			return err
+		}
+	}
+	if inspect, _, err := b.client.ImageInspectWithRaw(ctx, pulled, false); err == nil {
+		image.Digest = repoDigest(pulled, inspect.RepoDigests)
+		image.Size = inspect.Size
+	} else {
+		log.G(ctx).WithError(err).WithField("ref", pulled).Warn("unable to inspect the pulled image")
+	}
+	log.G(ctx).WithFields(apexlog.Fields{"ref": ref, "pulled": pulled, "digest": image.Digest}).Info("image has been pulled")
+	b.setImage(name, image)
+
+	return nil
+}
+
+// pullClientErrors are substrings of Docker replies about unknown images and denied access
+var pullClientErrors = []string{"not found", "manifest unknown", "unauthorized", "denied"}
+
+// classifyPullError marks errors of a healthy registry, so they don't open its breaker
+func classifyPullError(err error) error {
+	msg := strings.ToLower(err.Error())
+	for _, clientErr := range pullClientErrors {
+		if strings.Contains(msg, clientErr) {
+			return &mirror.ClientError{Err: err}
+		}
+	}
+	return err
+}
+
+// pull pulls the image with the auth of the registry host
+func (b *Box) pull(ctx context.Context, ref, host string) error {
 	pullOpts := types.ImagePullOptions{
 		All: false,
 	}
-
-	if registryAuth, ok := b.config.RegistryAuth[profile.Registry]; ok {
-		pullOpts.RegistryAuth = registryAuth
+	if registryAuth, ok := b.config.RegistryAuth[host]; ok {
+		pullOpts.RegistryAuth = registryAuth.Value()
 	}
 
 	body, err := b.client.ImagePull(ctx, ref, pullOpts)
 	if err != nil {
-		log.G(ctx).WithError(err).WithField("ref", ref).Error("unable to pull an image")
 		return err
 	}
 	defer body.Close()
 
-	if err = decodeImagePull(ctx, body); err != nil {
-		return err
-	}
-
-	return nil
+	return decodeImagePull(ctx, body)
 }
 
 // decodeImagePull detects Error of an image pulling proces
@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"bytes"
+	"testing"
+
+	apexlog "github.com/apex/log"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/log"
+	"github.com/noxiouz/stout/pkg/logutils"
+	"github.com/noxiouz/stout/pkg/secret/secrettest"
+)
+
+func TestConfigSecretsAreRedacted(t *testing.T) {
+	const token = "dockerregistry-secret-token"
+
+	var logs bytes.Buffer
+	logger := &apexlog.Logger{
+		Level:   apexlog.DebugLevel,
+		Handler: logutils.NewRedactHandler(logutils.NewLogHandler(&logs)),
+	}
+	ctx, cancel := context.WithCancel(log.WithLogger(context.Background(), apexlog.NewEntry(logger)))
+	defer cancel()
+
+	b, err := NewBox(ctx, isolate.BoxConfig{
+		"endpoint":     "unix:///nonexistent/docker.sock",
+		"registryauth": map[string]interface{}{"registry.images.net": token},
+	}, isolate.GlobalState{})
+	if err != nil {
+		t.Fatalf("NewBox: %v", err)
+	}
+	defer b.Close()
+
+	if auth := b.(*Box).config.RegistryAuth["registry.images.net"].Value(); auth != token {
+		t.Fatalf("expected token %q, actual %q", token, auth)
+	}
+
+	log.G(ctx).WithField("auth", token).Infof("config %v", b.(*Box).config)
+
+	secrettest.New(t, token).Expvar().Bytes("logs", logs.Bytes())
+}
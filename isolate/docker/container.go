@@ -46,17 +46,25 @@ type process struct {
 	removed uint32
 
 	uuid string
+	// the app and the digest of the image the container has been spawned from
+	appname     string
+	imageDigest string
 }
 
-func newContainer(ctx context.Context, client *client.Client, profile *Profile, name, executable string, args, env map[string]string) (pr *process, err error) {
-	defer log.G(ctx).Trace("spawning container").Stop(&err)
-
-	var image string
-	if registry := profile.Registry; registry != "" {
-		image = registry + "/" + name
-	} else {
-		image = name
+// containerImage returns the image containers of the app are created from
+func containerImage(name string, profile *Profile) string {
+	switch {
+	case profile.Image != "":
+		// a pinned image isn't tagged by a pull
+		return imageRef(name, profile)
+	case profile.Registry != "":
+		return profile.Registry + "/" + name
 	}
+	return name
+}
+
+func newContainer(ctx context.Context, client *client.Client, profile *Profile, image, name, executable string, args, env map[string]string) (pr *process, err error) {
+	defer log.G(ctx).Trace("spawning container").Stop(&err)
 
 	var Env = make([]string, 0, len(env))
 	for k, v := range env {
@@ -139,6 +147,7 @@ func newContainer(ctx context.Context, client *client.Client, profile *Profile,
 		client:       client,
 		containerID:  resp.ID,
 		uuid:         workeruuid,
+		appname:      name,
 	}
 
 	return pr, nil
@@ -15,6 +15,7 @@ import (
 	"github.com/docker/engine-api/client"
 	"github.com/docker/engine-api/types"
 	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/mirror"
 	"github.com/tinylib/msgp/msgp"
 
 	"github.com/stretchr/testify/assert"
@@ -61,7 +62,7 @@ func TestContainer(t *testing.T) {
 	args := map[string]string{"--endpoint": "/var/run/cocaine.sock"}
 	env := map[string]string{"A": "B"}
 
-	container, err := newContainer(ctx, client, &profile, "alpine", "echo", args, env)
+	container, err := newContainer(ctx, client, &profile, containerImage("alpine", &profile), "alpine", "echo", args, env)
 	assert.NoError(err)
 
 	inspect, err := client.ContainerInspect(ctx, container.containerID)
@@ -112,6 +113,14 @@ func TestImagePullFromMock(t *testing.T) {
 	}
 }
 
+func TestClassifyPullError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(mirror.IsFailure(classifyPullError(fmt.Errorf("manifest for app:latest not found"))))
+	assert.False(mirror.IsFailure(classifyPullError(fmt.Errorf("unauthorized: authentication required"))))
+	assert.True(mirror.IsFailure(classifyPullError(fmt.Errorf("received unexpected HTTP status: 502 Bad Gateway"))))
+}
+
 func TestImagePullFromRegistry(t *testing.T) {
 	assert := assert.New(t)
 	var endpoint string
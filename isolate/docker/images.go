@@ -0,0 +1,103 @@
+package docker
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+)
+
+var _ isolate.ImageLister = &Box{}
+
+// imageRef returns the reference of the image of the app in the registry
+func imageRef(name string, profile *Profile) string {
+	if profile.Image != "" {
+		return filepath.Join(profile.Registry, profile.Repository, profile.Image)
+	}
+	return filepath.Join(profile.Registry, profile.Repository, name)
+}
+
+// mirrorRef replaces the registry of the reference with the mirror
+func mirrorRef(ref, registry, host string) string {
+	return host + strings.TrimPrefix(ref, registry)
+}
+
+// isDigestRef tells if the reference is pinned to a digest
+func isDigestRef(ref string) bool {
+	return strings.Contains(ref, "@")
+}
+
+// repoDigest finds the digest of ref among RepoDigests of a pulled image
+func repoDigest(ref string, repoDigests []string) string {
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		return ref[i+1:]
+	}
+	// cut the tag, the port of the registry is followed by a slash
+	repository := ref
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repository = ref[:i]
+	}
+	for _, repoDigest := range repoDigests {
+		if strings.HasPrefix(repoDigest, repository+"@") {
+			return repoDigest[len(repository)+1:]
+		}
+	}
+	return ""
+}
+
+// imageRecord is the image an app has been spooled from
+type imageRecord struct {
+	Reference string
+	// Pulled is the local reference of a loaded image or of a pinned one pulled from a mirror.
+	// It's empty if the image has the Reference
+	Pulled  string
+	Digest  string
+	Size    int64
+	Spooled time.Time
+}
+
+func (b *Box) setImage(name string, image imageRecord) {
+	b.muImages.Lock()
+	b.images[name] = image
+	b.muImages.Unlock()
+}
+
+func (b *Box) image(name string) (imageRecord, bool) {
+	b.muImages.Lock()
+	defer b.muImages.Unlock()
+	image, ok := b.images[name]
+	return image, ok
+}
+
+// Images returns images spooled since the start of the box with workers running them
+func (b *Box) Images(ctx context.Context) ([]isolate.Image, error) {
+	workers := make(map[string][]isolate.Worker)
+	b.muContainers.Lock()
+	for _, p := range b.containers {
+		workers[p.appname] = append(workers[p.appname], isolate.Worker{UUID: p.uuid, Digest: p.imageDigest})
+	}
+	b.muContainers.Unlock()
+
+	b.muImages.Lock()
+	result := make([]isolate.Image, 0, len(b.images))
+	for app, image := range b.images {
+		running := workers[app]
+		sort.Slice(running, func(i, j int) bool { return running[i].UUID < running[j].UUID })
+		result = append(result, isolate.Image{
+			App:       app,
+			Reference: image.Reference,
+			Digest:    image.Digest,
+			Size:      image.Size,
+			Spooled:   image.Spooled,
+			Workers:   running,
+		})
+	}
+	b.muImages.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].App < result[j].App })
+	return result, nil
+}
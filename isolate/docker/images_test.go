@@ -0,0 +1,31 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageRef(t *testing.T) {
+	profile := &Profile{Registry: "registry.net:5000", Repository: "apps"}
+	require.Equal(t, "registry.net:5000/apps/app", imageRef("app", profile))
+	profile.Image = "worker@sha256:0123"
+	require.Equal(t, "registry.net:5000/apps/worker@sha256:0123", imageRef("app", profile))
+}
+
+func TestRepoDigest(t *testing.T) {
+	repoDigests := []string{
+		"registry.net:5000/apps/other@sha256:aaaa",
+		"registry.net:5000/apps/app@sha256:bbbb",
+	}
+	require.Equal(t, "sha256:bbbb", repoDigest("registry.net:5000/apps/app", repoDigests))
+	require.Equal(t, "sha256:bbbb", repoDigest("registry.net:5000/apps/app:latest", repoDigests))
+	require.Equal(t, "sha256:cccc", repoDigest("registry.net:5000/apps/app@sha256:cccc", repoDigests))
+	require.Equal(t, "", repoDigest("registry.net:5000/apps/unknown", repoDigests))
+}
+
+func TestMirrorRef(t *testing.T) {
+	require.Equal(t, "mirror.net/apps/app:latest", mirrorRef("registry.net:5000/apps/app:latest", "registry.net:5000", "mirror.net"))
+	require.True(t, isDigestRef(mirrorRef("registry.net/apps/app@sha256:0123", "registry.net", "mirror.net:5000")))
+	require.False(t, isDigestRef("registry.net:5000/apps/app:latest"))
+}
@@ -4,6 +4,9 @@ import (
 	"expvar"
 
 	"github.com/rcrowley/go-metrics"
+
+	"github.com/noxiouz/stout/pkg/mirror"
+	"github.com/noxiouz/stout/pkg/semaphore"
 )
 
 var (
@@ -17,6 +20,12 @@ var (
 
 	totalSpawnTimer = metrics.NewTimer()
 
+	spawnSMMetrics       = semaphore.NewMetrics()
+	spawnAdaptiveMetrics = semaphore.NewAdaptiveMetrics()
+
+	// failovers between registries and their mirrors
+	mirrorMetrics = mirror.NewMetrics()
+
 	dockerConfig = expvar.NewString("docker_config")
 )
 
@@ -26,4 +35,7 @@ func init() {
 	registry.Register("containers_created", containersCreatedCounter)
 	registry.Register("containers_errored", containersErroredCounter)
 	registry.Register("total_spawn_timer", totalSpawnTimer)
+	spawnSMMetrics.Register(registry, "spawn_sm_")
+	spawnAdaptiveMetrics.Register(registry, "spawn_adaptive_")
+	mirrorMetrics.Register(registry, "registry_mirrors_")
 }
@@ -26,7 +26,11 @@ type Resources struct {
 type Profile struct {
 	Registry   string `msg:"registry"`
 	Repository string `msg:"repository"`
-	Endpoint   string `msg:"endpoint"`
+	// Image is name[:tag] or name@sha256:... in Repository. The app name is used by default
+	Image string `msg:"image"`
+	// Source is file:///path of a docker save archive to load instead of pulling from Registry
+	Source   string `msg:"source"`
+	Endpoint string `msg:"endpoint"`
 
 	NetworkMode string `msg:"network_mode"`
 	RuntimePath string `msg:"runtime-path"`
@@ -34,6 +34,16 @@ func (z *Profile) DecodeMsg(dc *msgp.Reader) (err error) {
 			if err != nil {
 				return
 			}
+		case "image":
+			z.Image, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "source":
+			z.Source, err = dc.ReadString()
+			if err != nil {
+				return
+			}
 		case "endpoint":
 			z.Endpoint, err = dc.ReadString()
 			if err != nil {
@@ -115,9 +125,9 @@ func (z *Profile) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *Profile) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 9
+	// map header, size 11
 	// write "registry"
-	err = en.Append(0x89, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
+	err = en.Append(0x8b, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
 	if err != nil {
 		return err
 	}
@@ -134,6 +144,24 @@ func (z *Profile) EncodeMsg(en *msgp.Writer) (err error) {
 	if err != nil {
 		return
 	}
+	// write "image"
+	err = en.Append(0xa5, 0x69, 0x6d, 0x61, 0x67, 0x65)
+	if err != nil {
+		return err
+	}
+	err = en.WriteString(z.Image)
+	if err != nil {
+		return
+	}
+	// write "source"
+	err = en.Append(0xa6, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65)
+	if err != nil {
+		return err
+	}
+	err = en.WriteString(z.Source)
+	if err != nil {
+		return
+	}
 	// write "endpoint"
 	err = en.Append(0xa8, 0x65, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74)
 	if err != nil {
@@ -219,13 +247,19 @@ func (z *Profile) EncodeMsg(en *msgp.Writer) (err error) {
 // MarshalMsg implements msgp.Marshaler
 func (z *Profile) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 9
+	// map header, size 11
 	// string "registry"
-	o = append(o, 0x89, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
+	o = append(o, 0x8b, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
 	o = msgp.AppendString(o, z.Registry)
 	// string "repository"
 	o = append(o, 0xaa, 0x72, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x6f, 0x72, 0x79)
 	o = msgp.AppendString(o, z.Repository)
+	// string "image"
+	o = append(o, 0xa5, 0x69, 0x6d, 0x61, 0x67, 0x65)
+	o = msgp.AppendString(o, z.Image)
+	// string "source"
+	o = append(o, 0xa6, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65)
+	o = msgp.AppendString(o, z.Source)
 	// string "endpoint"
 	o = append(o, 0xa8, 0x65, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74)
 	o = msgp.AppendString(o, z.Endpoint)
@@ -286,6 +320,16 @@ func (z *Profile) UnmarshalMsg(bts []byte) (o []byte, err error) {
 			if err != nil {
 				return
 			}
+		case "image":
+			z.Image, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				return
+			}
+		case "source":
+			z.Source, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				return
+			}
 		case "endpoint":
 			z.Endpoint, bts, err = msgp.ReadStringBytes(bts)
 			if err != nil {
@@ -368,7 +412,7 @@ func (z *Profile) UnmarshalMsg(bts []byte) (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *Profile) Msgsize() (s int) {
-	s = 1 + 9 + msgp.StringPrefixSize + len(z.Registry) + 11 + msgp.StringPrefixSize + len(z.Repository) + 9 + msgp.StringPrefixSize + len(z.Endpoint) + 13 + msgp.StringPrefixSize + len(z.NetworkMode) + 13 + msgp.StringPrefixSize + len(z.RuntimePath) + 4 + msgp.StringPrefixSize + len(z.Cwd) + 10 + z.Resources.Msgsize() + 6 + msgp.MapHeaderSize
+	s = 1 + 9 + msgp.StringPrefixSize + len(z.Registry) + 11 + msgp.StringPrefixSize + len(z.Repository) + 6 + msgp.StringPrefixSize + len(z.Image) + 7 + msgp.StringPrefixSize + len(z.Source) + 9 + msgp.StringPrefixSize + len(z.Endpoint) + 13 + msgp.StringPrefixSize + len(z.NetworkMode) + 13 + msgp.StringPrefixSize + len(z.RuntimePath) + 4 + msgp.StringPrefixSize + len(z.Cwd) + 10 + z.Resources.Msgsize() + 6 + msgp.MapHeaderSize
 	if z.Tmpfs != nil {
 		for zxvk, zbzg := range z.Tmpfs {
 			_ = zbzg
@@ -0,0 +1,63 @@
+package isolate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Endpoint describes an address the daemon listens to.
+// It's decoded either from a string with an address
+// or from an object with an optional TLS section.
+type Endpoint struct {
+	Addr string             `json:"addr"`
+	TLS  *EndpointTLSConfig `json:"tls,omitempty"`
+}
+
+// EndpointTLSConfig enables TLS on the endpoint.
+// ClientCA turns on mutual TLS, AllowedSubjects restricts accepted client certificates
+// by the Subject (e.g. `CN=runtime,O=cocaine`) or by the Common Name only.
+type EndpointTLSConfig struct {
+	Cert            string   `json:"cert"`
+	Key             string   `json:"key"`
+	ClientCA        string   `json:"clientca,omitempty"`
+	AllowedSubjects []string `json:"allowedsubjects,omitempty"`
+}
+
+func (e *Endpoint) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '"' {
+		e.TLS = nil
+		return json.Unmarshal(trimmed, &e.Addr)
+	}
+
+	type plain Endpoint
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+func (e Endpoint) String() string {
+	if e.TLS != nil {
+		return "tls://" + e.Addr
+	}
+	return e.Addr
+}
+
+// Validate checks that the endpoint is usable
+func (e *Endpoint) Validate() error {
+	if e.Addr == "" {
+		return fmt.Errorf("endpoint address must be non empty")
+	}
+
+	if e.TLS == nil {
+		return nil
+	}
+
+	if e.TLS.Cert == "" || e.TLS.Key == "" {
+		return fmt.Errorf("endpoint %s: both `cert` and `key` are required for TLS", e.Addr)
+	}
+
+	if len(e.TLS.AllowedSubjects) > 0 && e.TLS.ClientCA == "" {
+		return fmt.Errorf("endpoint %s: `allowedsubjects` requires `clientca`", e.Addr)
+	}
+
+	return nil
+}
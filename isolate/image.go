@@ -0,0 +1,50 @@
+package isolate
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// SourceFileScheme is the scheme of local image sources of profiles
+const SourceFileScheme = "file://"
+
+// Image is the exact image an app has been spooled from
+type Image struct {
+	App string `json:"app"`
+	// Reference is the requested reference: a name with a tag or a digest
+	Reference string `json:"reference"`
+	// Digest is the manifest digest the reference has been resolved to
+	Digest  string    `json:"digest,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Spooled time.Time `json:"spooled"`
+	// Workers are uuids of running workers of the app with the image they have been spawned from
+	Workers []Worker `json:"workers,omitempty"`
+}
+
+// Worker is a running worker and the digest of its image
+type Worker struct {
+	UUID   string `json:"uuid"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// ImageLister is implemented by boxes which record images of spooled apps.
+// Images are served by the debug endpoint of the daemon
+type ImageLister interface {
+	Images(ctx context.Context) ([]Image, error)
+}
+
+// SourcePath returns the path of a local image source
+func SourcePath(source string) (string, error) {
+	if !strings.HasPrefix(source, SourceFileScheme) {
+		return "", fmt.Errorf("unsupported image source %q, %s is expected", source, SourceFileScheme)
+	}
+	path := strings.TrimPrefix(source, SourceFileScheme)
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path of the image source %q must be absolute", source)
+	}
+	return path, nil
+}
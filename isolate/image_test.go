@@ -0,0 +1,22 @@
+package isolate
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func init() {
+	Suite(&imageSuite{})
+}
+
+type imageSuite struct{}
+
+func (s *imageSuite) TestSourcePath(c *C) {
+	path, err := SourcePath("file:///var/images/app.tar")
+	c.Assert(err, IsNil)
+	c.Assert(path, Equals, "/var/images/app.tar")
+
+	_, err = SourcePath("http://images/app.tar")
+	c.Assert(err, NotNil)
+	_, err = SourcePath("file://images/app.tar")
+	c.Assert(err, NotNil)
+}
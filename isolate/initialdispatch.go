@@ -6,9 +6,11 @@ import (
 	"reflect"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/noxiouz/stout/pkg/events"
 	"github.com/noxiouz/stout/pkg/log"
 	"github.com/tinylib/msgp/msgp"
 )
@@ -177,13 +179,18 @@ func (d *initialDispatch) onSpool(opts *cocaineProfile, name string) (Dispatcher
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(d.ctx)
+	ctx, cancel := context.WithCancel(log.WithComponent(d.ctx, isolateType))
 
+	event := events.Event{Box: isolateType, App: name}
+	events.Publish(withType(event, events.SpoolStarted))
 	go func() {
+		start := time.Now()
 		if err := box.Spool(ctx, name, opts); err != nil {
+			events.Publish(withType(event, events.SpoolFailed).Since(start).WithError(err))
 			d.stream.Error(ctx, replySpoolError, errSpoolingFailed, err.Error())
 			return
 		}
+		events.Publish(withType(event, events.SpoolFinished).Since(start))
 		// NOTE: make sure that nil is packed as []interface{}
 		d.stream.Close(ctx, replySpoolOk)
 	}()
@@ -210,15 +217,24 @@ func (d *initialDispatch) onSpawn(opts *cocaineProfile, name, executable string,
 
 	log.G(d.ctx).Debugf("onSpawn() Profile Dump: %s", opts)
 
+	priority, err := opts.SpawnPriority()
+	if err != nil {
+		log.G(d.ctx).WithError(err).Warn("invalid spawn priority in a profile, use normal")
+	}
+	weight := opts.SpawnWeight()
+
 	prCh := make(chan Process)
 	flagKilled := uint32(0)
 	// ctx will be passed to Spawn function
 	// cancelSpawn will used by SpawnDispatch to cancel spawning
-	ctx, cancelSpawn := context.WithCancel(d.ctx)
+	ctx, cancelSpawn := context.WithCancel(log.WithComponent(d.ctx, isolateType))
+	event := events.Event{Box: isolateType, App: name, UUID: args["--uuid"]}
+	events.Publish(withType(event, events.SpawnRequested))
 	go func() {
 		defer close(prCh)
 
 		spawnMeter.Mark(1)
+		start := time.Now()
 
 		config := SpawnConfig{
 			Opts:       opts,
@@ -226,6 +242,8 @@ func (d *initialDispatch) onSpawn(opts *cocaineProfile, name, executable string,
 			Executable: executable,
 			Args:       args,
 			Env:        env,
+			Weight:     weight,
+			Priority:   priority,
 		}
 
 		outputCollector := &OutputCollector{
@@ -234,6 +252,7 @@ func (d *initialDispatch) onSpawn(opts *cocaineProfile, name, executable string,
 		}
 		pr, err := box.Spawn(ctx, config, outputCollector)
 		if err != nil {
+			events.Publish(withType(event, events.SpawnFailed).Since(start).WithError(err))
 			switch err {
 			case ErrSpawningCancelled, context.Canceled:
 				spawnCancelledMeter.Mark(1)
@@ -245,6 +264,7 @@ func (d *initialDispatch) onSpawn(opts *cocaineProfile, name, executable string,
 			}
 			return
 		}
+		events.Publish(withType(event, events.SpawnStarted).Since(start))
 
 		select {
 		case prCh <- pr:
@@ -255,7 +275,9 @@ func (d *initialDispatch) onSpawn(opts *cocaineProfile, name, executable string,
 			// Kill the process, set flagKilled to prevent trackOutput
 			// sending duplicated messages, reply WithKillOk
 			if atomic.CompareAndSwapUint32(&flagKilled, 0, 1) {
-				if err := pr.Kill(); err != nil {
+				err := pr.Kill()
+				events.Publish(withType(event, events.WorkerKilled).WithError(err))
+				if err != nil {
 					d.stream.Error(d.ctx, replyKillError, errKillError, err.Error())
 					return
 				}
@@ -265,7 +287,12 @@ func (d *initialDispatch) onSpawn(opts *cocaineProfile, name, executable string,
 		}
 	}()
 
-	return newSpawnDispatch(d.ctx, cancelSpawn, prCh, &flagKilled, d.stream), nil
+	return newSpawnDispatch(d.ctx, cancelSpawn, prCh, &flagKilled, d.stream, event), nil
+}
+
+func withType(e events.Event, typ events.Type) events.Event {
+	e.Type = typ
+	return e
 }
 
 type OutputCollector struct {
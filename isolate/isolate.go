@@ -7,7 +7,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/noxiouz/stout/pkg/events"
 	"github.com/noxiouz/stout/pkg/logutils"
+	"github.com/noxiouz/stout/pkg/secret"
+	"github.com/noxiouz/stout/pkg/semaphore"
 	"golang.org/x/net/context"
 )
 
@@ -17,6 +20,10 @@ type SpawnConfig struct {
 	Executable string
 	Args       map[string]string
 	Env        map[string]string
+
+	// Weight is the number of spawn slots taken by the worker
+	Weight   int64
+	Priority semaphore.Priority
 }
 
 type (
@@ -44,36 +51,53 @@ type (
 	BoxConfig map[string]interface{}
 
 	GlobalState struct {
-		Mtn     *MtnState
+		Mtn *MtnState
 	}
 
 	JSONEncodedDuration time.Duration
+
+	// MetricsExporterConfig describes a metrics sender with its own period
+	MetricsExporterConfig struct {
+		Type   string              `json:"type"`
+		Period JSONEncodedDuration `json:"period"`
+		Args   json.RawMessage     `json:"args"`
+	}
+
 	Config struct {
-		Version     int      `json:"version"`
-		Endpoints   []string `json:"endpoints"`
-		DebugServer string   `json:"debugserver"`
+		Version     int        `json:"version"`
+		Include     string     `json:"include,omitempty"`
+		Endpoints   []Endpoint `json:"endpoints"`
+		DebugServer string     `json:"debugserver"`
 		Logger      struct {
 			Level  logutils.Level `json:"level"`
-			Output string	 `json:"output"`
+			Output string         `json:"output"`
+			// Format is one of `text` (default), `json` or `syslog`
+			Format string `json:"format"`
+			// Components overrides Level for components like `porto` or `mtn`
+			Components map[string]logutils.Level `json:"components"`
 		} `json:"logger"`
 		Metrics struct {
-			Type   string	      `json:"type"`
+			Type   string              `json:"type"`
 			Period JSONEncodedDuration `json:"period"`
 			Args   json.RawMessage     `json:"args"`
+
+			Exporters  []MetricsExporterConfig `json:"exporters"`
+			Prometheus json.RawMessage         `json:"prometheus"`
 		} `json:"metrics"`
+		Events  events.Config `json:"events"`
 		Isolate map[string]struct {
-			Type string	    `json:"type"`
+			Type string    `json:"type"`
 			Args BoxConfig `json:"args"`
 		} `json:"isolate"`
 		Mtn struct {
-			Enable bool `json:"enable,omitempty"`
-			Allocbuffer int `json:"allocbuffer,omitempty"`
-			Url string `json:"url,omitempty"`
-			Label string `json:"label,omitempty"`
-			Ident string `json:"ident,omitempty"`
-			DbPath string `json:"dbpath,omitempty"`
-			AllowLocalState bool `json:"allowlocalstate,omitempty"`
-			Headers map[string]string `json:"headers,omitempty"`
+			Enable          bool                     `json:"enable,omitempty"`
+			Allocbuffer     int                      `json:"allocbuffer,omitempty"`
+			Url             string                   `json:"url,omitempty"`
+			Label           string                   `json:"label,omitempty"`
+			Ident           string                   `json:"ident,omitempty"`
+			DbPath          string                   `json:"dbpath,omitempty"`
+			AllowLocalState bool                     `json:"allowlocalstate,omitempty"`
+			Headers         map[string]secret.String `json:"headers,omitempty"`
 		} `json:"mtn,omitempty"`
 	}
 )
@@ -97,24 +121,50 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("`endpoints` section must containe at least one item")
 	}
 
+	for i := range c.Endpoints {
+		if err := c.Endpoints[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if !logutils.ValidFormat(c.Logger.Format) {
+		return fmt.Errorf("unknown `logger.format` %s", c.Logger.Format)
+	}
+
 	return nil
 }
 
-func Parse(data []byte) (*Config, error) {
-	var config Config
-	config.Mtn.Headers = make(map[string]string)
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+// MetricsExporters returns senders from `metrics.exporters`
+// with the legacy single sender described by `metrics.type` put first
+func (c *Config) MetricsExporters() []MetricsExporterConfig {
+	exporters := make([]MetricsExporterConfig, 0, len(c.Metrics.Exporters)+1)
+	if c.Metrics.Type != "" {
+		exporters = append(exporters, MetricsExporterConfig{
+			Type:   c.Metrics.Type,
+			Period: c.Metrics.Period,
+			Args:   c.Metrics.Args,
+		})
 	}
+	return append(exporters, c.Metrics.Exporters...)
+}
 
-	if err := config.Validate(); err != nil {
-		return nil, err
-	}
+const BoxesTag = "isolate.boxes.tag"
 
-	return &config, nil
+// boxNameTag keeps the name of a box in its configuration
+const boxNameTag = "isolate.boxname.tag"
+
+// WithBoxName passes the configured name of a box to its constructor and to events of its workers
+func WithBoxName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, boxNameTag, name)
 }
 
-const BoxesTag = "isolate.boxes.tag"
+// BoxName returns the configured name of the box or def if the box is constructed without it
+func BoxName(ctx context.Context, def string) string {
+	if name, ok := ctx.Value(boxNameTag).(string); ok && name != "" {
+		return name
+	}
+	return def
+}
 
 var (
 	notificationByte = []byte("")
@@ -11,10 +11,17 @@ import (
 	"sync"
 	"time"
 	bolt "go.etcd.io/bbolt"
+	"github.com/noxiouz/stout/pkg/events"
 	"github.com/noxiouz/stout/pkg/log"
+	"github.com/noxiouz/stout/pkg/secret"
 )
 
 
+// mtnLog marks entries by `mtn` component
+func mtnLog(ctx context.Context) log.Logger {
+	return log.G(ctx).WithField(log.ComponentKey, "mtn")
+}
+
 type RawAlloc struct {
 	Id string `json:"id"`
 	Porto RawPorto `json:"porto"`
@@ -48,7 +55,7 @@ type MtnCfg struct {
 	Url string
 	Ident string
 	SchedLabel string
-	Headers map[string]string
+	Headers map[string]secret.String
 	DbPath string
 }
 
@@ -116,7 +123,7 @@ func (c *MtnState) CfgInit(ctx context.Context, cfg *Config) bool {
 	if len(cfg.Mtn.Label) == 0 {
 		fqdn, err := os.Hostname()
 		if err != nil {
-			log.G(ctx).Errorf("Cant get hostname inside CfgInit() by calling os.Hostname(), returned: %s", err)
+			mtnLog(ctx).Errorf("Cant get hostname inside CfgInit() by calling os.Hostname(), returned: %s", err)
 			return false
 		}
 		c.Cfg.SchedLabel = fqdn
@@ -139,34 +146,34 @@ func (c *MtnState) CfgInit(ctx context.Context, cfg *Config) bool {
 	corruptedBackupPath := "/var/tmp/isolate.mtn.db.corrupted"
 	db, err := bolt.Open(c.Cfg.DbPath, 0666, &bolt.Options{Timeout: 10 * time.Second})
 	if err != nil {
-		log.G(ctx).Errorf("Cant open db inside CfgInit() by calling bolt.Open(), returned: %s", err)
+		mtnLog(ctx).Errorf("Cant open db inside CfgInit() by calling bolt.Open(), returned: %s", err)
 		if s, err := os.Stat(c.Cfg.DbPath); os.IsNotExist(err) {
-			log.G(ctx).Errorf("DB file not exist and we cant create new. Err: %s", err)
+			mtnLog(ctx).Errorf("DB file not exist and we cant create new. Err: %s", err)
 			return false
 		} else if err == nil {
 			fSize := s.Size()
 			if fSize > 0 {
 				if _, err := os.Stat(corruptedBackupPath); err == nil {
-					log.G(ctx).Errorf("Corrupted DB backup file exist, nothing to do there.")
+					mtnLog(ctx).Errorf("Corrupted DB backup file exist, nothing to do there.")
 					return false
 				}
-				log.G(ctx).Errorf("DB file exist, size %d and cant be opened. Try to recreate.", fSize)
+				mtnLog(ctx).Errorf("DB file exist, size %d and cant be opened. Try to recreate.", fSize)
 				errMove := SaveRename(c.Cfg.DbPath, corruptedBackupPath)
 				if errMove != nil {
-					log.G(ctx).Errorf("Cant move corrupted db file, err: %s", errMove)
+					mtnLog(ctx).Errorf("Cant move corrupted db file, err: %s", errMove)
 					return false
 				}
 			} else {
-				log.G(ctx).Errorf("DB file exist, size %d and cant be opened. Try to delete old.", fSize)
+				mtnLog(ctx).Errorf("DB file exist, size %d and cant be opened. Try to delete old.", fSize)
 				err := os.Remove(c.Cfg.DbPath)
 				if err != nil {
-					log.G(ctx).Errorf("Cant delete old db file, err: %s", err)
+					mtnLog(ctx).Errorf("Cant delete old db file, err: %s", err)
 					return false
 				}
 			}
 			db, err = bolt.Open(c.Cfg.DbPath, 0666, &bolt.Options{Timeout: 10 * time.Second})
 			if err != nil {
-				log.G(ctx).Errorf("Second try open db is failed, err: %s", err)
+				mtnLog(ctx).Errorf("Second try open db is failed, err: %s", err)
 				return false
 			}
 		}
@@ -180,7 +187,7 @@ func (c *MtnState) CfgInit(ctx context.Context, cfg *Config) bool {
 			}
 		})
 		if errDb != nil {
-			log.G(ctx).Errorf("DB fail consistency checks, err: %s", errDb)
+			mtnLog(ctx).Errorf("DB fail consistency checks, err: %s", errDb)
 			return false
 		}
 	}
@@ -232,7 +239,7 @@ func (c *MtnState) GetAllocations(logCtx context.Context) (map[string][]Allocati
 		return nil, errReq
 	}
 	for header, value := range c.Cfg.Headers {
-		req.Header.Set(header, value)
+		req.Header.Set(header, value.Value())
 	}
 	req = req.WithContext(ctx)
 	rh, errDo := http.DefaultClient.Do(req)
@@ -265,7 +272,7 @@ func (c *MtnState) GetAllocations(logCtx context.Context) (map[string][]Allocati
 	r := make(map[string][]Allocation)
 	jresp := []RawAlloc{}
 	decoder := json.NewDecoder(Body)
-	log.G(ctx).Debugf("reqHttp.Body from allocator getted in GetAllocations(): %s", &bufBody)
+	mtnLog(ctx).Debugf("reqHttp.Body from allocator getted in GetAllocations(): %s", &bufBody)
 	errDecode := decoder.Decode(&jresp)
 	if errDecode != nil {
 		return nil, errDecode
@@ -273,7 +280,7 @@ func (c *MtnState) GetAllocations(logCtx context.Context) (map[string][]Allocati
 	for _, a := range jresp {
 		r[a.Network] = append(r[a.Network], Allocation{a.Porto.Net, a.Porto.Hostname, a.Porto.Ip, a.Id, a.Network, "", false})
 	}
-	log.G(logCtx).Debugf("GetAllocations() successfull ended with ContentLength size %d.", req.ContentLength)
+	mtnLog(logCtx).Debugf("GetAllocations() successfull ended with ContentLength size %d.", req.ContentLength)
 	return r, nil
 }
 
@@ -286,14 +293,14 @@ func (c *MtnState) RequestAllocs(ctx context.Context, netid string) (map[string]
 	if errMrsh != nil {
 		return nil, errMrsh
 	}
-	log.G(ctx).Debugf("c.Cfg.Allocbuffer inside RequestAllocs() is %d.", c.Cfg.Allocbuffer)
+	mtnLog(ctx).Debugf("c.Cfg.Allocbuffer inside RequestAllocs() is %d.", c.Cfg.Allocbuffer)
 	for i := 0; i < c.Cfg.Allocbuffer; i++ {
 		req, errNewReq := http.NewRequest("POST", c.Cfg.Url, bytes.NewReader(txtBody))
 		if errNewReq != nil {
 			return nil, errNewReq
 		}
 		for header, value := range c.Cfg.Headers {
-			req.Header.Set(header, value)
+			req.Header.Set(header, value.Value())
 		}
 		req.Header.Set("Content-Type", "application/json")
 		req = req.WithContext(httpCtx)
@@ -301,28 +308,28 @@ func (c *MtnState) RequestAllocs(ctx context.Context, netid string) (map[string]
 		var bufBody bytes.Buffer
 		Body := io.TeeReader(reqHttp.Body, &bufBody)
 		if errDo != nil {
-			log.G(ctx).Errorf("Inside RequestAllocs(), erro: %s. Body: %s.", errDo, &bufBody)
+			mtnLog(ctx).Errorf("Inside RequestAllocs(), erro: %s. Body: %s.", errDo, &bufBody)
 			return nil, errDo
 		}
 		jsonResp := RawAlloc{}
 		decoder := json.NewDecoder(Body)
 		errDecode := decoder.Decode(&jsonResp)
-		log.G(ctx).Debugf("RequestAllocs() reqHttp.Body from allocator getted in RequestAllocs(): %s", &bufBody)
+		mtnLog(ctx).Debugf("RequestAllocs() reqHttp.Body from allocator getted in RequestAllocs(): %s", &bufBody)
 		reqHttp.Body.Close()
 		if errDecode != nil {
 			return nil, errDecode
 		}
-		log.G(ctx).Debugf("Allocation from allocator getted in RequestAllocs(): %s", jsonResp)
+		mtnLog(ctx).Debugf("Allocation from allocator getted in RequestAllocs(): %s", jsonResp)
 		r[jsonResp.Id] = Allocation{jsonResp.Porto.Net, jsonResp.Porto.Hostname, jsonResp.Porto.Ip, jsonResp.Id, netid, "", false}
 	}
-	log.G(ctx).Debugf("RequestAllocs() successfull ended with %s.", r)
+	mtnLog(ctx).Debugf("RequestAllocs() successfull ended with %s.", r)
 	return r, nil
 }
 
 func (c *MtnState) DbAllocIsFree(ctx context.Context, value []byte) bool {
 	var a Allocation
 	if err := json.Unmarshal(value, &a); err != nil {
-		log.G(ctx).Errorf("DbAllocIsFree() failed on json.Unmarshal()  with error:  %s.", err)
+		mtnLog(ctx).Errorf("DbAllocIsFree() failed on json.Unmarshal()  with error:  %s.", err)
 		return false
 	}
 	if a.Used {
@@ -349,7 +356,7 @@ func (c *MtnState) UsedAllocations(ctx context.Context) ([]Allocation, AllocsSta
 				stat.Free++
 				return nil
 			}
-			log.G(ctx).Infof("Found used alloc for net id %s with id %s: %s", netId, allocId, value)
+			mtnLog(ctx).Infof("Found used alloc for net id %s with id %s: %s", netId, allocId, value)
 			stat.Used++
 			var a Allocation
 			if errUnmrsh := json.Unmarshal(value, &a); errUnmrsh != nil {
@@ -396,10 +403,10 @@ func (c *MtnState) GetDbAlloc(ctx context.Context, tx *bolt.Tx, netId string, bo
 		}
 	}
 	fcounter, errCnt := c.CountFreeAllocs(ctx, tx, netId)
-	log.G(ctx).Warnf("Normaly we must never be in GetDbAlloc() at that point. But ok, lets try fix situation. Free count for that netId %s is %d (possible counter error: %s).", netId, fcounter, errCnt)
+	mtnLog(ctx).Warnf("Normaly we must never be in GetDbAlloc() at that point. But ok, lets try fix situation. Free count for that netId %s is %d (possible counter error: %s).", netId, fcounter, errCnt)
 	allocs, errAllocs := c.RequestAllocs(ctx, netId)
 	if errAllocs != nil {
-		log.G(ctx).Errorf("Last hope in GetDbAlloc() failed.")
+		mtnLog(ctx).Errorf("Last hope in GetDbAlloc() failed.")
 		return a, errAllocs
 	}
 	gotcha := false
@@ -432,7 +439,7 @@ func (c *MtnState) GetDbAlloc(ctx context.Context, tx *bolt.Tx, netId string, bo
 func (c *MtnState) FreeDbAlloc(ctx context.Context, netId string, id string) error {
 	tx, errTx := c.Db.Begin(true)
 	if errTx != nil {
-		log.G(ctx).Errorf("Cant start transaction inside FreeDbAlloc(), err: %s", errTx)
+		mtnLog(ctx).Errorf("Cant start transaction inside FreeDbAlloc(), err: %s", errTx)
 		return errTx
 	}
 	defer tx.Rollback()
@@ -472,7 +479,7 @@ func (c *MtnState) CountFreeAllocs(ctx context.Context, tx *bolt.Tx, netId strin
 		}
 		return nil
 	})
-	log.G(ctx).Debugf("CountFreeAllocs() ended for netId %s with count: %d.", netId, counter)
+	mtnLog(ctx).Debugf("CountFreeAllocs() ended for netId %s with count: %d.", netId, counter)
 	return counter, e
 }
 
@@ -480,40 +487,40 @@ func (c *MtnState) BindAllocs(ctx context.Context, netId string) error {
 	if len(netId) == 0 {
 		return fmt.Errorf("Len(netId) is zero.")
 	}
-	log.G(ctx).Debugf("BindAllocs() called with netId %s.", netId)
+	mtnLog(ctx).Debugf("BindAllocs() called with netId %s.", netId)
 
 	tx, errTx := c.Db.Begin(true)
 	if errTx != nil {
-		log.G(ctx).Errorf("Cant start transaction inside BindAllocs(), err: %s", errTx)
+		mtnLog(ctx).Errorf("Cant start transaction inside BindAllocs(), err: %s", errTx)
 		return errTx
 	}
 	defer tx.Rollback()
 	fCount, errCnt := c.CountFreeAllocs(ctx, tx, netId)
 	if errCnt != nil {
-		log.G(ctx).Errorf("Cant continue transaction inside BindAllocs(), err: %s", errCnt)
+		mtnLog(ctx).Errorf("Cant continue transaction inside BindAllocs(), err: %s", errCnt)
 		return errCnt
 	}
 	if c.Cfg.Allocbuffer > fCount {
 		allocs, err := c.RequestAllocs(ctx, netId)
 		if err != nil {
-			log.G(ctx).Errorf("Cant do c.RequestAllocs(%s) inside BindAllocs(), err: %s", netId, err)
+			mtnLog(ctx).Errorf("Cant do c.RequestAllocs(%s) inside BindAllocs(), err: %s", netId, err)
 			return err
 		}
-		log.G(ctx).Debugf("c.RequestAllocs(ctx, %s) end sucessfully with: %s.", netId, allocs)
+		mtnLog(ctx).Debugf("c.RequestAllocs(ctx, %s) end sucessfully with: %s.", netId, allocs)
 		b, errBk := tx.CreateBucketIfNotExists([]byte(netId))
 		if errBk != nil {
-			log.G(ctx).Errorf("Cant create bucket inside BindAllocs(), err: %s", errBk)
+			mtnLog(ctx).Errorf("Cant create bucket inside BindAllocs(), err: %s", errBk)
 			return errBk
 		}
 		for id, alloc := range allocs {
 			value, errMrsh := json.Marshal(alloc)
 			if errMrsh != nil {
-				log.G(ctx).Errorf("Cant Marshal(%s).", alloc)
+				mtnLog(ctx).Errorf("Cant Marshal(%s).", alloc)
 				return errMrsh
 			}
 			errPut := b.Put([]byte(id), value)
 			if errPut != nil {
-				log.G(ctx).Errorf("Cant b.Put(%s,%s)", id, value)
+				mtnLog(ctx).Errorf("Cant b.Put(%s,%s)", id, value)
 				return errPut
 			}
 		}
@@ -527,29 +534,39 @@ func (c *MtnState) BindAllocs(ctx context.Context, netId string) error {
 func (c *MtnState) UseAlloc(ctx context.Context, netId string, box string, ident string) (Allocation, error) {
 	c.AllocMu.Lock()
 	defer c.AllocMu.Unlock()
-	log.G(ctx).Debugf("UseAlloc() successfuly get lock for: %s %s %s.", netId, box, ident)
+	mtnLog(ctx).Debugf("UseAlloc() successfuly get lock for: %s %s %s.", netId, box, ident)
 	tx, errTx := c.Db.Begin(true)
 	if errTx != nil {
-		log.G(ctx).Errorf("Cant start transaction inside UseAlloc(), err: %s", errTx)
+		mtnLog(ctx).Errorf("Cant start transaction inside UseAlloc(), err: %s", errTx)
 		return Allocation{}, errTx
 	}
 	defer tx.Rollback()
 	a, e := c.GetDbAlloc(ctx, tx, netId, box)
-	log.G(ctx).Debugf("UseAlloc(): a, e: %s, %s. By %s.", a, e, ident)
+	mtnLog(ctx).Debugf("UseAlloc(): a, e: %s, %s. By %s.", a, e, ident)
 	if e != nil {
 		return Allocation{}, e
 	}
 	if err := tx.Commit(); err != nil {
 		return a, err
 	}
+	events.Publish(events.Event{
+		Type:  events.MtnAllocationUsed,
+		Box:   BoxName(ctx, box),
+		Attrs: map[string]string{"netid": netId, "id": a.Id, "ip": a.Ip, "ident": ident},
+	})
 	return a, nil
 }
 
 func (c *MtnState) UnuseAlloc(ctx context.Context, netId string, id string, ident string) {
 	err := c.FreeDbAlloc(ctx, netId, id)
 	if err != nil {
-		log.G(ctx).Errorf("BUG inside FreeDbAlloc()! error returned: %s.", err)
-	}
-	log.G(ctx).Debugf("UnuseAlloc() successfuly for: %s %s %s.", netId, id, ident)
+		mtnLog(ctx).Errorf("BUG inside FreeDbAlloc()! error returned: %s.", err)
+	}
+	events.Publish(events.Event{
+		Type:  events.MtnAllocationFreed,
+		Box:   BoxName(ctx, ""),
+		Attrs: map[string]string{"netid": netId, "id": id, "ident": ident},
+	}.WithError(err))
+	mtnLog(ctx).Debugf("UnuseAlloc() successfuly for: %s %s %s.", netId, id, ident)
 }
 
@@ -2,6 +2,7 @@ package isolate
 
 import (
 	"fmt"
+	"sort"
 
 	"golang.org/x/net/context"
 )
@@ -18,6 +19,16 @@ func RegisterBox(name string, constructor BoxConstructor, args ...GlobalState) {
 	plugins[name] = constructor
 }
 
+// RegisteredBoxes returns sorted names of available isolate plugins
+func RegisteredBoxes() []string {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // ConstructBox creates new Box
 func ConstructBox(ctx context.Context, name string, cfg BoxConfig, state GlobalState) (Box, error) {
 	constructor, ok := plugins[name]
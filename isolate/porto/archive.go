@@ -0,0 +1,412 @@
+package porto
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apexlog "github.com/apex/log"
+	"github.com/docker/distribution"
+	distctx "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema2"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	// unpacked archives are placed in Layers, the blob repository ignores directories
+	archiveDirPrefix = "archive-"
+
+	annotationRefName = "org.opencontainers.image.ref.name"
+)
+
+// unpackArchive extracts a tarball, optionally gzipped, to a new directory in dir
+func unpackArchive(path string, dir string) (_ string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	root, err := ioutil.TempDir(dir, archiveDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(root)
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return "", fmt.Errorf("archive entry %q is outside of the archive", hdr.Name)
+		}
+		// an entry written through a symlink of the archive lands where the symlink points
+		if err = checkParents(root, name); err != nil {
+			return "", err
+		}
+		target := filepath.Join(root, name)
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr)
+		case tar.TypeSymlink:
+			// docker save links layers shared by images
+			if !linkInside(name, hdr.Linkname) {
+				return "", fmt.Errorf("archive link %q points outside of the archive", hdr.Name)
+			}
+			err = os.Symlink(hdr.Linkname, target)
+		default:
+			// devices, fifos and hardlinks are never a part of image layouts
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// checkParents fails if a parent directory of the entry is a symlink
+func checkParents(root, name string) error {
+	dir := root
+	parts := strings.Split(filepath.Dir(name), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %q is placed under a symlink", name)
+		}
+	}
+	return nil
+}
+
+// linkInside tells if the link of the entry stays in the archive. The link may only climb up
+// parent directories of the entry and then descend, so it never steps back through another symlink
+func linkInside(name, link string) bool {
+	if filepath.IsAbs(link) {
+		return false
+	}
+	depth := 0
+	if dir := filepath.Dir(name); dir != "." {
+		depth = len(strings.Split(dir, string(filepath.Separator)))
+	}
+	descended := false
+	for _, part := range strings.Split(link, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if descended || depth == 0 {
+				return false
+			}
+			depth--
+		default:
+			descended = true
+		}
+	}
+	return true
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// imageLayout is an unpacked docker save archive or an OCI image layout
+type imageLayout struct {
+	dir string
+	// Digest of the manifest. Archives of docker save have no manifests
+	Digest digest.Digest
+	// Layers are ordered from the base one
+	Layers []distribution.Descriptor
+	// files of blobs relative to dir
+	blobs map[digest.Digest]string
+}
+
+// readImageLayout finds the image in the directory. A layout with several images is
+// looked up by the image name or its tag, an image index is resolved for the platform
+func readImageLayout(dir string, image string, p platform) (*imageLayout, error) {
+	l := &imageLayout{dir: dir, blobs: make(map[digest.Digest]string)}
+	if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err == nil {
+		return l, l.readDockerArchive(image)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return l, l.readOCILayout(image, p)
+	}
+	return nil, fmt.Errorf("neither manifest.json of docker save nor index.json of OCI layout is found")
+}
+
+func (l *imageLayout) readJSON(name string, v interface{}) error {
+	body, err := ioutil.ReadFile(filepath.Join(l.dir, name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// imageTag returns the digest or the tag of the image, latest by default
+func imageTag(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+// withTag appends the default tag to an image without a tag or a digest
+func withTag(image string) string {
+	if imageTag(image) == "latest" && !strings.HasSuffix(image, ":latest") {
+		return image + ":latest"
+	}
+	return image
+}
+
+func (l *imageLayout) readDockerArchive(image string) error {
+	var manifests []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	if err := l.readJSON("manifest.json", &manifests); err != nil {
+		return err
+	}
+
+	var index = -1
+	if len(manifests) == 1 {
+		index = 0
+	}
+	for i, m := range manifests {
+		for _, repoTag := range m.RepoTags {
+			if repoTag == withTag(image) || strings.HasSuffix(repoTag, "/"+withTag(image)) {
+				index = i
+			}
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("image %s is not found in the archive", image)
+	}
+	m := manifests[index]
+
+	var config struct {
+		RootFS struct {
+			DiffIDs []digest.Digest `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if err := l.readJSON(m.Config, &config); err != nil {
+		return err
+	}
+	if len(config.RootFS.DiffIDs) != len(m.Layers) {
+		return fmt.Errorf("config of the image has %d layers, the archive has %d", len(config.RootFS.DiffIDs), len(m.Layers))
+	}
+
+	for i, layer := range m.Layers {
+		// layers of recent Docker are OCI blobs, legacy ones are tarballs hashed as diff ids
+		dgst := config.RootFS.DiffIDs[i]
+		if parts := strings.Split(layer, "/"); len(parts) == 3 && parts[0] == "blobs" {
+			dgst = digest.Digest(parts[1] + ":" + parts[2])
+		}
+		if err := dgst.Validate(); err != nil {
+			return err
+		}
+		l.blobs[dgst] = layer
+		l.Layers = append(l.Layers, distribution.Descriptor{MediaType: schema2.MediaTypeLayer, Digest: dgst})
+	}
+	return nil
+}
+
+func (l *imageLayout) readOCILayout(image string, p platform) error {
+	var index struct {
+		Manifests []struct {
+			distribution.Descriptor
+			Annotations map[string]string `json:"annotations,omitempty"`
+		} `json:"manifests"`
+	}
+	if err := l.readJSON("index.json", &index); err != nil {
+		return err
+	}
+
+	tag := imageTag(image)
+	var descriptor *distribution.Descriptor
+	if len(index.Manifests) == 1 {
+		descriptor = &index.Manifests[0].Descriptor
+	}
+	for i, m := range index.Manifests {
+		if ref := m.Annotations[annotationRefName]; ref == tag || ref == withTag(image) || m.Digest.String() == tag {
+			descriptor = &index.Manifests[i].Descriptor
+		}
+	}
+	if descriptor == nil {
+		return fmt.Errorf("image %s is not found in the layout", image)
+	}
+	l.Digest = descriptor.Digest
+
+	m, err := l.manifest(*descriptor)
+	for depth := 0; err == nil; depth++ {
+		indexManifest, ok := m.(*manifestIndex)
+		if !ok {
+			break
+		}
+		if depth >= maxManifestIndexDepth {
+			return fmt.Errorf("manifest index of %s is nested too deep", image)
+		}
+		var dgst digest.Digest
+		if dgst, err = indexManifest.Select(p); err != nil {
+			return err
+		}
+		for _, d := range indexManifest.Manifests {
+			if d.Digest == dgst {
+				m, err = l.manifest(d.Descriptor)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	switch m.(type) {
+	case *schema2.DeserializedManifest, *ociManifest:
+	default:
+		return fmt.Errorf("unsupported manifest type %T in the layout", m)
+	}
+	if err = checkLayers(m.References()); err != nil {
+		return err
+	}
+	for _, layer := range m.References() {
+		if err = l.addBlob(layer.Digest); err != nil {
+			return err
+		}
+		l.Layers = append(l.Layers, layer)
+	}
+	return nil
+}
+
+// addBlob adds the file blobs/<algorithm>/<hex> of an OCI layout
+func (l *imageLayout) addBlob(dgst digest.Digest) error {
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	l.blobs[dgst] = filepath.Join("blobs", string(dgst.Algorithm()), dgst.Hex())
+	return nil
+}
+
+// manifest reads and verifies the manifest of the descriptor
+func (l *imageLayout) manifest(descriptor distribution.Descriptor) (distribution.Manifest, error) {
+	if err := l.addBlob(descriptor.Digest); err != nil {
+		return nil, err
+	}
+	body, err := l.Get(context.Background(), descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+	m, _, err := distribution.UnmarshalManifest(descriptor.MediaType, body)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyManifest(m, descriptor.Digest); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Get implements distribution.BlobProvider
+func (l *imageLayout) Get(ctx distctx.Context, dgst digest.Digest) ([]byte, error) {
+	path, ok := l.blobs[dgst]
+	if !ok {
+		return nil, distribution.ErrBlobUnknown
+	}
+	return ioutil.ReadFile(filepath.Join(l.dir, path))
+}
+
+// Open implements distribution.BlobProvider
+func (l *imageLayout) Open(ctx distctx.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
+	path, ok := l.blobs[dgst]
+	if !ok {
+		return nil, distribution.ErrBlobUnknown
+	}
+	return os.Open(filepath.Join(l.dir, path))
+}
+
+// getLayersFromSource spools the app from a local archive or directory
+func (b *Box) getLayersFromSource(ctx context.Context, name string, profile Profile) error {
+	path, err := isolate.SourcePath(profile.Source)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	dir := path
+	if !fi.IsDir() {
+		if dir, err = unpackArchive(path, b.config.Layers); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	image := imageName(name, profile)
+	layout, err := readImageLayout(dir, image, b.platform)
+	if err != nil {
+		return err
+	}
+	log.G(ctx).WithFields(apexlog.Fields{"name": name, "source": profile.Source, "digest": layout.Digest}).Info("image has been found in the source")
+
+	return b.importImage(ctx, name, layout, layerOrderV2(layout.Layers), imageRecord{
+		Reference: profile.Source,
+		Digest:    layout.Digest.String(),
+	})
+}
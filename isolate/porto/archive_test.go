@@ -0,0 +1,165 @@
+package porto
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type archiveEntry struct {
+	name, link string
+	body       []byte
+}
+
+func writeArchive(t *testing.T, path string, gzipped bool, entries ...archiveEntry) {
+	var buff bytes.Buffer
+	tw := tar.NewWriter(&buff)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write(e.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	body := buff.Bytes()
+	if gzipped {
+		var gzBuff bytes.Buffer
+		gz := gzip.NewWriter(&gzBuff)
+		gz.Write(body)
+		gz.Close()
+		body = gzBuff.Bytes()
+	}
+	require.NoError(t, ioutil.WriteFile(path, body, 0644))
+}
+
+func TestDockerArchive(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	base, top := []byte("base layer"), []byte("top layer")
+	config := fmt.Sprintf(`{"rootfs":{"type":"layers","diff_ids":[%q,%q]}}`, digest.FromBytes(base), digest.FromBytes(top))
+	manifest := `[
+		{"Config":"other.json","RepoTags":["other:latest"],"Layers":["base/layer.tar"]},
+		{"Config":"config.json","RepoTags":["registry.net/app:1.0"],"Layers":["base/layer.tar","top/layer.tar"]}
+	]`
+	archive := filepath.Join(dir, "app.tar.gz")
+	writeArchive(t, archive, true,
+		archiveEntry{name: "manifest.json", body: []byte(manifest)},
+		archiveEntry{name: "config.json", body: []byte(config)},
+		archiveEntry{name: "shared/layer.tar", body: base},
+		archiveEntry{name: "base/layer.tar", link: "../shared/layer.tar"},
+		archiveEntry{name: "top/layer.tar", body: top},
+	)
+
+	unpacked, err := unpackArchive(archive, dir)
+	require.NoError(err)
+	require.True(strings.HasPrefix(filepath.Base(unpacked), archiveDirPrefix))
+
+	_, err = readImageLayout(unpacked, "app", platform{})
+	require.EqualError(err, "image app is not found in the archive")
+
+	layout, err := readImageLayout(unpacked, "app:1.0", platform{})
+	require.NoError(err)
+	require.Equal(digest.Digest(""), layout.Digest)
+	require.Len(layout.Layers, 2)
+	require.Equal(digest.FromBytes(base), layout.Layers[0].Digest)
+
+	// shared layers are read through links
+	content, err := layout.Get(context.Background(), digest.FromBytes(base))
+	require.NoError(err)
+	require.Equal(base, content)
+	rsc, err := layout.Open(context.Background(), digest.FromBytes(top))
+	require.NoError(err)
+	content, err = ioutil.ReadAll(rsc)
+	rsc.Close()
+	require.NoError(err)
+	require.Equal(top, content)
+}
+
+func TestOCILayout(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "layout")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	writeBlob := func(body []byte) digest.Digest {
+		dgst := digest.FromBytes(body)
+		path := filepath.Join(dir, "blobs", string(dgst.Algorithm()), dgst.Hex())
+		require.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(ioutil.WriteFile(path, body, 0644))
+		return dgst
+	}
+
+	layer := writeBlob([]byte("layer"))
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q},"layers":[{"mediaType":%q,"digest":%q}]}`,
+		mediaTypeOCIManifest, layer, mediaTypeOCILayerGzip, layer))
+	manifestDigest := writeBlob(manifest)
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"mediaType":%q,"digest":%q,"platform":{"os":"linux","architecture":"amd64"}}]}`,
+		mediaTypeOCIIndex, mediaTypeOCIManifest, manifestDigest))
+	indexDigest := writeBlob(index)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "index.json"), []byte(fmt.Sprintf(
+		`{"schemaVersion":2,"manifests":[{"mediaType":%q,"digest":%q,"annotations":{%q:"1.0"}},{"mediaType":%q,"digest":%q,"annotations":{%q:"2.0"}}]}`,
+		mediaTypeOCIIndex, indexDigest, annotationRefName, mediaTypeOCIManifest, manifestDigest, annotationRefName)), 0644))
+
+	// the tag points to an index resolved for the platform
+	layout, err := readImageLayout(dir, "app:1.0", platform{OS: "linux", Architecture: "amd64"})
+	require.NoError(err)
+	require.Equal(indexDigest, layout.Digest)
+	require.Len(layout.Layers, 1)
+	require.Equal(layer, layout.Layers[0].Digest)
+
+	_, err = readImageLayout(dir, "app:1.0", platform{OS: "linux", Architecture: "arm64"})
+	require.EqualError(err, "no image for platform linux/arm64, available: linux/amd64")
+
+	layout, err = readImageLayout(dir, "app@"+manifestDigest.String(), platform{})
+	require.NoError(err)
+	require.Equal(manifestDigest, layout.Digest)
+
+	_, err = readImageLayout(dir, "app", platform{})
+	require.EqualError(err, "image app is not found in the layout")
+}
+
+func TestUnpackArchiveRejectsEscapes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "evil.tar")
+	writeArchive(t, archive, false, archiveEntry{name: "../evil", body: []byte("evil")})
+	_, err = unpackArchive(archive, dir)
+	require.EqualError(t, err, `archive entry "../evil" is outside of the archive`)
+
+	writeArchive(t, archive, false, archiveEntry{name: "layer.tar", link: "../../etc/passwd"})
+	_, err = unpackArchive(archive, dir)
+	require.EqualError(t, err, `archive link "layer.tar" points outside of the archive`)
+
+	// lexically c is the root, but b/.. is resolved on disk from where b points
+	writeArchive(t, archive, false, archiveEntry{name: "b", link: "."}, archiveEntry{name: "c", link: "b/.."})
+	_, err = unpackArchive(archive, dir)
+	require.EqualError(t, err, `archive link "c" points outside of the archive`)
+
+	writeArchive(t, archive, false, archiveEntry{name: "c", link: "."}, archiveEntry{name: "c/evil", body: []byte("evil")})
+	_, err = unpackArchive(archive, dir)
+	require.EqualError(t, err, `archive entry "c/evil" is placed under a symlink`)
+
+	// unpacked directories are removed on failures
+	entries, err := filepath.Glob(filepath.Join(dir, archiveDirPrefix+"*"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
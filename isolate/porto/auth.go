@@ -0,0 +1,363 @@
+package porto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	apexlog "github.com/apex/log"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+	"github.com/noxiouz/stout/pkg/secret"
+)
+
+const (
+	// the token specification defaults the lifetime to 60 seconds
+	defaultTokenExpiresIn = 60
+	// tokens are refreshed a bit earlier to survive a request in flight
+	tokenExpiryMargin = 5 * time.Second
+
+	tokenClientID = "stout"
+)
+
+// registryCredentials authenticate against a token server of a registry
+type registryCredentials struct {
+	Username secret.String `json:"username"`
+	Password secret.String `json:"password"`
+	// RefreshToken is exchanged for tokens with OAuth2 grant_type=refresh_token
+	RefreshToken secret.String `json:"refreshtoken"`
+}
+
+// identity distinguishes tokens obtained with different credentials without keeping them in keys
+func (c registryCredentials) identity() string {
+	sum := sha256.Sum256([]byte(c.Username.Value() + "\x00" + c.Password.Value() + "\x00" + c.RefreshToken.Value()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// authChallenge is a parsed WWW-Authenticate header
+type authChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// parseAuthChallenges parses headers like `Bearer realm="https://auth/token",service="registry",scope="a,b"`
+func parseAuthChallenges(header http.Header) []authChallenge {
+	var challenges []authChallenge
+	for _, value := range header["Www-Authenticate"] {
+		value = strings.TrimSpace(value)
+		scheme := value
+		rest := ""
+		if i := strings.IndexByte(value, ' '); i >= 0 {
+			scheme, rest = value[:i], value[i+1:]
+		}
+		challenges = append(challenges, authChallenge{
+			Scheme: strings.ToLower(scheme),
+			Params: parseAuthParams(rest),
+		})
+	}
+	return challenges
+}
+
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			value, s = unquote(s[1:])
+		} else {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				j = len(s)
+			}
+			value, s = strings.TrimSpace(s[:j]), s[j:]
+		}
+		params[key] = value
+	}
+}
+
+// unquote reads a quoted-string without the opening quote and returns the rest after the closing one
+func unquote(s string) (value, rest string) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			b = append(b, s[i])
+		case c == '"':
+			return string(b), s[i+1:]
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b), ""
+}
+
+type bearerToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// tokenCache keeps bearer tokens of a box per realm, service, credentials and scope,
+// and challenges of registries to authorize requests before getting 401
+type tokenCache struct {
+	client *http.Client
+
+	mu         sync.Mutex
+	tokens     map[string]*bearerToken
+	challenges map[string]authChallenge
+	// refresh tokens issued by token servers per realm, service and credentials
+	refreshTokens map[string]string
+}
+
+func newTokenCache(tr http.RoundTripper) *tokenCache {
+	return &tokenCache{
+		client:        &http.Client{Transport: tr, Timeout: 30 * time.Second},
+		tokens:        make(map[string]*bearerToken),
+		challenges:    make(map[string]authChallenge),
+		refreshTokens: make(map[string]string),
+	}
+}
+
+func (c *tokenCache) challenge(host string) (authChallenge, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.challenges[host]
+	return ch, ok
+}
+
+func (c *tokenCache) setChallenge(host string, ch authChallenge) {
+	c.mu.Lock()
+	c.challenges[host] = ch
+	c.mu.Unlock()
+}
+
+func (c *tokenCache) entry(key string) *bearerToken {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.tokens[key]
+	if !ok {
+		entry = &bearerToken{}
+		c.tokens[key] = entry
+	}
+	return entry
+}
+
+// Token returns a cached token of the scope or fetches a new one.
+// Concurrent callers of the same scope wait for one fetch
+func (c *tokenCache) Token(ctx context.Context, ch authChallenge, scope string, creds registryCredentials, force bool) (string, error) {
+	realm, service := ch.Params["realm"], ch.Params["service"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge has no realm")
+	}
+	entry := c.entry(realm + "|" + service + "|" + creds.identity() + "|" + scope)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if !force && entry.token != "" && time.Now().Before(entry.expires) {
+		return entry.token, nil
+	}
+
+	token, expires, err := c.fetch(ctx, realm, service, scope, creds)
+	if err != nil {
+		registryTokenErrorsCounter.Inc(1)
+		return "", err
+	}
+	registryTokenFetchesCounter.Inc(1)
+	entry.token, entry.expires = token, expires
+	return token, nil
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (c *tokenCache) fetch(ctx context.Context, realm, service, scope string, creds registryCredentials) (token string, expires time.Time, err error) {
+	defer log.G(ctx).WithFields(apexlog.Fields{"realm": realm, "service": service, "scope": scope}).Trace("fetch registry token").Stop(&err)
+
+	refreshKey := realm + "|" + service + "|" + creds.identity()
+	c.mu.Lock()
+	refreshToken := c.refreshTokens[refreshKey]
+	c.mu.Unlock()
+	if refreshToken == "" {
+		refreshToken = creds.RefreshToken.Value()
+	}
+
+	var req *http.Request
+	if refreshToken != "" {
+		form := url.Values{
+			"grant_type":    []string{"refresh_token"},
+			"refresh_token": []string{refreshToken},
+			"service":       []string{service},
+			"client_id":     []string{tokenClientID},
+		}
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		req, err = http.NewRequest("POST", realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequest("GET", realm, nil)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		query := req.URL.Query()
+		if service != "" {
+			query.Set("service", service)
+		}
+		if scope != "" {
+			query.Set("scope", scope)
+		}
+		if creds.Username != "" {
+			query.Set("account", creds.Username.Value())
+			req.SetBasicAuth(creds.Username.Value(), creds.Password.Value())
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", time.Time{}, fmt.Errorf("token server %s replied %s: %s", realm, resp.Status, secret.Redact(string(body)))
+	}
+
+	var tr tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", time.Time{}, err
+	}
+	token = tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+	if token == "" {
+		return "", time.Time{}, fmt.Errorf("token server %s replied without a token", realm)
+	}
+	if tr.RefreshToken != "" {
+		c.mu.Lock()
+		c.refreshTokens[refreshKey] = tr.RefreshToken
+		c.mu.Unlock()
+	}
+
+	// issued_at is ignored, clocks of the token server and the box may differ
+	expiresIn := tr.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = defaultTokenExpiresIn
+	}
+	return token, time.Now().Add(time.Duration(expiresIn)*time.Second - tokenExpiryMargin), nil
+}
+
+// tokenTransport authorizes requests to a registry with bearer tokens scoped to one repository.
+// It learns the token server from a 401 challenge and retries the request once with a token.
+// Basic challenges are answered with the username and the password
+type tokenTransport struct {
+	base       http.RoundTripper
+	cache      *tokenCache
+	creds      registryCredentials
+	repository string
+}
+
+func newTokenTransport(base http.RoundTripper, cache *tokenCache, creds registryCredentials, repository string) *tokenTransport {
+	return &tokenTransport{base: base, cache: cache, creds: creds, repository: repository}
+}
+
+func (t *tokenTransport) scope(ch authChallenge) string {
+	if t.repository != "" {
+		return "repository:" + t.repository + ":pull"
+	}
+	return ch.Params["scope"]
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host := req.URL.Host
+
+	authorized := req
+	if ch, ok := t.cache.challenge(host); ok {
+		if r, err := t.authorize(ctx, req, ch, false); err == nil {
+			authorized = r
+		} else {
+			log.G(ctx).WithError(err).WithField("registry", host).Warn("unable to authorize request to registry")
+		}
+	}
+
+	resp, err := t.base.RoundTrip(authorized)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// the body can't be sent again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	for _, ch := range parseAuthChallenges(resp.Header) {
+		if ch.Scheme != "bearer" && (ch.Scheme != "basic" || t.creds.Username == "") {
+			continue
+		}
+		retry, err := t.authorize(ctx, req, ch, authorized != req)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("registry", host).Warn("unable to authorize request to registry")
+			return resp, nil
+		}
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				return resp, nil
+			}
+		}
+		t.cache.setChallenge(host, ch)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return t.base.RoundTrip(retry)
+	}
+	return resp, nil
+}
+
+// authorize returns a copy of the request with Authorization set according to the challenge
+func (t *tokenTransport) authorize(ctx context.Context, req *http.Request, ch authChallenge, force bool) (*http.Request, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+
+	switch ch.Scheme {
+	case "basic":
+		r.SetBasicAuth(t.creds.Username.Value(), t.creds.Password.Value())
+	case "bearer":
+		token, err := t.cache.Token(ctx, ch, t.scope(ch), t.creds, force)
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return nil, fmt.Errorf("unsupported auth scheme %s", ch.Scheme)
+	}
+	return r, nil
+}
@@ -0,0 +1,179 @@
+package porto
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAuthChallenges(t *testing.T) {
+	header := http.Header{}
+	header.Add("WWW-Authenticate", `Bearer realm="https://auth.example.net/token",service="registry.example.net",scope="repository:app:pull,push"`)
+	header.Add("WWW-Authenticate", `Basic realm=registry`)
+
+	challenges := parseAuthChallenges(header)
+	require.Len(t, challenges, 2)
+	require.Equal(t, "bearer", challenges[0].Scheme)
+	require.Equal(t, map[string]string{
+		"realm":   "https://auth.example.net/token",
+		"service": "registry.example.net",
+		"scope":   "repository:app:pull,push",
+	}, challenges[0].Params)
+	require.Equal(t, "basic", challenges[1].Scheme)
+	require.Equal(t, "registry", challenges[1].Params["realm"])
+}
+
+type fakeTokenServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	issued    int
+	expiresIn int
+	scopes    []string
+	grants    []string
+}
+
+func newFakeTokenServer() *fakeTokenServer {
+	s := &fakeTokenServer{expiresIn: 300}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.Form.Get("grant_type") {
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		default:
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		s.issued++
+		s.scopes = append(s.scopes, r.Form.Get("scope"))
+		s.grants = append(s.grants, r.Form.Get("grant_type"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      fmt.Sprintf("token-%d", s.issued),
+			"expires_in": s.expiresIn,
+		})
+	}))
+	return s
+}
+
+func newFakeRegistry(realm string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer token-") && auth[:13] == "Bearer token-" {
+			fmt.Fprint(w, auth[7:])
+			return
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="registry"`, realm))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body [64]byte
+	n, _ := resp.Body.Read(body[:])
+	return resp.StatusCode, string(body[:n])
+}
+
+func TestTokenTransport(t *testing.T) {
+	require := require.New(t)
+	tokens := newFakeTokenServer()
+	defer tokens.Close()
+	registry := newFakeRegistry(tokens.URL)
+	defer registry.Close()
+
+	cache := newTokenCache(http.DefaultTransport)
+	creds := registryCredentials{Username: "user", Password: "password"}
+	client := &http.Client{Transport: newTokenTransport(http.DefaultTransport, cache, creds, "app/worker")}
+
+	// the first request learns the challenge
+	code, body := get(t, client, registry.URL+"/v2/app/worker/manifests/latest")
+	require.Equal(http.StatusOK, code)
+	require.Equal("token-1", body)
+
+	// the token is cached
+	code, body = get(t, client, registry.URL+"/v2/app/worker/blobs/sha256:abc")
+	require.Equal(http.StatusOK, code)
+	require.Equal("token-1", body)
+	require.Equal([]string{"repository:app/worker:pull"}, tokens.scopes)
+
+	// tokens are scoped per repository
+	other := &http.Client{Transport: newTokenTransport(http.DefaultTransport, cache, creds, "app/other")}
+	code, body = get(t, other, registry.URL+"/v2/app/other/manifests/latest")
+	require.Equal(http.StatusOK, code)
+	require.Equal("token-2", body)
+	require.Equal([]string{"repository:app/worker:pull", "repository:app/other:pull"}, tokens.scopes)
+}
+
+func TestTokenTransportRefreshesExpired(t *testing.T) {
+	require := require.New(t)
+	tokens := newFakeTokenServer()
+	defer tokens.Close()
+	// shorter than the margin, so every token is expired at once
+	tokens.expiresIn = 1
+	registry := newFakeRegistry(tokens.URL)
+	defer registry.Close()
+
+	cache := newTokenCache(http.DefaultTransport)
+	creds := registryCredentials{RefreshToken: "refresh"}
+	client := &http.Client{Transport: newTokenTransport(http.DefaultTransport, cache, creds, "app")}
+
+	for i := 1; i <= 3; i++ {
+		code, body := get(t, client, registry.URL+"/v2/app/manifests/latest")
+		require.Equal(http.StatusOK, code)
+		require.Equal(fmt.Sprintf("token-%d", i), body)
+	}
+	require.Equal([]string{"refresh_token", "refresh_token", "refresh_token"}, tokens.grants)
+}
+
+func TestTokenTransportBadCredentials(t *testing.T) {
+	tokens := newFakeTokenServer()
+	defer tokens.Close()
+	registry := newFakeRegistry(tokens.URL)
+	defer registry.Close()
+
+	cache := newTokenCache(http.DefaultTransport)
+	creds := registryCredentials{Username: "user", Password: "wrong"}
+	client := &http.Client{Transport: newTokenTransport(http.DefaultTransport, cache, creds, "app")}
+
+	// the original reply is returned
+	code, _ := get(t, client, registry.URL+"/v2/app/manifests/latest")
+	require.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTokenTransportSeparatesCredentials(t *testing.T) {
+	require := require.New(t)
+	tokens := newFakeTokenServer()
+	defer tokens.Close()
+	registry := newFakeRegistry(tokens.URL)
+	defer registry.Close()
+
+	cache := newTokenCache(http.DefaultTransport)
+	creds := registryCredentials{Username: "user", Password: "password"}
+	client := &http.Client{Transport: newTokenTransport(http.DefaultTransport, cache, creds, "app")}
+	code, body := get(t, client, registry.URL+"/v2/app/manifests/latest")
+	require.Equal(http.StatusOK, code)
+	require.Equal("token-1", body)
+
+	// the token of other credentials isn't reused
+	anonymous := &http.Client{Transport: newTokenTransport(http.DefaultTransport, cache, registryCredentials{}, "app")}
+	code, _ = get(t, anonymous, registry.URL+"/v2/app/manifests/latest")
+	require.Equal(http.StatusUnauthorized, code)
+	require.Equal(1, tokens.issued)
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
@@ -22,17 +21,18 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/events"
 	"github.com/noxiouz/stout/pkg/log"
+	"github.com/noxiouz/stout/pkg/mirror"
+	"github.com/noxiouz/stout/pkg/secret"
 	"github.com/noxiouz/stout/pkg/semaphore"
 
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/distribution/reference"
-	"github.com/docker/distribution/registry/client"
-	"github.com/docker/distribution/registry/client/transport"
 	engineref "github.com/docker/engine-api/types/reference"
-	porto "github.com/yandex/porto/src/api/go"
-	portorpc "github.com/yandex/porto/src/api/go/rpc"
 )
 
 type portoBoxConfig struct {
@@ -43,19 +43,44 @@ type portoBoxConfig struct {
 	// Path to a journal file
 	Journal string `json:"journal"`
 
-	SpawnConcurrency      uint              `json:"concurrency"`
-	RegistryAuth          map[string]string `json:"registryauth"`
-	DialRetries           int               `json:"dialretries"`
-	CleanupEnabled        bool              `json:"cleanupenabled"`
-	SetImgURI             bool              `json:"setimguri"`
-	WeakEnabled           bool              `json:"weakenabled"`
-	Gc                    bool              `json:"gc"`
-	WaitLoopStepSec       uint              `json:"waitloopstepsec"`
-	DefaultUlimits        string            `json:"defaultulimits"`
-	VolumeBackend         string            `json:"volumebackend"`
-	DefaultResolvConf     string            `json:"defaultresolv_conf"`
-	CocaineAppVolumeLabel string            `json:"cocaineappvolumelabel"`
-	DownloadHelperCmd     string            `json:"download_helper_cmd",omitempty`
+	SpawnConcurrency uint                     `json:"concurrency"`
+	RegistryAuth     map[string]secret.String `json:"registryauth"`
+	// Credentials for token servers of registries without static RegistryAuth
+	RegistryCredentials map[string]registryCredentials `json:"registrycredentials"`
+	// Mirrors of registries and breakers of unhealthy ones
+	RegistryMirrors mirror.Config `json:"registrymirrors"`
+	DialRetries     int           `json:"dialretries"`
+	CleanupEnabled  bool          `json:"cleanupenabled"`
+	SetImgURI       bool          `json:"setimguri"`
+	WeakEnabled     bool          `json:"weakenabled"`
+	Gc              bool          `json:"gc"`
+	WaitLoopStepSec uint          `json:"waitloopstepsec"`
+	// Containers spawned while the tracker waits for deaths are tracked after the timeout
+	TrackWaitTimeoutMs    uint   `json:"trackwaittimeoutms"`
+	DefaultUlimits        string `json:"defaultulimits"`
+	VolumeBackend         string `json:"volumebackend"`
+	DefaultResolvConf     string `json:"defaultresolv_conf"`
+	CocaineAppVolumeLabel string `json:"cocaineappvolumelabel"`
+	DownloadHelperCmd     string `json:"download_helper_cmd",omitempty`
+	// Free space in Layers and Containers required by the readiness probe
+	MinFreeSpace uint64 `json:"minfreespace"`
+	// Pool of connections to Portod
+	Porto portoConnConfig `json:"porto"`
+	// Removal of unused manifests and layers
+	LayerGC layerGCConfig `json:"layergc"`
+	// Budget of downloaded blobs in Layers
+	BlobsMaxSize      uint64 `json:"blobsmaxsize"`
+	BlobsMinFreeSpace uint64 `json:"blobsminfreespace"`
+	BlobsFetchRetries int    `json:"blobsfetchretries"`
+	// Concurrent downloads of layers by the box and by one Spool
+	FetchConcurrency      uint `json:"fetchconcurrency"`
+	SpoolFetchConcurrency uint `json:"spoolfetchconcurrency"`
+	// Platform os/arch[/variant] of images selected from manifest lists. It's the host one by default
+	Platform string `json:"platform"`
+	// Adjusts SpawnConcurrency according to latency and errors of spawns
+	AdaptiveConcurrency semaphore.AdaptiveConfig `json:"adaptiveconcurrency"`
+	// Streaming of stdout and stderr of containers to the runtime
+	Output outputConfig `json:"output"`
 }
 
 func (c *portoBoxConfig) String() string {
@@ -72,17 +97,29 @@ func (c *portoBoxConfig) ContainerRootDir(name, containerID string) string {
 
 // Box operates with Porto to launch containers
 type Box struct {
-	Name        string
+	// Name marks MTN allocations of the box, it mustn't change between versions
+	Name string
+	// name of the box in the configuration, events are published with it
+	boxName     string
 	config      *portoBoxConfig
 	GlobalState isolate.GlobalState
 	journal     *journal
-
-	spawnSM      semaphore.Semaphore
-	transport    *http.Transport
-	muContainers sync.Mutex
-	containers   map[string]*container
-	blobRepo     BlobRepository
-	dhEnable     bool
+	journalFile *journalFile
+	staged      *stagedLayers
+
+	spawnSM        *semaphore.Weighted
+	spawnLimiter   *semaphore.Adaptive
+	fetchSM        *semaphore.Weighted
+	portoPool      *connPool
+	trackWakeup    chan struct{}
+	transport      *http.Transport
+	registryTokens *tokenCache
+	mirrors        *mirror.Pool
+	muContainers   sync.Mutex
+	containers     map[string]*container
+	blobRepo       BlobRepository
+	platform       platform
+	dhEnable       bool
 
 	rootPrefix string
 
@@ -97,19 +134,38 @@ const defaultVolumeBackend = "overlay"
 func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalState) (isolate.Box, error) {
 	log.G(ctx).Info("Porto Box Initiate")
 	var config = &portoBoxConfig{
-		SpawnConcurrency: 5,
-		DialRetries:      10,
-		WaitLoopStepSec:  10,
+		SpawnConcurrency:      5,
+		DialRetries:           10,
+		WaitLoopStepSec:       10,
+		TrackWaitTimeoutMs:    defaultTrackWaitTimeoutMs,
+		FetchConcurrency:      defaultFetchConcurrency,
+		SpoolFetchConcurrency: defaultSpoolFetchConcurrency,
+		LayerGC: layerGCConfig{
+			IntervalSec: defaultLayerGCIntervalSec,
+			MaxAgeSec:   defaultLayerGCMaxAgeSec,
+			Place:       defaultPortoPlace,
+		},
+		Output: outputConfig{
+			PollMs:    defaultOutputPollMs,
+			ReadChunk: defaultOutputReadChunk,
+		},
 
-		CleanupEnabled: true,
-		WeakEnabled:    false,
-		Gc:             true,
+		CleanupEnabled:        true,
+		WeakEnabled:           false,
+		Gc:                    true,
 		CocaineAppVolumeLabel: "cocaine-app",
+		MinFreeSpace:          isolate.DefaultMinFreeSpace,
+		Porto: portoConnConfig{
+			PoolSize:       defaultPortoPoolSize,
+			ConnectRetries: defaultPortoConnectRetries,
+			HealthCheckSec: defaultPortoHealthCheckSec,
+		},
 	}
 	decoderConfig := mapstructure.DecoderConfig{
 		WeaklyTypedInput: true,
 		Result:           config,
 		TagName:          "json",
+		DecodeHook:       secret.DecodeHook,
 	}
 	decoder, err := mapstructure.NewDecoder(&decoderConfig)
 	if err != nil {
@@ -135,17 +191,38 @@ func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalSta
 		config.VolumeBackend = defaultVolumeBackend
 	}
 
+	if config.FetchConcurrency == 0 {
+		config.FetchConcurrency = defaultFetchConcurrency
+	}
+
+	imagePlatform, err := parsePlatform(config.Platform)
+	if err != nil {
+		return nil, err
+	}
+
 	log.G(ctx).WithField("dir", config.Layers).Info("create directory for Layers")
 	if err = os.MkdirAll(config.Layers, 0755); err != nil {
 		return nil, err
 	}
 
+	// archives unpacked by spools interrupted by a restart
+	if stale, err := filepath.Glob(filepath.Join(config.Layers, archiveDirPrefix+"*")); err == nil {
+		for _, dir := range stale {
+			os.RemoveAll(dir)
+		}
+	}
+
 	log.G(ctx).WithField("dir", config.Containers).Info("create directory for Containers")
 	if err = os.MkdirAll(config.Containers, 0755); err != nil {
 		return nil, err
 	}
 
-	blobRepo, err := NewBlobRepository(ctx, BlobRepositoryConfig{SpoolPath: config.Layers})
+	blobRepo, err := NewBlobRepository(ctx, BlobRepositoryConfig{
+		SpoolPath:    config.Layers,
+		MaxSize:      config.BlobsMaxSize,
+		MinFreeSpace: config.BlobsMinFreeSpace,
+		FetchRetries: config.BlobsFetchRetries,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -173,14 +250,17 @@ func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalSta
 		ExpectContinueTimeout: 5 * time.Second,
 	}
 
-	portoConn, err := portoConnect()
+	portoPool := newConnPool(config.Porto)
+	portoConn, err := portoPool.Get(ctx)
 	if err != nil {
+		portoPool.Close()
 		return nil, err
 	}
 	defer portoConn.Close()
 
 	rootPrefix, err := portoConn.GetProperty("self", "absolute_name")
 	if err != nil {
+		portoPool.Close()
 		return nil, err
 	}
 	if rootPrefix == "/" {
@@ -197,17 +277,34 @@ func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalSta
 	log.G(ctx).Debugf("download_helper is %s: %s.", dhEnable, config.DownloadHelperCmd)
 
 	box := &Box{
-		Name:        name,
-		config:      config,
-		GlobalState: gstate,
-		journal:     newJournal(),
-		transport:   tr,
-		spawnSM:     semaphore.New(config.SpawnConcurrency),
-		containers:  make(map[string]*container),
-		onClose:     onClose,
-		rootPrefix:  rootPrefix,
-		dhEnable:    dhEnable,
-		blobRepo:    blobRepo,
+		Name:           name,
+		boxName:        isolate.BoxName(ctx, name),
+		config:         config,
+		GlobalState:    gstate,
+		journal:        newJournal(),
+		journalFile:    &journalFile{path: config.Journal},
+		staged:         newStagedLayers(),
+		transport:      tr,
+		registryTokens: newTokenCache(tr),
+		mirrors:        mirror.NewPool(config.RegistryMirrors, mirrorMetrics),
+		spawnSM:        semaphore.NewWeighted(int64(config.SpawnConcurrency), spawnSMMetrics),
+		fetchSM:        semaphore.NewWeighted(int64(config.FetchConcurrency), fetchSMMetrics),
+		portoPool:      portoPool,
+		trackWakeup:    make(chan struct{}, 1),
+		containers:     make(map[string]*container),
+		onClose:        onClose,
+		rootPrefix:     rootPrefix,
+		dhEnable:       dhEnable,
+		blobRepo:       blobRepo,
+		platform:       imagePlatform,
+	}
+
+	if config.AdaptiveConcurrency.Enabled {
+		box.spawnLimiter, err = semaphore.NewAdaptive(box.spawnSM, config.AdaptiveConcurrency, spawnAdaptiveMetrics)
+		if err != nil {
+			box.Close()
+			return nil, err
+		}
 	}
 
 	body, err := json.Marshal(config)
@@ -227,11 +324,18 @@ func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalSta
 	}
 
 	box.journal.UpdateFromPorto(layers)
+	// journals of older versions are migrated at once
+	if err = box.dumpJournal(ctx); err != nil {
+		log.G(ctx).WithError(err).Warn("unable to write the journal")
+	}
 
 	journalContent.Set(box.journal.String())
 
 	go box.waitLoop(ctx)
 	go box.dumpJournalEvery(ctx, time.Minute)
+	if config.LayerGC.Enabled {
+		go box.layerGCLoop(ctx)
+	}
 
 	return box, nil
 }
@@ -250,51 +354,28 @@ func (b *Box) dumpJournalEvery(ctx context.Context, every time.Duration) {
 
 func (b *Box) dumpJournal(ctx context.Context) (err error) {
 	defer log.G(ctx).Trace("dump journal").Stop(&err)
-	tempfile, err := ioutil.TempFile(filepath.Dir(b.config.Journal), "portojournalbak")
-	if err != nil {
-		return err
-	}
-	defer os.Remove(tempfile.Name())
-	defer tempfile.Close()
-
-	if err = b.journal.Dump(tempfile); err != nil {
-		return err
-	}
-
-	if err = os.Rename(tempfile.Name(), b.config.Journal); err != nil {
-		return err
-	}
-
-	return nil
+	return b.journalFile.Write(b.journal)
 }
 
 func (b *Box) loadJournal(ctx context.Context) error {
-	f, err := os.Open(b.config.Journal)
+	j, err := b.journalFile.Read(ctx)
 	if err != nil {
-		log.G(ctx).Warnf("unable to open Journal file: %v", err)
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
-	}
-	defer f.Close()
-
-	if err = b.journal.Load(f); err != nil {
 		log.G(ctx).WithError(err).Error("unable to load Journal")
 		return err
 	}
-
+	b.journal = j
 	return nil
 }
 
 func (b *Box) waitLoop(ctx context.Context) {
 	log.G(ctx).Info("start waitLoop")
+	// waitLoop keeps one connection of the pool while it's alive
 	var (
-		portoConn porto.API
+		portoConn *pooledConn
 		err       error
 	)
 
-	closed := func(portoConn porto.API) bool {
+	closed := func(portoConn *pooledConn) bool {
 		select {
 		case <-ctx.Done():
 			if portoConn != nil {
@@ -307,12 +388,12 @@ func (b *Box) waitLoop(ctx context.Context) {
 	}
 
 	log.G(ctx).Info("waitLoop: connect to Portod before gc")
-	portoConn, err = portoConnect()
+	portoConn, err = b.portoPool.Get(ctx)
 	if err != nil {
 		log.G(ctx).WithError(err).Warn("unable to connect to Portod")
 	}
 
-	if b.config.Gc {
+	if b.config.Gc && portoConn != nil {
 		// In future we can make another loop for gc with pattern checking like:
 		// rePattern, err := regexp.Compile("^.*_[0-9a-f]{6}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
 		// Now we just try clean trash one time without error handle.
@@ -323,18 +404,21 @@ func (b *Box) waitLoop(ctx context.Context) {
 		usedAllocations, stat, errUsedAllocs := b.GlobalState.Mtn.UsedAllocations(ctx)
 		if errUsedAllocs != nil {
 			log.G(ctx).Errorf("Cant get UsedAllocations(). Err: %s. Stat: %s.", errUsedAllocs, stat)
+			portoConn.Close()
 			return
 		}
 		log.G(ctx).Debugf("Allocation statistic: %s.", stat)
 		var ips []string
 		for _, name := range containerNames {
 			containerState, _ := portoConn.GetProperty(name, "state")
-			if containerState == "dead" {
-				log.G(ctx).Debugf("At gc state destroy dead container: %s", name)
-				portoConn.Destroy(name)
-			} else if containerState == "stopped" {
-				log.G(ctx).Debugf("At gc state destroy stopped container: %s", name)
-				portoConn.Destroy(name)
+			if containerState == "dead" || containerState == "stopped" {
+				log.G(ctx).Debugf("At gc state destroy %s container: %s", containerState, name)
+				err := portoConn.Destroy(name)
+				events.Publish(events.Event{
+					Type:  events.GCContainerDestroyed,
+					Box:   b.boxName,
+					Attrs: map[string]string{"container": name, "state": containerState},
+				}.WithError(err))
 			} else if containerState == "meta" {
 				continue
 			} else if containerState == "running" || containerState == "starting" {
@@ -363,6 +447,11 @@ func (b *Box) waitLoop(ctx context.Context) {
 				if usedAllocation.Box == b.Name {
 					log.G(ctx).Debugf("Try free alloc with b.GlobalState.Mtn.UnuseAlloc(ctx, %s, %s)", usedAllocation.NetId, usedAllocation.Id)
 					b.GlobalState.Mtn.UnuseAlloc(ctx, usedAllocation.NetId, usedAllocation.Id, "GC state")
+					events.Publish(events.Event{
+						Type:  events.GCAllocationFreed,
+						Box:   b.boxName,
+						Attrs: map[string]string{"netid": usedAllocation.NetId, "id": usedAllocation.Id, "ip": usedAllocation.Ip},
+					})
 				}
 			}
 		}
@@ -373,16 +462,19 @@ func (b *Box) waitLoop(ctx context.Context) {
 		} else {
 			for _, volume := range volumes {
 				if volume.Properties["Private"] == b.config.CocaineAppVolumeLabel {
-					portoConn.UnlinkVolume(volume.Path, "***")
+					err := portoConn.UnlinkVolume(volume.Path, "***")
+					events.Publish(events.Event{
+						Type:  events.GCVolumeUnlinked,
+						Box:   b.boxName,
+						Attrs: map[string]string{"volume": volume.Path},
+					}.WithError(err))
 				}
 			}
 		}
 	}
 
-LOOP:
+	tracker := newTracker(b)
 	for {
-		log.G(ctx).Debugf("next iteration of waitLoop will started after %d second of sleep.", b.config.WaitLoopStepSec)
-		time.Sleep(time.Duration(b.config.WaitLoopStepSec) * time.Second)
 		if closed(portoConn) {
 			return
 		}
@@ -390,68 +482,23 @@ LOOP:
 		// In case of error: wait either a fixed timeout or closing of Box
 		if portoConn == nil {
 			log.G(ctx).Info("waitLoop: connect to Portod")
-			portoConn, err = portoConnect()
+			portoConn, err = b.portoPool.Get(ctx)
 			if err != nil {
 				log.G(ctx).WithError(err).Warn("unable to connect to Portod")
 				select {
 				case <-time.After(time.Second):
-					continue LOOP
+					continue
 				case <-ctx.Done():
 					return
 				}
 			}
 		}
 
-		ourContainers := []string{}
-		b.muContainers.Lock()
-		for k, _ := range b.containers {
-			ourContainers = append(ourContainers, k)
-		}
-		b.muContainers.Unlock()
-		log.G(ctx).Debugf("That containers are being tracked now: %s", ourContainers)
-
-		for _, ourContainer := range ourContainers {
-			containerState, err := portoConn.GetProperty(ourContainer, "state")
-			if err != nil {
-				e := err.(*porto.Error)
-				switch e.ErrName {
-				case "ContainerDoesNotExist":
-					b.muContainers.Lock()
-					container, ok := b.containers[ourContainer]
-					if ok {
-						delete(b.containers, ourContainer)
-					}
-					rest := len(b.containers)
-					b.muContainers.Unlock()
-					if ok {
-						log.G(ctx).WithError(err).Errorf("We take ContainerDoesNotExist exception %s for exist container %s but try kill anyway.", err, ourContainer)
-						if err = container.Kill(); err != nil {
-							log.G(ctx).WithError(err).Debugf("catch at try kill ContainerDoesNotExist %s", ourContainer)
-						}
-					}
-					log.G(ctx).Debugf("%d containers are being tracked now after remove ContainerDoesNotExist %s", rest, ourContainer)
-				default:
-					portoConn.Close()
-					portoConn = nil
-					continue LOOP
-				}
-			}
-			if containerState == "dead" {
-				b.muContainers.Lock()
-				container, ok := b.containers[ourContainer]
-				if ok {
-					delete(b.containers, ourContainer)
-				}
-				rest := len(b.containers)
-				b.muContainers.Unlock()
-				log.G(ctx).Infof("%s container have status dead now.", ourContainer)
-				if ok {
-					if err = container.Kill(); err != nil {
-						log.G(ctx).WithError(err).Errorf("Killing %s error", ourContainer)
-					}
-				}
-				log.G(ctx).Infof("%d containers are being tracked now", rest)
-			}
+		if err = tracker.next(ctx, portoConn); err != nil {
+			log.G(ctx).WithError(err).Warn("unable to track containers, reconnect to Portod")
+			portoConn.Discard()
+			portoConn.Close()
+			portoConn = nil
 		}
 	}
 }
@@ -473,51 +520,69 @@ func (b *Box) addRootNamespacePrefix(container string) string {
 }
 
 // get layers from registy
-func (b *Box) getLayersViaDownloadHelper(ctx context.Context, name string, profile Profile) error {
-	layers := make([]string, 0)
-
-	portoConn, err := portoConnect()
+func (b *Box) getLayersViaDownloadHelper(ctx context.Context, name string, profile Profile) (err error) {
+	portoConn, err := b.portoPool.Get(ctx)
 	if err != nil {
 		log.G(ctx).WithError(err).WithField("name", name).Error("Porto connection error")
 		return err
 	}
 	defer portoConn.Close()
-	for _, layer := range profile.ExtendedInfo.Layers {
-		portoLayerName := fmt.Sprintf("%s_%s", layer.DigestType, layer.Digest)
-		wctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+
+	tx := b.beginSpool(name, portoConn)
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	infoLayers := profile.ExtendedInfo.Layers
+	fetch := func(ctx context.Context, i int) (string, error) {
+		layer := infoLayers[i]
+		wctx, cancel := context.WithTimeout(ctx, 1*time.Hour)
 		defer cancel()
-		timeout := fmt.Sprint(300 + uint(60 * (layer.Size / (100 * 1024 * 1024) )))
+		timeout := fmt.Sprint(300 + uint(60*(layer.Size/(100*1024*1024))))
 		cmd := exec.CommandContext(wctx, b.config.DownloadHelperCmd, "get", "-d", b.config.Layers,
 			"-t", timeout, layer.TorrentId)
 		stdoutStderr, err := cmd.CombinedOutput()
 		if err != nil {
 			log.G(ctx).WithError(err).WithField("name", name).Errorf("When download layer via download helper. output is: %s.", stdoutStderr)
-			return err
+			return "", err
 		}
 		blobPath := filepath.Join(b.config.Layers, layer.Digest)
 		f, err := os.Open(blobPath)
 		if err != nil {
-			return fmt.Errorf("ERROR when open layer %s for check hashsumm.", blobPath)
+			return "", fmt.Errorf("ERROR when open layer %s for check hashsumm.", blobPath)
 		}
 		defer f.Close()
 		hashSum := sha256.New()
 		if _, err := io.Copy(hashSum, f); err != nil {
-			return fmt.Errorf("ERROR when copy layer %s for check hashsumm.", blobPath)
+			return "", fmt.Errorf("ERROR when copy layer %s for check hashsumm.", blobPath)
 		}
 		digest := fmt.Sprintf("%x", hashSum.Sum(nil))
 		log.G(ctx).Debugf("Layer digest: %s; Layer sha256sum: %s;", layer.Digest, digest)
 		if digest != layer.Digest {
-			return fmt.Errorf("ERROR hashsum missmatch, hashSum.Sum(): %s, Digest: %s.", digest, layer.Digest)
+			return "", fmt.Errorf("ERROR hashsum missmatch, hashSum.Sum(): %s, Digest: %s.", digest, layer.Digest)
 		}
-		entry := log.G(ctx).WithField("layer", blobPath).Trace("Try to import layer")
-		err = portoConn.ImportLayer(portoLayerName, blobPath, false)
-		if err != nil && !isEqualPortoError(err, portorpc.EError_LayerAlreadyExists) {
-			entry.Stop(&err)
-			return err
-		}
-		layers = append(layers, portoLayerName)
+		return blobPath, nil
+	}
+	importLayer := func(i int, blobPath string) error {
+		layer := infoLayers[i]
+		portoLayerName := fmt.Sprintf("%s_%s", layer.DigestType, layer.Digest)
+		return tx.Import(ctx, portoLayerName, layer.DigestType+":"+layer.Digest, "", blobPath)
+	}
+	if err = b.fetchLayers(ctx, len(infoLayers), fetch, importLayer); err != nil {
+		return err
+	}
+	// the download helper gets layers without a manifest
+	var size int64
+	for _, layer := range infoLayers {
+		size += int64(layer.Size)
 	}
-	b.journal.InsertManifestLayers(name, strings.Join(layers, ";"))
+	tx.Commit(ctx, imageRecord{
+		Reference: imageName(name, profile),
+		Size:      size,
+		Spooled:   time.Now(),
+	})
 	return nil
 }
 
@@ -527,86 +592,123 @@ func (b *Box) getLayersViaRegistry(ctx context.Context, name string, profile Pro
 		log.G(ctx).WithField("name", name).Error("Registry must be non empty")
 		return fmt.Errorf("Registry must be non empty")
 	}
-	named, err := reference.ParseNamed(filepath.Join(profile.Repository, profile.Repository, name))
+	named, err := reference.ParseNamed(filepath.Join(profile.Repository, profile.Repository, imageName(name, profile)))
 	if err != nil {
 		log.G(ctx).WithError(err).WithField("name", name).Error("name is invalid")
 		return err
 	}
-	var tr http.RoundTripper
-	if registryAuth, ok := b.config.RegistryAuth[profile.Registry]; ok {
-		tr = transport.NewTransport(b.transport, transport.NewHeaderRequestModifier(http.Header{
-			"Authorization": []string{registryAuth},
-		}))
-	} else {
-		tr = b.transport
-	}
-
-	var registry = profile.Registry
-	if !strings.HasPrefix(registry, "http") {
-		registry = "https://" + registry
-	}
-	log.G(ctx).Debugf("Image URI generated at spawn with data: %s and %s", registry, named)
+	log.G(ctx).Debugf("Image URI generated at spawn with data: %s and %s", profile.Registry, named)
 
-	repo, err := client.NewRepository(ctx, named, registry, tr)
+	repo, err := b.newMirroredRepository(ctx, named, profile.Registry)
 	if err != nil {
 		return err
 	}
 
-	tagDescriptor, err := repo.Tags(ctx).Get(ctx, engineref.GetTagFromNamedRef(named))
-	if err != nil {
-		return err
+	// an app pinned to a digest is spooled without resolving a tag
+	var manifestDigest digest.Digest
+	if canonical, ok := named.(reference.Canonical); ok {
+		manifestDigest = canonical.Digest()
+	} else {
+		manifestDigest, err = repo.Resolve(ctx, engineref.GetTagFromNamedRef(named))
+		if err != nil {
+			return err
+		}
 	}
+	log.G(ctx).WithFields(apexlog.Fields{"name": name, "reference": named, "digest": manifestDigest}).Info("image has been resolved")
 
-	manifests, err := repo.Manifests(ctx)
+	manifest, err := repo.Manifest(ctx, manifestDigest)
 	if err != nil {
 		return err
 	}
 
-	manifest, err := manifests.Get(ctx, tagDescriptor.Digest)
-	if err != nil {
-		return err
+	// an index or a manifest list refers to images of different platforms
+	for depth := 0; ; depth++ {
+		index, ok := manifest.(*manifestIndex)
+		if !ok {
+			break
+		}
+		if depth >= maxManifestIndexDepth {
+			return fmt.Errorf("manifest index of %s is nested too deep", named)
+		}
+		dgst, err := index.Select(b.platform)
+		if err != nil {
+			return err
+		}
+		log.G(ctx).WithFields(apexlog.Fields{"name": name, "platform": b.platform, "digest": dgst}).Info("select image of the platform")
+		if manifest, err = repo.Manifest(ctx, dgst); err != nil {
+			return err
+		}
 	}
 
 	var order layersOrder
 	switch manifest.(type) {
 	case schema1.SignedManifest, *schema1.SignedManifest:
 		order = layerOrderV1
-	case schema2.DeserializedManifest, *schema2.DeserializedManifest:
+	case schema2.DeserializedManifest, *schema2.DeserializedManifest, *ociManifest:
+		if err = checkLayers(manifest.References()); err != nil {
+			return err
+		}
 		order = layerOrderV2
 	default:
 		return fmt.Errorf("unknown manifest type %T", manifest)
 	}
 
-	layers := make([]string, 0)
+	return b.importImage(ctx, name, repo.Blobs(ctx), order(manifest.References()), imageRecord{
+		Reference: named.String(),
+		Digest:    manifestDigest.String(),
+	})
+}
 
-	portoConn, err := portoConnect()
+// importImage fetches blobs of layers ordered from the top one, imports them to Porto and records the image
+func (b *Box) importImage(ctx context.Context, name string, blobs distribution.BlobProvider, references []distribution.Descriptor, image imageRecord) (err error) {
+	portoConn, err := b.portoPool.Get(ctx)
 	if err != nil {
 		log.G(ctx).WithError(err).WithField("name", name).Error("Porto connection error")
 		return err
 	}
 	defer portoConn.Close()
 
-	for _, descriptor := range order(manifest.References()) {
-		// TODO: Add support for __weak__ layers
-		layerName := descriptor.Digest.String()
-		// TODO: insert check of the layer existance here
-		// ListLayers is too heavy IMHO
-		// if the layer presents we can skip it
-		blobPath, err := b.blobRepo.Get(ctx, repo, descriptor.Digest)
+	// the rollback runs after blobs are unpinned, so blobs of removed layers can be removed too
+	tx := b.beginSpool(name, portoConn)
+	defer func() {
 		if err != nil {
-			return err
+			tx.Rollback(ctx)
 		}
-		entry := log.G(ctx).WithField("layer", layerName).Trace("Try to import layer")
+	}()
+
+	dgsts := make([]digest.Digest, 0, len(references))
+	for _, descriptor := range references {
+		dgsts = append(dgsts, descriptor.Digest)
+	}
+	// blobs must not be evicted until they are imported
+	defer b.blobRepo.Pin(dgsts...)()
+
+	var size int64
+
+	// TODO: Add support for __weak__ layers
+	// TODO: insert check of the layer existance here
+	// ListLayers is too heavy IMHO
+	// if the layer presents we can skip it
+	fetch := func(ctx context.Context, i int) (string, error) {
+		return b.blobRepo.Get(ctx, blobs, dgsts[i])
+	}
+	importLayer := func(i int, blobPath string) error {
+		layerName := dgsts[i].String()
 		portoLayerName := strings.Replace(layerName, ":", "_", -1)
-		err = portoConn.ImportLayer(portoLayerName, blobPath, false)
-		if err != nil && !isEqualPortoError(err, portorpc.EError_LayerAlreadyExists) {
-			entry.Stop(&err)
+		if err := tx.Import(ctx, portoLayerName, layerName, dgsts[i], blobPath); err != nil {
 			return err
 		}
-		layers = append(layers, portoLayerName)
+		if fi, err := os.Stat(blobPath); err == nil {
+			size += fi.Size()
+		}
+		return nil
 	}
-	b.journal.InsertManifestLayers(name, strings.Join(layers, ";"))
-
+	if err = b.fetchLayers(ctx, len(dgsts), fetch, importLayer); err != nil {
+		return err
+	}
+	image.Size = size
+	image.Spooled = time.Now()
+	tx.Commit(ctx, image)
 	return nil
 }
 
@@ -622,7 +724,10 @@ func (b *Box) Spool(ctx context.Context, name string, opts isolate.RawProfile) (
 
 	var errGet error
 	layersImported := false
-	if len(profile.ExtendedInfo.Layers) > 0 && b.dhEnable {
+	if profile.Source != "" {
+		errGet = b.getLayersFromSource(ctx, name, *profile)
+		layersImported = true
+	} else if len(profile.ExtendedInfo.Layers) > 0 && b.dhEnable {
 		log.G(ctx).Debugf("Try get layers via download_helper cmd: %s.", b.config.DownloadHelperCmd)
 		errGet = b.getLayersViaDownloadHelper(ctx, name, *profile)
 		if errGet != nil {
@@ -656,6 +761,8 @@ func (b *Box) Spool(ctx context.Context, name string, opts isolate.RawProfile) (
 
 // Spawn spawns new Porto container
 func (b *Box) Spawn(ctx context.Context, config isolate.SpawnConfig, output io.Writer) (isolate.Process, error) {
+	// MTN events of the container are published with the name of the box
+	ctx = isolate.WithBoxName(ctx, b.boxName)
 	var profile = new(Profile)
 	err := config.Opts.DecodeTo(profile)
 	if err != nil {
@@ -680,6 +787,7 @@ func (b *Box) Spawn(ctx context.Context, config isolate.SpawnConfig, output io.W
 	ID := b.appGenLabel(config.Name) + "_" + config.Args["--uuid"]
 	cfg := containerConfig{
 		BoxName:        b.Name,
+		EventBox:       b.boxName,
 		Root:           filepath.Join(b.config.Containers, ID),
 		ID:             b.addRootNamespacePrefix(ID),
 		Layer:          layers,
@@ -688,6 +796,8 @@ func (b *Box) Spawn(ctx context.Context, config isolate.SpawnConfig, output io.W
 		SetImgURI:      b.config.SetImgURI,
 		VolumeBackend:  b.config.VolumeBackend,
 		VolumeLabel:    b.config.CocaineAppVolumeLabel,
+		Pool:           b.portoPool,
+		Output:         b.config.Output,
 		execInfo: execInfo{
 			Profile:     profile,
 			name:        config.Name,
@@ -699,18 +809,22 @@ func (b *Box) Spawn(ctx context.Context, config isolate.SpawnConfig, output io.W
 		},
 	}
 
-	portoConn, err := portoConnect()
+	// queued spawns must not hold connections needed by Kill and Inspect
+	release, err := b.spawnSM.AcquireN(ctx, config.Weight, config.Priority)
+	spawningQueueSize.Dec(1)
 	if err != nil {
-		return nil, err
+		return nil, isolate.ErrSpawningCancelled
 	}
-	defer portoConn.Close()
+	defer release()
+	defer func(started time.Time) {
+		b.spawnLimiter.Observe(ctx, time.Since(started), err)
+	}(time.Now())
 
-	err = b.spawnSM.Acquire(ctx)
-	spawningQueueSize.Dec(1)
+	portoConn, err := b.portoPool.Get(ctx)
 	if err != nil {
-		return nil, isolate.ErrSpawningCancelled
+		return nil, err
 	}
-	defer b.spawnSM.Release()
+	defer portoConn.Close()
 
 	log.G(ctx).WithFields(apexlog.Fields{"name": config.Name, "layer": cfg.Layer, "root": cfg.Root, "id": cfg.ID}).Info("Create container")
 
@@ -721,15 +835,20 @@ func (b *Box) Spawn(ctx context.Context, config isolate.SpawnConfig, output io.W
 		return nil, err
 	}
 
-	b.muContainers.Lock()
-	b.containers[pr.containerID] = pr
-	b.muContainers.Unlock()
+	if image, ok := b.journal.Image(config.Name); ok {
+		pr.imageDigest = image.Digest
+	}
 
 	if err = pr.start(portoConn, output); err != nil {
 		containersErroredCounter.Inc(1)
 		pr.Cleanup(portoConn)
 		return nil, err
 	}
+	// Wait of Portod returns at once for stopped containers, so only started ones are tracked
+	b.muContainers.Lock()
+	b.containers[pr.containerID] = pr
+	b.muContainers.Unlock()
+	b.track()
 	isolate.NotifyAboutStart(output)
 	totalSpawnTimer.UpdateSince(start)
 	return pr, nil
@@ -741,27 +860,55 @@ func (b *Box) Inspect(ctx context.Context, workeruuid string) ([]byte, error) {
 		if pr.uuid == workeruuid {
 			b.muContainers.Unlock()
 
-			portoConn, err := portoConnect()
+			portoConn, err := b.portoPool.Get(ctx)
 			if err != nil {
 				return nil, err
 			}
+			defer portoConn.Close()
 			list := getPListAndDlist(portoConn)
 			result, err := portoConn.Get([]string{cid}, list)
 			if err != nil {
 				return nil, err
 			}
 
-			return json.Marshal(portoData(result[cid]))
+			body, err := json.Marshal(portoData(result[cid]))
+			if err != nil {
+				return nil, err
+			}
+			return b.withImage(body, pr)
 		}
 	}
 	b.muContainers.Unlock()
 	return []byte(""), nil
 }
 
+// Probes checks that Porto replies and Layers and Containers directories are usable
+func (b *Box) Probes() []isolate.Probe {
+	return []isolate.Probe{
+		{
+			Name: "porto.version",
+			Check: func(ctx context.Context) error {
+				portoConn, err := b.portoPool.Get(ctx)
+				if err != nil {
+					return err
+				}
+				defer portoConn.Close()
+				if _, _, err = portoConn.GetVersion(); err != nil {
+					portoConn.Discard()
+				}
+				return err
+			},
+		},
+		isolate.DirWritableProbe("layers.writable", b.config.Layers),
+		isolate.FreeSpaceProbe("layers.freespace", b.config.Layers, b.config.MinFreeSpace),
+		isolate.FreeSpaceProbe("containers.freespace", b.config.Containers, b.config.MinFreeSpace),
+	}
+}
+
 // Close releases all resources such as idle connections from http.Transport
 func (b *Box) Close() error {
 	b.transport.CloseIdleConnections()
 	b.GlobalState.Mtn.Db.Close()
 	b.onClose()
-	return nil
+	return b.portoPool.Close()
 }
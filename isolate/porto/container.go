@@ -2,14 +2,14 @@ package porto
 
 import (
 	"io"
-	"io/ioutil"
 	"os"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/noxiouz/stout/pkg/log"
 	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/events"
+	"github.com/noxiouz/stout/pkg/log"
 	"golang.org/x/net/context"
 
 	porto "github.com/yandex/porto/src/api/go"
@@ -19,7 +19,10 @@ import (
 type container struct {
 	ctx context.Context
 
-	State          isolate.GlobalState
+	State isolate.GlobalState
+	// name of the box in the configuration
+	box            string
+	appname        string
 	uuid           string
 	containerID    string
 	mtnIp          string
@@ -29,13 +32,18 @@ type container struct {
 
 	volume       Volume
 	extraVolumes []Volume
-	output       io.Writer
+	output       *outputFollower
 	VolumeLabel  string
-
-	mtn               bool
-	netId             string
-	mtnAllocationId   string
-	mtnAllocCleaned   bool
+	pool         *connPool
+	// layers of the root volume, they are not collected while the container is alive
+	layers []string
+	// digest of the image the container has been spawned from
+	imageDigest string
+
+	mtn             bool
+	netId           string
+	mtnAllocationId string
+	mtnAllocCleaned bool
 }
 
 // NOTE: is it better to have some kind of our own init inside Porto container to handle output?
@@ -60,38 +68,57 @@ func newContainer(ctx context.Context, portoConn porto.API, cfg containerConfig)
 	}
 
 	cnt = &container{
-		ctx:              ctx,
-		State:            cfg.State,
-		uuid:             cfg.args["--uuid"],
-		containerID:      cfg.ID,
-		rootDir:          cfg.Root,
-		cleanupEnabled:   cfg.CleanupEnabled,
-		SetImgURI:        cfg.SetImgURI,
-
-		volume:           volume,
-		extraVolumes:     extravolumes,
-		output:           ioutil.Discard,
-		VolumeLabel:      cfg.VolumeLabel,
-
-		mtn:              cfg.Mtn,
-		mtnAllocCleaned:  !cfg.Mtn,
-		netId:            cfg.Network["netid"],
-		mtnAllocationId:  cfg.MtnAllocationId,
-		mtnIp:            cfg.MtnIp,
+		ctx:            ctx,
+		State:          cfg.State,
+		box:            cfg.EventBox,
+		appname:        cfg.name,
+		uuid:           cfg.args["--uuid"],
+		containerID:    cfg.ID,
+		rootDir:        cfg.Root,
+		cleanupEnabled: cfg.CleanupEnabled,
+		SetImgURI:      cfg.SetImgURI,
+
+		volume:       volume,
+		extraVolumes: extravolumes,
+		output:       newOutputFollower(ctx, cfg.ID, cfg.Output),
+		VolumeLabel:  cfg.VolumeLabel,
+		pool:         cfg.Pool,
+		layers:       splitLayers(cfg.Layer),
+
+		mtn:             cfg.Mtn,
+		mtnAllocCleaned: !cfg.Mtn,
+		netId:           cfg.Network["netid"],
+		mtnAllocationId: cfg.MtnAllocationId,
+		mtnIp:           cfg.MtnIp,
 	}
 	return cnt, nil
 }
 
+func (c *container) event(typ events.Type) events.Event {
+	return events.Event{
+		Type:  typ,
+		Box:   c.box,
+		App:   c.appname,
+		UUID:  c.uuid,
+		Attrs: map[string]string{"container": c.containerID},
+	}
+}
+
 func (c *container) start(portoConn porto.API, output io.Writer) (err error) {
 	defer log.G(c.ctx).WithField("id", c.containerID).Trace("start container").Stop(&err)
-	c.output = output
-	return portoConn.Start(c.containerID)
+	if err = portoConn.Start(c.containerID); err != nil {
+		return err
+	}
+	c.output.Follow(c.pool, output)
+	return nil
 }
 
 func (c *container) Kill() (err error) {
 	defer log.G(c.ctx).WithField("id", c.containerID).Trace("Kill container").Stop(&err)
 	containersKilledCounter.Inc(1)
-	portoConn, err := portoConnect()
+	// the spawn context is canceled when the runtime disconnects,
+	// but the container must be destroyed anyway
+	portoConn, err := c.pool.Get(context.Background())
 	if err != nil {
 		return err
 	}
@@ -100,21 +127,7 @@ func (c *container) Kill() (err error) {
 
 	// After Kill the container must be in `dead` state
 	// Wait seems redundant as we sent SIGKILL
-	value, err := portoConn.GetData(c.containerID, "stdout")
-	if err != nil {
-		log.G(c.ctx).WithField("id", c.containerID).WithError(err).Warn("unable to get stdout")
-	}
-	// TODO: add StringWriter interface to an output
-	c.output.Write([]byte(value))
-	log.G(c.ctx).WithField("id", c.containerID).Infof("%d bytes of stdout have been sent", len(value))
-
-	value, err = portoConn.GetData(c.containerID, "stderr")
-	if err != nil {
-		log.G(c.ctx).WithField("id", c.containerID).WithError(err).Warn("unable to get stderr")
-	}
-	c.output.Write([]byte(value))
-	log.G(c.ctx).WithField("id", c.containerID).Infof("%d bytes of stderr have been sent", len(value))
-	log.G(c.ctx).WithField("id", c.containerID).Debugf("Content of stderr that sented: %s", value)
+	c.output.Flush(portoConn)
 
 	if err = portoConn.Kill(c.containerID, syscall.SIGKILL); err != nil {
 		if !isEqualPortoError(err, portorpc.EError_InvalidState) {
@@ -100,6 +100,8 @@ type containerConfig struct {
 	State           isolate.GlobalState
 
 	BoxName		string
+	// EventBox is the name of the box in the configuration
+	EventBox        string
 	Root            string
 	ID              string
 	Layer           string
@@ -110,6 +112,9 @@ type containerConfig struct {
 	MtnAllocationId string
 	MtnIp		string
 	VolumeLabel     string
+	// Pool provides connections to the container after the spawn
+	Pool            *connPool
+	Output          outputConfig
 }
 
 func (c *containerConfig) CreateRootVolume(ctx context.Context, portoConn porto.API) (Volume, error) {
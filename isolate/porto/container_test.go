@@ -131,7 +131,9 @@ func TestContainer(t *testing.T) {
 		io.Copy(ioutil.Discard, tarReader)
 	}
 
-	portoConn, err := portoConnect()
+	pool := newConnPool(portoConnConfig{})
+	defer pool.Close()
+	portoConn, err := pool.Get(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -173,6 +175,7 @@ func TestContainer(t *testing.T) {
 		Root:     dir,
 		ID:       "IsolateLinuxApline",
 		Layer:    "testalpine",
+		Pool:     pool,
 		execInfo: ei,
 	}
 
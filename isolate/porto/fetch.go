@@ -0,0 +1,128 @@
+package porto
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/semaphore"
+)
+
+const (
+	defaultFetchConcurrency      = 8
+	defaultSpoolFetchConcurrency = 3
+)
+
+// fetchLayerFunc downloads the i-th layer of a manifest and returns the path to its blob
+type fetchLayerFunc func(ctx context.Context, i int) (string, error)
+
+// importLayerFunc imports the i-th layer downloaded to path
+type importLayerFunc func(i int, path string) error
+
+type fetchResult struct {
+	path string
+	err  error
+}
+
+// fetchLayers downloads count layers concurrently and imports them one by one in order of the manifest.
+// Downloads are limited by SpoolFetchConcurrency per call and by FetchConcurrency per box.
+// The first error cancels downloads in flight. fetchLayers returns after all of them have finished
+func (b *Box) fetchLayers(ctx context.Context, count int, fetch fetchLayerFunc, importLayer importLayerFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var (
+		wg sync.WaitGroup
+
+		mu       sync.Mutex
+		firstErr error
+	)
+	// fail cancels the rest of downloads and keeps the first error
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+	failure := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr != nil {
+			return firstErr
+		}
+		return ctx.Err()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	results := make([]chan fetchResult, count)
+	for i := range results {
+		results[i] = make(chan fetchResult, 1)
+	}
+
+	slots := make(chan struct{}, b.spoolFetchConcurrency())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// layers are started in order, so the next one to import is downloaded first
+		for i := 0; i < count; i++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			// both cases might be ready
+			if ctx.Err() != nil {
+				return
+			}
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				path, err := b.fetchLayer(ctx, i, fetch)
+				if err != nil {
+					fail(err)
+				}
+				results[i] <- fetchResult{path: path, err: err}
+			}(i)
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		var res fetchResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return failure()
+		}
+		if res.err != nil {
+			return failure()
+		}
+		if err := importLayer(i, res.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Box) fetchLayer(ctx context.Context, i int, fetch fetchLayerFunc) (string, error) {
+	release, err := b.fetchSM.AcquireN(ctx, 1, semaphore.PriorityNormal)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	defer layerFetchTimer.UpdateSince(time.Now())
+
+	return fetch(ctx, i)
+}
+
+func (b *Box) spoolFetchConcurrency() int {
+	if b.config.SpoolFetchConcurrency == 0 {
+		return defaultSpoolFetchConcurrency
+	}
+	return int(b.config.SpoolFetchConcurrency)
+}
@@ -0,0 +1,152 @@
+package porto
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/semaphore"
+)
+
+func newFetchBox(box, spool uint) *Box {
+	return &Box{
+		config:  &portoBoxConfig{FetchConcurrency: box, SpoolFetchConcurrency: spool},
+		fetchSM: semaphore.NewWeighted(int64(box), nil),
+	}
+}
+
+func TestFetchLayersOrderAndLimit(t *testing.T) {
+	require := require.New(t)
+	b := newFetchBox(8, 3)
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		peak     int
+	)
+	fetch := func(ctx context.Context, i int) (string, error) {
+		mu.Lock()
+		if inFlight++; inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		// the first layers are the slowest ones
+		time.Sleep(time.Duration(10-i) * 5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return fmt.Sprintf("layer-%d", i), nil
+	}
+
+	var imported []string
+	importLayer := func(i int, path string) error {
+		imported = append(imported, path)
+		return nil
+	}
+
+	require.NoError(b.fetchLayers(context.Background(), 10, fetch, importLayer))
+	require.Len(imported, 10)
+	for i, path := range imported {
+		require.Equal(fmt.Sprintf("layer-%d", i), path)
+	}
+	require.True(peak > 1, "layers must be fetched concurrently")
+	require.True(peak <= 3, "concurrency of a spool must be limited, got %d", peak)
+}
+
+func TestFetchLayersBoxLimit(t *testing.T) {
+	require := require.New(t)
+	b := newFetchBox(2, 3)
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		peak     int
+	)
+	fetch := func(ctx context.Context, i int) (string, error) {
+		mu.Lock()
+		if inFlight++; inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return "", nil
+	}
+	importLayer := func(i int, path string) error { return nil }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(b.fetchLayers(context.Background(), 4, fetch, importLayer))
+		}()
+	}
+	wg.Wait()
+	require.Equal(2, peak)
+}
+
+func TestFetchLayersCancelsOnError(t *testing.T) {
+	require := require.New(t)
+	b := newFetchBox(8, 4)
+
+	errFetch := errors.New("fetch failed")
+	var (
+		mu        sync.Mutex
+		cancelled int
+	)
+	fetch := func(ctx context.Context, i int) (string, error) {
+		if i == 1 {
+			return "", errFetch
+		}
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			cancelled++
+			mu.Unlock()
+			return "", ctx.Err()
+		case <-time.After(5 * time.Second):
+			return "", nil
+		}
+	}
+
+	var imported []int
+	importLayer := func(i int, path string) error {
+		imported = append(imported, i)
+		return nil
+	}
+
+	start := time.Now()
+	require.Equal(errFetch, b.fetchLayers(context.Background(), 6, fetch, importLayer))
+	require.True(time.Since(start) < time.Second)
+	require.Empty(imported)
+	// fetches in flight have returned before fetchLayers
+	mu.Lock()
+	require.Equal(3, cancelled)
+	mu.Unlock()
+}
+
+func TestFetchLayersImportError(t *testing.T) {
+	require := require.New(t)
+	b := newFetchBox(8, 2)
+
+	errImport := errors.New("import failed")
+	fetch := func(ctx context.Context, i int) (string, error) { return "", nil }
+	var imported []int
+	importLayer := func(i int, path string) error {
+		if i == 2 {
+			return errImport
+		}
+		imported = append(imported, i)
+		return nil
+	}
+	require.Equal(errImport, b.fetchLayers(context.Background(), 5, fetch, importLayer))
+	require.Equal([]int{0, 1}, imported)
+}
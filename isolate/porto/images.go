@@ -0,0 +1,79 @@
+package porto
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+)
+
+var _ isolate.ImageLister = &Box{}
+
+// imageName returns the image of the profile or the name of the app
+func imageName(name string, profile Profile) string {
+	if profile.Image != "" {
+		return profile.Image
+	}
+	return name
+}
+
+// inspectImage is added to Inspect replies as the "image" key
+type inspectImage struct {
+	imageRecord
+	// Current is the digest the app is spooled from now, it differs if the app has been respooled
+	Current string `json:"current,omitempty"`
+}
+
+// withImage adds the image of the container to the JSON object of its properties
+func (b *Box) withImage(body []byte, c *container) ([]byte, error) {
+	image, ok := b.journal.Image(c.appname)
+	if !ok && c.imageDigest == "" {
+		return body, nil
+	}
+	reply := inspectImage{imageRecord: image, Current: image.Digest}
+	reply.Digest = c.imageDigest
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(body)) <= 2 {
+		return append(append([]byte(`{"image":`), encoded...), '}'), nil
+	}
+	buff := bytes.NewBuffer(make([]byte, 0, len(body)+len(encoded)+10))
+	buff.WriteString(`{"image":`)
+	buff.Write(encoded)
+	buff.WriteByte(',')
+	buff.Write(bytes.TrimPrefix(bytes.TrimSpace(body), []byte("{")))
+	return buff.Bytes(), nil
+}
+
+// Images returns images of spooled apps with workers running them
+func (b *Box) Images(ctx context.Context) ([]isolate.Image, error) {
+	workers := make(map[string][]isolate.Worker)
+	b.muContainers.Lock()
+	for _, c := range b.containers {
+		workers[c.appname] = append(workers[c.appname], isolate.Worker{UUID: c.uuid, Digest: c.imageDigest})
+	}
+	b.muContainers.Unlock()
+
+	all := b.journal.AllImages()
+	result := make([]isolate.Image, 0, len(all))
+	for app, image := range all {
+		running := workers[app]
+		sort.Slice(running, func(i, j int) bool { return running[i].UUID < running[j].UUID })
+		result = append(result, isolate.Image{
+			App:       app,
+			Reference: image.Reference,
+			Digest:    image.Digest,
+			Size:      image.Size,
+			Spooled:   image.Spooled,
+			Workers:   running,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].App < result[j].App })
+	return result, nil
+}
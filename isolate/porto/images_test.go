@@ -0,0 +1,56 @@
+package porto
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestImageName(t *testing.T) {
+	require.Equal(t, "app", imageName("app", Profile{}))
+	require.Equal(t, "worker@sha256:0123", imageName("app", Profile{Image: "worker@sha256:0123"}))
+}
+
+func TestInspectWithImage(t *testing.T) {
+	require := require.New(t)
+	b := &Box{journal: newJournal(), containers: make(map[string]*container)}
+
+	c := &container{appname: "app", uuid: "uuid", imageDigest: "sha256:old"}
+	// the container without an image is inspected as is
+	body, err := b.withImage([]byte(`{"state":"running"}`), &container{appname: "unknown"})
+	require.NoError(err)
+	require.Equal(`{"state":"running"}`, string(body))
+
+	b.journal.InsertImage("app", imageRecord{Reference: "apps/apps/app:latest", Digest: "sha256:new"})
+	body, err = b.withImage([]byte(`{"state":"running"}`), c)
+	require.NoError(err)
+
+	var reply struct {
+		State string `json:"state"`
+		Image struct {
+			Reference string `json:"reference"`
+			Digest    string `json:"digest"`
+			Current   string `json:"current"`
+		} `json:"image"`
+	}
+	require.NoError(json.Unmarshal(body, &reply))
+	require.Equal("running", reply.State)
+	require.Equal("apps/apps/app:latest", reply.Image.Reference)
+	require.Equal("sha256:old", reply.Image.Digest)
+	require.Equal("sha256:new", reply.Image.Current)
+
+	body, err = b.withImage([]byte(`{}`), c)
+	require.NoError(err)
+	require.NoError(json.Unmarshal(body, &reply))
+
+	b.containers["app_uuid"] = c
+	images, err := b.Images(context.Background())
+	require.NoError(err)
+	require.Len(images, 1)
+	require.Equal("app", images[0].App)
+	require.Equal("sha256:new", images[0].Digest)
+	require.Equal("uuid", images[0].Workers[0].UUID)
+	require.Equal("sha256:old", images[0].Workers[0].Digest)
+}
@@ -4,26 +4,48 @@ import (
 	"encoding/json"
 	"io"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pborman/uuid"
 )
 
 type layersMap map[string]string
 type manifests map[string]string
+type lastUse map[string]time.Time
+type images map[string]imageRecord
+
+// imageRecord is the image an app has been spooled from
+type imageRecord struct {
+	Reference string    `json:"reference"`
+	Digest    string    `json:"digest,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Spooled   time.Time `json:"spooled"`
+}
 
 type journal struct {
 	mu        sync.RWMutex
 	UUID      string    `json:"uuid"`
 	Layers    layersMap `json:"layers"`
 	Manifests manifests `json:"manifests"`
+
+	// AppsUsed keeps the last spool or spawn of an app,
+	// LayersUsed keeps the last time a layer has been referenced by a used manifest
+	AppsUsed   lastUse `json:"appsused"`
+	LayersUsed lastUse `json:"layersused"`
+	// Images are resolved references of apps
+	Images images `json:"images"`
 }
 
 func newJournal() *journal {
 	j := &journal{
-		UUID:      uuid.New(),
-		Layers:    make(layersMap),
-		Manifests: make(manifests),
+		UUID:       uuid.New(),
+		Layers:     make(layersMap),
+		Manifests:  make(manifests),
+		AppsUsed:   make(lastUse),
+		LayersUsed: make(lastUse),
+		Images:     make(images),
 	}
 	return j
 }
@@ -41,29 +63,178 @@ func (j *journal) Load(r io.Reader) error {
 	if err := json.NewDecoder(r).Decode(j); err != nil {
 		return err
 	}
+	// journals of older versions have no usage, it's counted from the load
+	// not to collect everything right after an upgrade
+	if j.AppsUsed == nil {
+		j.AppsUsed = make(lastUse)
+	}
+	if j.LayersUsed == nil {
+		j.LayersUsed = make(lastUse)
+	}
+	if j.Images == nil {
+		j.Images = make(images)
+	}
+	now := time.Now().UTC()
+	for manifest := range j.Manifests {
+		if _, ok := j.AppsUsed[manifest]; !ok {
+			j.AppsUsed[manifest] = now
+		}
+	}
+	for layer := range j.Layers {
+		if _, ok := j.LayersUsed[layer]; !ok {
+			j.LayersUsed[layer] = now
+		}
+	}
 	return nil
 }
 
 func (j *journal) InsertManifestLayers(manifest string, layers string) {
 	j.mu.Lock()
 	j.Manifests[manifest] = layers
+	j.touch(manifest, time.Now())
 	j.mu.Unlock()
 }
 
-func (j *journal) GetManifestLayers(manifests string) string {
+// CommitSpool records imported layers with the manifest and the image of the app at once
+func (j *journal) CommitSpool(manifest string, layers []string, digests map[string]string, image imageRecord) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, layer := range layers {
+		j.Layers[layer] = digests[layer]
+	}
+	j.Manifests[manifest] = strings.Join(layers, ";")
+	j.Images[manifest] = image
+	j.touch(manifest, time.Now())
+}
+
+// Referenced tells if a manifest references the layer
+func (j *journal) Referenced(layer string) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	for _, layers := range j.Manifests {
+		if contains(splitLayers(layers), layer) {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertImage records the image the manifest of the app has been spooled from
+func (j *journal) InsertImage(manifest string, image imageRecord) {
+	j.mu.Lock()
+	j.Images[manifest] = image
+	j.mu.Unlock()
+}
+
+// Image returns the image of the app
+func (j *journal) Image(manifest string) (imageRecord, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	image, ok := j.Images[manifest]
+	return image, ok
+}
+
+// AllImages returns a copy of images of apps
+func (j *journal) AllImages() images {
 	j.mu.RLock()
+	defer j.mu.RUnlock()
+	all := make(images, len(j.Images))
+	for manifest, image := range j.Images {
+		all[manifest] = image
+	}
+	return all
+}
+
+// GetManifestLayers returns layers of the manifest and marks them as used
+func (j *journal) GetManifestLayers(manifests string) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
 	layers, ok := j.Manifests[manifests]
-	j.mu.RUnlock()
 	if !ok {
 		return ""
 	}
+	j.touch(manifests, time.Now())
 	return layers
 }
 
+// touch must be called under the lock
+func (j *journal) touch(manifest string, now time.Time) {
+	j.AppsUsed[manifest] = now
+	for _, layer := range splitLayers(j.Manifests[manifest]) {
+		j.LayersUsed[layer] = now
+	}
+}
+
+// RemoveStaleManifests removes manifests which haven't been used since the deadline.
+// Manifests of running apps are kept. Nothing is removed if dryRun is set
+func (j *journal) RemoveStaleManifests(deadline time.Time, running map[string]struct{}, dryRun bool) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var stale []string
+	for manifest := range j.Manifests {
+		if _, ok := running[manifest]; ok || !j.AppsUsed[manifest].Before(deadline) {
+			continue
+		}
+		stale = append(stale, manifest)
+		if !dryRun {
+			delete(j.Manifests, manifest)
+			delete(j.AppsUsed, manifest)
+			delete(j.Images, manifest)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+type layerUsage struct {
+	Name     string
+	LastUsed time.Time
+}
+
+// Unreferenced returns imported layers which are neither referenced by manifests nor in use,
+// the least recently used first. Manifests in ignored are treated as removed
+func (j *journal) Unreferenced(inUse map[string]struct{}, ignored []string) []layerUsage {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	referenced := make(map[string]struct{}, len(j.Layers))
+	for manifest, layers := range j.Manifests {
+		if contains(ignored, manifest) {
+			continue
+		}
+		for _, layer := range splitLayers(layers) {
+			referenced[layer] = struct{}{}
+		}
+	}
+
+	var unreferenced []layerUsage
+	for layer := range j.Layers {
+		_, isReferenced := referenced[layer]
+		_, isUsed := inUse[layer]
+		if !isReferenced && !isUsed {
+			unreferenced = append(unreferenced, layerUsage{Name: layer, LastUsed: j.LayersUsed[layer]})
+		}
+	}
+	sort.Slice(unreferenced, func(a, b int) bool {
+		return unreferenced[a].LastUsed.Before(unreferenced[b].LastUsed)
+	})
+	return unreferenced
+}
+
+// RemoveLayer forgets the layer removed from Porto
+func (j *journal) RemoveLayer(layer string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.Layers, layer)
+	delete(j.LayersUsed, layer)
+}
+
 func (j *journal) Insert(layer string, digest string) *journal {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 	j.Layers[layer] = digest
+	// a layer being imported is not referenced yet
+	j.LayersUsed[layer] = time.Now()
 	return j
 }
 
@@ -89,6 +260,7 @@ func (j *journal) UpdateFromPorto(layers []string) {
 	for k := range j.Layers {
 		if !in(layers, k) {
 			delete(j.Layers, k)
+			delete(j.LayersUsed, k)
 		}
 	}
 }
@@ -103,6 +275,22 @@ func (j *journal) String() string {
 	return string(body)
 }
 
+func splitLayers(layers string) []string {
+	if layers == "" {
+		return nil
+	}
+	return strings.Split(layers, ";")
+}
+
+func contains(a []string, x string) bool {
+	for _, v := range a {
+		if v == x {
+			return true
+		}
+	}
+	return false
+}
+
 func in(a []string, x string) bool {
 	i := sort.SearchStrings(a, x)
 	return i < len(a) && a[i] == x
@@ -3,7 +3,9 @@ package porto
 import (
 	"bytes"
 	"encoding/json"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -64,3 +66,83 @@ func TestJournalInsert(t *testing.T) {
 
 	assertT.EqualValues(map[string]string{"A": "a", "B": "b", "C": "c", "D": "d"}, j.Layers)
 }
+
+func TestJournalUsage(t *testing.T) {
+	assertT := require.New(t)
+	j := newJournal()
+
+	j.Insert("A", "sha256:a").Insert("B", "sha256:b").Insert("C", "sha256:c")
+	j.InsertManifestLayers("app1", "A;B")
+	j.InsertManifestLayers("app2", "B")
+
+	assertT.Equal([]string{"C"}, layerNames(j.Unreferenced(nil, nil)))
+	assertT.Empty(j.Unreferenced(map[string]struct{}{"C": {}}, nil))
+	assertT.Equal([]string{"A", "C"}, sortedLayerNames(j.Unreferenced(nil, []string{"app1"})))
+
+	// app2 has been spawned recently, app1 is running
+	deadline := time.Now().Add(time.Hour)
+	j.AppsUsed["app2"] = deadline.Add(time.Second)
+	assertT.Empty(j.RemoveStaleManifests(deadline, map[string]struct{}{"app1": {}}, false))
+
+	assertT.Equal([]string{"app1"}, j.RemoveStaleManifests(deadline, nil, true))
+	assertT.Equal("A;B", j.GetManifestLayers("app1"), "dry run must keep manifests")
+
+	assertT.Equal([]string{"app1"}, j.RemoveStaleManifests(deadline, nil, false))
+	assertT.Empty(j.GetManifestLayers("app1"))
+	assertT.Equal([]string{"A", "C"}, sortedLayerNames(j.Unreferenced(nil, nil)))
+
+	j.RemoveLayer("A")
+	assertT.False(j.In("A", "sha256:a"))
+	assertT.NotContains(j.LayersUsed, "A")
+}
+
+func TestJournalLoadWithoutUsage(t *testing.T) {
+	assertT := require.New(t)
+	j := &journal{}
+	assertT.NoError(j.Load(bytes.NewReader([]byte(`{"uuid":"uuid","layers":{"A":"sha256:a"},"manifests":{"app":"A"}}`))))
+	// usage is counted from the load, nothing is stale right after an upgrade
+	assertT.False(j.AppsUsed["app"].IsZero())
+	assertT.False(j.LayersUsed["A"].IsZero())
+	assertT.Empty(j.RemoveStaleManifests(time.Now().Add(-time.Hour), nil, false))
+	assertT.Equal("A", j.GetManifestLayers("app"))
+	assertT.Contains(j.LayersUsed, "A")
+}
+
+func layerNames(layers []layerUsage) []string {
+	var names []string
+	for _, layer := range layers {
+		names = append(names, layer.Name)
+	}
+	return names
+}
+
+func sortedLayerNames(layers []layerUsage) []string {
+	names := layerNames(layers)
+	sort.Strings(names)
+	return names
+}
+
+func TestJournalImages(t *testing.T) {
+	assertT := require.New(t)
+	j := newJournal()
+
+	spooled := time.Now()
+	j.InsertManifestLayers("app", "A")
+	j.InsertImage("app", imageRecord{Reference: "apps/apps/app:latest", Digest: "sha256:0123", Size: 42, Spooled: spooled})
+
+	buff := new(bytes.Buffer)
+	assertT.NoError(j.Dump(buff))
+	loaded := &journal{}
+	assertT.NoError(loaded.Load(buff))
+	image, ok := loaded.Image("app")
+	assertT.True(ok)
+	assertT.Equal("sha256:0123", image.Digest)
+	assertT.Equal(int64(42), image.Size)
+	assertT.True(spooled.Equal(image.Spooled))
+
+	// images of stale manifests are forgotten
+	loaded.RemoveStaleManifests(time.Now().Add(time.Hour), nil, false)
+	_, ok = loaded.Image("app")
+	assertT.False(ok)
+	assertT.Empty(loaded.AllImages())
+}
@@ -0,0 +1,188 @@
+package porto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	// journalVersion is the version of the format written by the box.
+	// Version 1 journals are bare JSON objects without a checksum
+	journalVersion = 2
+
+	journalBackupSuffix  = ".bak"
+	journalCorruptSuffix = ".corrupt"
+)
+
+// journalEnvelope is the on-disk format of the journal
+type journalEnvelope struct {
+	Version int `json:"version"`
+	// Checksum is sha256 of Body
+	Checksum string          `json:"checksum"`
+	Body     json.RawMessage `json:"body"`
+}
+
+func journalChecksum(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func encodeJournal(j *journal) ([]byte, error) {
+	var buff bytes.Buffer
+	if err := j.Dump(&buff); err != nil {
+		return nil, err
+	}
+	body := bytes.TrimSpace(buff.Bytes())
+	return json.Marshal(journalEnvelope{
+		Version:  journalVersion,
+		Checksum: journalChecksum(body),
+		Body:     json.RawMessage(body),
+	})
+}
+
+// decodeJournal verifies the journal and migrates it from older versions
+func decodeJournal(data []byte) (*journal, int, error) {
+	var envelope journalEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, 0, err
+	}
+
+	switch {
+	case envelope.Version == 0:
+		// a bare journal of the first version
+		envelope.Version, envelope.Body = 1, data
+	case envelope.Version > journalVersion:
+		return nil, envelope.Version, fmt.Errorf("journal version %d is newer than supported %d", envelope.Version, journalVersion)
+	case journalChecksum(envelope.Body) != envelope.Checksum:
+		return nil, envelope.Version, fmt.Errorf("journal checksum mismatch: expected %s", envelope.Checksum)
+	}
+
+	j := newJournal()
+	if err := j.Load(bytes.NewReader(envelope.Body)); err != nil {
+		return nil, envelope.Version, err
+	}
+	return j, envelope.Version, nil
+}
+
+// journalFile keeps the journal and the previous copy of it as a backup.
+// Writes are synced with their directory, so a written journal survives a crash
+type journalFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (f *journalFile) backupPath() string {
+	return f.path + journalBackupSuffix
+}
+
+// Write replaces the journal, the replaced one becomes the backup
+func (f *journalFile) Write(j *journal) (err error) {
+	start := time.Now()
+	defer func() {
+		journalWriteTimer.UpdateSince(start)
+		if err != nil {
+			journalWriteErrorsCounter.Inc(1)
+		}
+	}()
+
+	data, err := encodeJournal(j)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dir := filepath.Dir(f.path)
+	tempfile, err := ioutil.TempFile(dir, "portojournalbak")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempfile.Name())
+	if _, err = tempfile.Write(data); err != nil {
+		tempfile.Close()
+		return err
+	}
+	if err = tempfile.Sync(); err != nil {
+		tempfile.Close()
+		return err
+	}
+	if err = tempfile.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(f.path, f.backupPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Rename(tempfile.Name(), f.path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Read loads the journal, the backup is used if the journal is missing or corrupted.
+// A new journal is returned if there is no usable copy, corrupted copies are kept aside
+func (f *journalFile) Read(ctx context.Context) (*journal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var found bool
+	for _, path := range []string{f.path, f.backupPath()} {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+
+		j, version, err := decodeJournal(data)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("path", path).Error("journal is corrupted")
+			if version > journalVersion {
+				// a newer journal must not be overwritten by the box
+				return nil, err
+			}
+			continue
+		}
+		if path != f.path {
+			journalRecoveredCounter.Inc(1)
+			log.G(ctx).WithField("path", path).Warn("journal has been recovered from the backup")
+			// the next write must not turn the corrupted journal into the backup
+			os.Rename(f.path, f.path+journalCorruptSuffix)
+		}
+		if version < journalVersion {
+			log.G(ctx).WithField("version", version).Info("journal will be migrated to the current version")
+		}
+		return j, nil
+	}
+
+	if found {
+		for _, path := range []string{f.path, f.backupPath()} {
+			os.Rename(path, path+journalCorruptSuffix)
+		}
+		log.G(ctx).Error("no usable copy of the journal, start with an empty one")
+	}
+	return newJournal(), nil
+}
@@ -0,0 +1,126 @@
+package porto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func newTestJournalFile(t *testing.T) (*journalFile, func()) {
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	return &journalFile{path: filepath.Join(dir, "portojournal.jrnl")}, func() { os.RemoveAll(dir) }
+}
+
+func TestJournalFileWriteRead(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	f, cleanup := newTestJournalFile(t)
+	defer cleanup()
+
+	// no journal yet
+	j, err := f.Read(ctx)
+	require.NoError(err)
+	require.Empty(j.Manifests)
+
+	j.Insert("layer", "sha256:a")
+	j.InsertManifestLayers("app", "layer")
+	require.NoError(f.Write(j))
+	j.InsertManifestLayers("other", "layer")
+	require.NoError(f.Write(j))
+
+	data, err := ioutil.ReadFile(f.path)
+	require.NoError(err)
+	require.True(strings.HasPrefix(string(data), `{"version":2,"checksum":"sha256:`))
+
+	loaded, err := f.Read(ctx)
+	require.NoError(err)
+	require.Equal(j.UUID, loaded.UUID)
+	require.Equal(j.Manifests, loaded.Manifests)
+
+	// the previous copy is the backup
+	backup, _, err := decodeJournal(mustReadFile(t, f.backupPath()))
+	require.NoError(err)
+	require.Equal(map[string]string{"app": "layer"}, map[string]string(backup.Manifests))
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
+
+func TestJournalFileRecoversFromBackup(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	f, cleanup := newTestJournalFile(t)
+	defer cleanup()
+
+	j := newJournal()
+	j.InsertManifestLayers("app", "layer")
+	require.NoError(f.Write(j))
+	require.NoError(f.Write(j))
+
+	// a flipped byte of the body breaks the checksum
+	data := mustReadFile(t, f.path)
+	corrupted := strings.Replace(string(data), `"app"`, `"apq"`, 1)
+	require.NoError(ioutil.WriteFile(f.path, []byte(corrupted), 0644))
+	_, _, err := decodeJournal([]byte(corrupted))
+	require.Error(err)
+
+	loaded, err := f.Read(ctx)
+	require.NoError(err)
+	require.Equal(j.Manifests, loaded.Manifests)
+	require.Equal(corrupted, string(mustReadFile(t, f.path+journalCorruptSuffix)))
+
+	// the corrupted journal doesn't become the backup
+	require.NoError(f.Write(loaded))
+	_, _, err = decodeJournal(mustReadFile(t, f.backupPath()))
+	require.NoError(err)
+}
+
+func TestJournalFileNoUsableCopy(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	f, cleanup := newTestJournalFile(t)
+	defer cleanup()
+
+	require.NoError(ioutil.WriteFile(f.path, []byte(`{"uuid": "trunc`), 0644))
+	j, err := f.Read(ctx)
+	require.NoError(err)
+	require.Empty(j.Manifests)
+	_, err = os.Stat(f.path + journalCorruptSuffix)
+	require.NoError(err)
+
+	// a journal of a newer box isn't touched
+	require.NoError(ioutil.WriteFile(f.path, []byte(`{"version": 3, "checksum": "", "body": {}}`), 0644))
+	_, err = f.Read(ctx)
+	require.EqualError(err, "journal version 3 is newer than supported 2")
+}
+
+func TestJournalFileMigrates(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	f, cleanup := newTestJournalFile(t)
+	defer cleanup()
+
+	legacy := `{"uuid":"uuid","layers":{"layer":"sha256:a"},"manifests":{"app":"layer"}}`
+	require.NoError(ioutil.WriteFile(f.path, []byte(legacy), 0644))
+
+	j, err := f.Read(ctx)
+	require.NoError(err)
+	require.Equal("uuid", j.UUID)
+	require.Equal("layer", j.Manifests["app"])
+	require.NotNil(j.Images)
+
+	require.NoError(f.Write(j))
+	migrated, version, err := decodeJournal(mustReadFile(t, f.path))
+	require.NoError(err)
+	require.Equal(journalVersion, version)
+	require.Equal(j.Manifests, migrated.Manifests)
+}
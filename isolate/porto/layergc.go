@@ -0,0 +1,159 @@
+package porto
+
+import (
+	"strconv"
+	"time"
+
+	apexlog "github.com/apex/log"
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/events"
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	defaultLayerGCIntervalSec = 600
+	defaultLayerGCMaxAgeSec   = 7 * 24 * 3600
+	defaultPortoPlace         = "/place"
+)
+
+// layerGCConfig describes collection of layers imported by the box
+type layerGCConfig struct {
+	Enabled bool `json:"enabled"`
+	// DryRun only logs and publishes what would be removed
+	DryRun      bool `json:"dryrun"`
+	IntervalSec uint `json:"intervalsec"`
+	// MaxAgeSec is the time since the last use after which manifests and unreferenced layers are removed
+	MaxAgeSec uint `json:"maxagesec"`
+	// Place is the directory where Portod keeps layers
+	Place string `json:"place"`
+	// MinFreeSpace makes GC remove unreferenced layers regardless of their age while Place has less free bytes
+	MinFreeSpace uint64 `json:"minfreespace"`
+}
+
+func (b *Box) layerGCLoop(ctx context.Context) {
+	interval := time.Duration(b.config.LayerGC.IntervalSec) * time.Second
+	for {
+		select {
+		case <-time.After(interval):
+			if err := b.collectLayers(ctx); err != nil {
+				log.G(ctx).WithError(err).Warn("layer GC failed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runningLayers returns apps and layers of tracked containers
+func (b *Box) runningLayers() (apps map[string]struct{}, layers map[string]struct{}) {
+	apps, layers = make(map[string]struct{}), make(map[string]struct{})
+	b.muContainers.Lock()
+	defer b.muContainers.Unlock()
+	for _, c := range b.containers {
+		apps[c.appname] = struct{}{}
+		for _, layer := range c.layers {
+			layers[layer] = struct{}{}
+		}
+	}
+	return apps, layers
+}
+
+// collectLayers removes manifests of apps unused for MaxAgeSec and layers referenced by none of manifests.
+// A layer is removed if it hasn't been used for MaxAgeSec or if Place is short of free space
+func (b *Box) collectLayers(ctx context.Context) (err error) {
+	cfg := &b.config.LayerGC
+	defer log.G(ctx).WithField("dryrun", cfg.DryRun).Trace("collect layers").Stop(&err)
+	defer layerGCTimer.UpdateSince(time.Now())
+
+	now := time.Now()
+	maxAge := time.Duration(cfg.MaxAgeSec) * time.Second
+	runningApps, runningLayers := b.runningLayers()
+	// layers of spools in flight aren't referenced by manifests yet
+	b.staged.copyTo(runningLayers)
+
+	var stale []string
+	if maxAge > 0 {
+		stale = b.journal.RemoveStaleManifests(now.Add(-maxAge), runningApps, cfg.DryRun)
+		for _, app := range stale {
+			log.G(ctx).WithFields(apexlog.Fields{"app": app, "dryrun": cfg.DryRun}).Info("remove stale manifest")
+			if !cfg.DryRun {
+				manifestsCollectedCounter.Inc(1)
+			}
+			events.Publish(events.Event{
+				Type:  events.GCManifestRemoved,
+				Box:   b.boxName,
+				App:   app,
+				Attrs: map[string]string{"dryrun": strconv.FormatBool(cfg.DryRun)},
+			})
+		}
+	}
+
+	candidates := b.journal.Unreferenced(runningLayers, stale)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	portoConn, err := b.portoPool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer portoConn.Close()
+
+	lowSpace := func() bool {
+		if cfg.MinFreeSpace == 0 {
+			return false
+		}
+		free, err := isolate.FreeSpace(cfg.Place)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("place", cfg.Place).Warn("unable to get free space")
+			return false
+		}
+		return free < cfg.MinFreeSpace
+	}
+
+	for _, layer := range candidates {
+		if maxAge == 0 || now.Sub(layer.LastUsed) < maxAge {
+			if !lowSpace() {
+				continue
+			}
+		}
+
+		logger := log.G(ctx).WithFields(apexlog.Fields{"layer": layer.Name, "lastused": layer.LastUsed, "dryrun": cfg.DryRun})
+		var removeErr error
+		if !cfg.DryRun {
+			// a spool may have reused the layer since the candidates were found
+			var removed bool
+			removed, removeErr = b.staged.removeUnused(layer.Name, b.journal.Referenced, func(name string) error {
+				err := portoConn.RemoveLayer(name)
+				if err == nil || isEqualPortoError(err, portorpc.EError_LayerNotFound) {
+					b.journal.RemoveLayer(name)
+					return nil
+				}
+				return err
+			})
+			switch {
+			case !removed:
+				logger.Info("layer has been reused, skip it")
+				continue
+			case removeErr == nil:
+				layersCollectedCounter.Inc(1)
+			default:
+				logger.WithError(removeErr).Warn("unable to remove layer")
+			}
+		}
+		if removeErr == nil {
+			logger.Info("remove unreferenced layer")
+		}
+		events.Publish(events.Event{
+			Type:  events.GCLayerRemoved,
+			Box:   b.boxName,
+			Attrs: map[string]string{"layer": layer.Name, "dryrun": strconv.FormatBool(cfg.DryRun)},
+		}.WithError(removeErr))
+	}
+
+	journalContent.Set(b.journal.String())
+	return nil
+}
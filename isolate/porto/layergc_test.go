@@ -0,0 +1,146 @@
+package porto
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	porto "github.com/yandex/porto/src/api/go"
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+	"golang.org/x/net/context"
+)
+
+type fakeLayersConn struct {
+	porto.API
+
+	mu      sync.Mutex
+	removed []string
+	// onRemove is called before a layer is removed
+	onRemove func(layer string)
+}
+
+func (f *fakeLayersConn) RemoveLayer(layer string) error {
+	if f.onRemove != nil {
+		f.onRemove(layer)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if layer == "missing" {
+		return &porto.Error{Errno: portorpc.EError_LayerNotFound, ErrName: "LayerNotFound"}
+	}
+	f.removed = append(f.removed, layer)
+	return nil
+}
+
+func (f *fakeLayersConn) GetVersion() (string, string, error) { return "v4", "rev", nil }
+func (f *fakeLayersConn) Close() error                        { return nil }
+
+func newLayerGCTestBox(conn *fakeLayersConn, gc layerGCConfig) *Box {
+	pool := newConnPool(portoConnConfig{HealthCheckSec: 3600})
+	pool.dial = func() (porto.API, error) { return conn, nil }
+	return &Box{
+		Name:       "porto",
+		boxName:    "porto",
+		config:     &portoBoxConfig{LayerGC: gc},
+		journal:    newJournal(),
+		staged:     newStagedLayers(),
+		containers: make(map[string]*container),
+		portoPool:  pool,
+	}
+}
+
+func TestCollectLayers(t *testing.T) {
+	require := require.New(t)
+
+	conn := &fakeLayersConn{}
+	b := newLayerGCTestBox(conn, layerGCConfig{MaxAgeSec: 3600})
+	defer b.portoPool.Close()
+
+	old := time.Now().Add(-2 * time.Hour)
+	b.journal.Insert("base", "sha256:base").Insert("old", "sha256:old").Insert("fresh", "sha256:fresh").
+		Insert("running", "sha256:running").Insert("missing", "sha256:missing")
+	b.journal.InsertManifestLayers("stale", "base;old")
+	b.journal.InsertManifestLayers("app", "base")
+	b.journal.AppsUsed["stale"] = old
+	b.journal.LayersUsed["old"] = old
+	b.journal.LayersUsed["running"] = old
+	b.journal.LayersUsed["missing"] = old
+	b.containers["c"] = &container{appname: "previous", layers: []string{"running"}}
+	// a spool in flight has imported the layer, but hasn't committed it yet
+	b.journal.Insert("staged", "sha256:staged")
+	b.journal.LayersUsed["staged"] = old
+	b.staged.add("staged")
+
+	require.NoError(b.collectLayers(context.Background()))
+
+	require.Equal([]string{"old"}, conn.removed)
+	require.Empty(b.journal.GetManifestLayers("stale"))
+	require.Equal("base", b.journal.GetManifestLayers("app"))
+	for _, layer := range []string{"base", "fresh", "running", "staged"} {
+		require.Contains(b.journal.Layers, layer)
+	}
+	require.NotContains(b.journal.Layers, "old")
+	require.NotContains(b.journal.Layers, "missing")
+}
+
+func TestCollectLayersDryRun(t *testing.T) {
+	require := require.New(t)
+
+	conn := &fakeLayersConn{}
+	b := newLayerGCTestBox(conn, layerGCConfig{MaxAgeSec: 3600, DryRun: true})
+	defer b.portoPool.Close()
+
+	b.journal.Insert("old", "sha256:old")
+	b.journal.InsertManifestLayers("stale", "old")
+	b.journal.AppsUsed["stale"] = time.Now().Add(-2 * time.Hour)
+	b.journal.LayersUsed["old"] = time.Now().Add(-2 * time.Hour)
+
+	require.NoError(b.collectLayers(context.Background()))
+	require.Empty(conn.removed)
+	require.Equal("old", b.journal.GetManifestLayers("stale"))
+	require.Contains(b.journal.Layers, "old")
+}
+
+func TestCollectLayersReused(t *testing.T) {
+	require := require.New(t)
+
+	conn := &fakeLayersConn{}
+	b := newLayerGCTestBox(conn, layerGCConfig{MaxAgeSec: 3600})
+	defer b.portoPool.Close()
+
+	b.journal.Insert("first", "sha256:first").Insert("second", "sha256:second")
+	b.journal.LayersUsed["first"] = time.Now().Add(-3 * time.Hour)
+	b.journal.LayersUsed["second"] = time.Now().Add(-2 * time.Hour)
+	// a spool reuses the second candidate while the first one is being removed
+	conn.onRemove = func(layer string) {
+		if layer == "first" {
+			b.journal.InsertManifestLayers("app", "second")
+		}
+	}
+
+	require.NoError(b.collectLayers(context.Background()))
+	require.Equal([]string{"first"}, conn.removed)
+	require.Contains(b.journal.Layers, "second")
+}
+
+func TestStagedLayersRemoveUnused(t *testing.T) {
+	require := require.New(t)
+
+	staged := newStagedLayers()
+	staged.add("staged")
+	unused := func(string) bool { return false }
+	var removed []string
+	remove := func(layer string) error {
+		removed = append(removed, layer)
+		return nil
+	}
+
+	ok, err := staged.removeUnused("staged", unused, remove)
+	require.NoError(err)
+	require.False(ok)
+	ok, err = staged.removeUnused("free", unused, remove)
+	require.NoError(err)
+	require.True(ok)
+	require.Equal([]string{"free"}, removed)
+}
@@ -0,0 +1,217 @@
+package porto
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/schema2"
+)
+
+const (
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	mediaTypeOCILayer                       = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeOCILayerGzip                   = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeOCILayerNonDistributable       = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	mediaTypeOCILayerNonDistributableGzip   = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	mediaTypeDockerLayerUncompressed        = "application/vnd.docker.image.rootfs.diff.tar"
+	mediaTypeDockerForeignLayerUncompressed = "application/vnd.docker.image.rootfs.foreign.diff.tar"
+
+	// an index may point to another index, but not deeper
+	maxManifestIndexDepth = 2
+)
+
+// layerMediaTypes are tarballs Portod imports. Portod detects gzip by the content
+var layerMediaTypes = map[string]struct{}{
+	"":                                      {},
+	schema2.MediaTypeLayer:                  {},
+	schema2.MediaTypeForeignLayer:           {},
+	mediaTypeDockerLayerUncompressed:        {},
+	mediaTypeDockerForeignLayerUncompressed: {},
+	mediaTypeOCILayer:                       {},
+	mediaTypeOCILayerGzip:                   {},
+	mediaTypeOCILayerNonDistributable:       {},
+	mediaTypeOCILayerNonDistributableGzip:   {},
+}
+
+func init() {
+	ociFunc := func(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+		m := new(ociManifest)
+		if err := m.UnmarshalJSON(b); err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+		return m, distribution.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b)), MediaType: mediaTypeOCIManifest}, nil
+	}
+	if err := distribution.RegisterManifestSchema(mediaTypeOCIManifest, ociFunc); err != nil {
+		panic(fmt.Sprintf("Unable to register manifest: %s", err))
+	}
+
+	for _, mediaType := range []string{mediaTypeOCIIndex, mediaTypeManifestList} {
+		mediaType := mediaType
+		indexFunc := func(b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+			m := new(manifestIndex)
+			if err := m.UnmarshalJSON(b); err != nil {
+				return nil, distribution.Descriptor{}, err
+			}
+			if m.MediaType == "" {
+				m.MediaType = mediaType
+			}
+			return m, distribution.Descriptor{Digest: digest.FromBytes(b), Size: int64(len(b)), MediaType: mediaType}, nil
+		}
+		if err := distribution.RegisterManifestSchema(mediaType, indexFunc); err != nil {
+			panic(fmt.Sprintf("Unable to register manifest: %s", err))
+		}
+	}
+}
+
+// ociManifest is an OCI image manifest. Its layout matches schema2, but layers have OCI media types
+type ociManifest struct {
+	manifest.Versioned
+
+	Config      distribution.Descriptor   `json:"config"`
+	Layers      []distribution.Descriptor `json:"layers"`
+	Annotations map[string]string         `json:"annotations,omitempty"`
+
+	canonical []byte
+}
+
+func (m *ociManifest) UnmarshalJSON(b []byte) error {
+	type plain ociManifest
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*m = ociManifest(p)
+	m.canonical = append([]byte(nil), b...)
+	if m.MediaType == "" {
+		m.MediaType = mediaTypeOCIManifest
+	}
+	return nil
+}
+
+func (m *ociManifest) References() []distribution.Descriptor {
+	return m.Layers
+}
+
+func (m *ociManifest) Payload() (string, []byte, error) {
+	return m.MediaType, m.canonical, nil
+}
+
+// platform describes an image of an index
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// parsePlatform parses os/arch[/variant]. The host platform is returned for an empty string
+func parsePlatform(s string) (platform, error) {
+	if s == "" {
+		return platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return platform{}, fmt.Errorf("invalid platform %q, os/arch[/variant] is expected", s)
+	}
+	p := platform{OS: parts[0], Architecture: normalizeArch(parts[1])}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "i386", "i686":
+		return "386"
+	}
+	return arch
+}
+
+// matches tells if an image of the platform runs on p. Variants are compared if both are set
+func (p platform) matches(image platform) bool {
+	if image.OS != p.OS || normalizeArch(image.Architecture) != p.Architecture {
+		return false
+	}
+	return p.Variant == "" || image.Variant == "" || p.Variant == image.Variant
+}
+
+func (p platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+type indexDescriptor struct {
+	distribution.Descriptor
+	Platform *platform `json:"platform,omitempty"`
+}
+
+// manifestIndex is an OCI image index or a Docker manifest list
+type manifestIndex struct {
+	manifest.Versioned
+
+	Manifests []indexDescriptor `json:"manifests"`
+
+	canonical []byte
+}
+
+func (m *manifestIndex) UnmarshalJSON(b []byte) error {
+	type plain manifestIndex
+	var p plain
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*m = manifestIndex(p)
+	m.canonical = append([]byte(nil), b...)
+	return nil
+}
+
+func (m *manifestIndex) References() []distribution.Descriptor {
+	references := make([]distribution.Descriptor, 0, len(m.Manifests))
+	for _, d := range m.Manifests {
+		references = append(references, d.Descriptor)
+	}
+	return references
+}
+
+func (m *manifestIndex) Payload() (string, []byte, error) {
+	return m.MediaType, m.canonical, nil
+}
+
+// Select returns the first manifest of the platform
+func (m *manifestIndex) Select(p platform) (digest.Digest, error) {
+	var available []string
+	for _, d := range m.Manifests {
+		if d.Platform == nil {
+			continue
+		}
+		if p.matches(*d.Platform) {
+			return d.Digest, nil
+		}
+		available = append(available, d.Platform.String())
+	}
+	return "", fmt.Errorf("no image for platform %s, available: %s", p, strings.Join(available, ", "))
+}
+
+// checkLayers fails if Portod is unable to import some of layers
+func checkLayers(references []distribution.Descriptor) error {
+	for _, d := range references {
+		if _, ok := layerMediaTypes[d.MediaType]; !ok {
+			return fmt.Errorf("layer %s has unsupported media type %s", d.Digest, d.MediaType)
+		}
+	}
+	return nil
+}
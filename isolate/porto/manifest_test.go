@@ -0,0 +1,97 @@
+package porto
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/stretchr/testify/require"
+)
+
+const ociManifestJSON = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+  "config": {"mediaType": "application/vnd.oci.image.config.v1+json", "size": 7023, "digest": "sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7"},
+  "layers": [
+    {"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "size": 32654, "digest": "sha256:9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0"},
+    {"mediaType": "application/vnd.oci.image.layer.v1.tar", "size": 16724, "digest": "sha256:3c3a4604a545cdc127456d94e421cd355bca5b528f4a9c1905b15da2eb4a4c6b"}
+  ]
+}`
+
+const manifestListJSON = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+  "manifests": [
+    {"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": 7143, "digest": "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f", "platform": {"architecture": "ppc64le", "os": "linux"}},
+    {"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": 7682, "digest": "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270", "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}},
+    {"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": 7682, "digest": "sha256:1f2b2b6c3dc1b1e8f5c2b5b6e6a8d2a3a7d0b2c6f1e0a9d8c7b6a5f4e3d2c1b0", "platform": {"architecture": "amd64", "os": "linux"}}
+  ]
+}`
+
+func TestOCIManifest(t *testing.T) {
+	require := require.New(t)
+
+	m, desc, err := distribution.UnmarshalManifest(mediaTypeOCIManifest, []byte(ociManifestJSON))
+	require.NoError(err)
+	require.Equal(mediaTypeOCIManifest, desc.MediaType)
+
+	oci, ok := m.(*ociManifest)
+	require.True(ok)
+	require.Len(oci.References(), 2)
+	require.NoError(checkLayers(oci.References()))
+
+	mediaType, payload, err := m.Payload()
+	require.NoError(err)
+	require.Equal(mediaTypeOCIManifest, mediaType)
+	require.Equal(ociManifestJSON, string(payload))
+
+	require.Contains(distribution.ManifestMediaTypes(), mediaTypeOCIIndex)
+	require.Contains(distribution.ManifestMediaTypes(), mediaTypeManifestList)
+}
+
+func TestCheckLayersRejectsUnsupported(t *testing.T) {
+	err := checkLayers([]distribution.Descriptor{
+		{MediaType: mediaTypeOCILayerGzip},
+		{MediaType: "application/vnd.oci.image.layer.v1.tar+zstd", Digest: "sha256:abc"},
+	})
+	require.EqualError(t, err, "layer sha256:abc has unsupported media type application/vnd.oci.image.layer.v1.tar+zstd")
+}
+
+func TestManifestIndexSelect(t *testing.T) {
+	require := require.New(t)
+
+	m, _, err := distribution.UnmarshalManifest(mediaTypeManifestList, []byte(manifestListJSON))
+	require.NoError(err)
+	index, ok := m.(*manifestIndex)
+	require.True(ok)
+	require.Len(index.References(), 3)
+
+	p, err := parsePlatform("linux/x86_64")
+	require.NoError(err)
+	dgst, err := index.Select(p)
+	require.NoError(err)
+	require.EqualValues("sha256:1f2b2b6c3dc1b1e8f5c2b5b6e6a8d2a3a7d0b2c6f1e0a9d8c7b6a5f4e3d2c1b0", dgst)
+
+	// a variant matches images without one and images of the same variant
+	p, err = parsePlatform("linux/aarch64/v8")
+	require.NoError(err)
+	dgst, err = index.Select(p)
+	require.NoError(err)
+	require.EqualValues("sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270", dgst)
+
+	p, err = parsePlatform("linux/arm64/v9")
+	require.NoError(err)
+	_, err = index.Select(p)
+	require.EqualError(err, "no image for platform linux/arm64/v9, available: linux/ppc64le, linux/arm64/v8, linux/amd64")
+}
+
+func TestParsePlatform(t *testing.T) {
+	p, err := parsePlatform("")
+	require.NoError(t, err)
+	require.Equal(t, platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}, p)
+
+	for _, invalid := range []string{"linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		_, err = parsePlatform(invalid)
+		require.Error(t, err, invalid)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"expvar"
 
 	"github.com/rcrowley/go-metrics"
+
+	"github.com/noxiouz/stout/pkg/mirror"
+	"github.com/noxiouz/stout/pkg/semaphore"
 )
 
 var (
@@ -17,6 +20,60 @@ var (
 	containersKilledCounter  = metrics.NewCounter()
 
 	totalSpawnTimer = metrics.NewTimer()
+	// blob repository
+	blobHitsCounter      = metrics.NewCounter()
+	blobMissesCounter    = metrics.NewCounter()
+	blobEvictionsCounter = metrics.NewCounter()
+	blobBytesGauge       = metrics.NewGauge()
+	// rate of downloading blobs
+	blobFetchedBytesMeter     = metrics.NewMeter()
+	blobFetchRetriesCounter   = metrics.NewCounter()
+	blobDigestMismatchCounter = metrics.NewCounter()
+
+	// bearer tokens of registries
+	registryTokenFetchesCounter = metrics.NewCounter()
+	registryTokenErrorsCounter  = metrics.NewCounter()
+
+	// failovers between registries and their mirrors
+	mirrorMetrics = mirror.NewMetrics()
+
+	// layer GC
+	layersCollectedCounter    = metrics.NewCounter()
+	manifestsCollectedCounter = metrics.NewCounter()
+	layerGCTimer              = metrics.NewTimer()
+
+	// writes of the journal file
+	journalWriteTimer         = metrics.NewTimer()
+	journalWriteErrorsCounter = metrics.NewCounter()
+	journalRecoveredCounter   = metrics.NewCounter()
+
+	// spools committed to the journal and rolled back with layers they have created
+	spoolCommitsCounter          = metrics.NewCounter()
+	spoolRollbacksCounter        = metrics.NewCounter()
+	spoolLayersRolledBackCounter = metrics.NewCounter()
+
+	// output of containers sent to the runtime and dropped by Portod before it has been read
+	outputBytesCounter          = metrics.NewCounter()
+	outputTruncatedBytesCounter = metrics.NewCounter()
+	outputReadErrorsCounter     = metrics.NewCounter()
+
+	// time between a death of a container and its detection
+	deathDetectionTimer = metrics.NewTimer()
+
+	spawnSMMetrics       = semaphore.NewMetrics()
+	spawnAdaptiveMetrics = semaphore.NewAdaptiveMetrics()
+
+	// concurrent downloads of layers
+	fetchSMMetrics  = semaphore.NewMetrics()
+	layerFetchTimer = metrics.NewTimer()
+
+	// connections to Portod
+	poolInUseGauge          = metrics.NewGauge()
+	poolIdleGauge           = metrics.NewGauge()
+	poolConnectsCounter     = metrics.NewCounter()
+	poolConnectErrorCounter = metrics.NewCounter()
+	poolBrokenCounter       = metrics.NewCounter()
+	poolWaitTimer           = metrics.NewTimer()
 
 	portoConfig    = expvar.NewString("porto_config")
 	journalContent = expvar.NewString("porto_journal")
@@ -29,4 +86,37 @@ func init() {
 	registry.Register("containers_errored", containersErroredCounter)
 	registry.Register("containers_killed", containersKilledCounter)
 	registry.Register("total_spawn_timer", totalSpawnTimer)
+	registry.Register("death_detection_timer", deathDetectionTimer)
+	registry.Register("journal_write_timer", journalWriteTimer)
+	registry.Register("journal_write_errors", journalWriteErrorsCounter)
+	registry.Register("journal_recovered", journalRecoveredCounter)
+	registry.Register("spool_commits", spoolCommitsCounter)
+	registry.Register("spool_rollbacks", spoolRollbacksCounter)
+	registry.Register("spool_layers_rolled_back", spoolLayersRolledBackCounter)
+	registry.Register("output_bytes", outputBytesCounter)
+	registry.Register("output_truncated_bytes", outputTruncatedBytesCounter)
+	registry.Register("output_read_errors", outputReadErrorsCounter)
+	registry.Register("gc_layers_removed", layersCollectedCounter)
+	registry.Register("gc_manifests_removed", manifestsCollectedCounter)
+	registry.Register("gc_layers_timer", layerGCTimer)
+	registry.Register("blobs_hits", blobHitsCounter)
+	registry.Register("blobs_misses", blobMissesCounter)
+	registry.Register("blobs_evictions", blobEvictionsCounter)
+	registry.Register("blobs_bytes", blobBytesGauge)
+	registry.Register("blobs_fetched_bytes", blobFetchedBytesMeter)
+	registry.Register("blobs_fetch_retries", blobFetchRetriesCounter)
+	registry.Register("blobs_digest_mismatches", blobDigestMismatchCounter)
+	registry.Register("registry_token_fetches", registryTokenFetchesCounter)
+	registry.Register("registry_token_errors", registryTokenErrorsCounter)
+	mirrorMetrics.Register(registry, "registry_mirrors_")
+	spawnSMMetrics.Register(registry, "spawn_sm_")
+	spawnAdaptiveMetrics.Register(registry, "spawn_adaptive_")
+	fetchSMMetrics.Register(registry, "fetch_sm_")
+	registry.Register("layer_fetch_timer", layerFetchTimer)
+	registry.Register("pool_in_use", poolInUseGauge)
+	registry.Register("pool_idle", poolIdleGauge)
+	registry.Register("pool_connects", poolConnectsCounter)
+	registry.Register("pool_connect_errors", poolConnectErrorCounter)
+	registry.Register("pool_broken", poolBrokenCounter)
+	registry.Register("pool_wait_timer", poolWaitTimer)
 }
@@ -0,0 +1,220 @@
+package porto
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	apexlog "github.com/apex/log"
+	"github.com/docker/distribution"
+	distctx "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/transport"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+	"github.com/noxiouz/stout/pkg/mirror"
+)
+
+// registryTransport authorizes requests to the host with its static auth or with bearer tokens
+func (b *Box) registryTransport(host string, name string) http.RoundTripper {
+	if registryAuth, ok := b.config.RegistryAuth[host]; ok {
+		return transport.NewTransport(b.transport, transport.NewHeaderRequestModifier(http.Header{
+			"Authorization": []string{registryAuth.Value()},
+		}))
+	}
+	return newTokenTransport(b.transport, b.registryTokens, b.config.RegistryCredentials[host], name)
+}
+
+func registryURL(host string) string {
+	if !strings.HasPrefix(host, "http") {
+		return "https://" + host
+	}
+	return host
+}
+
+// mirroredRepository is a repository of an image in a registry and its mirrors.
+// The embedded Repository is the one of the registry
+type mirroredRepository struct {
+	distribution.Repository
+
+	pool      *mirror.Pool
+	endpoints []*mirror.Endpoint
+	repos     []distribution.Repository
+
+	mu sync.Mutex
+	// failed are endpoints which broke a download of this repository, they are tried last
+	failed map[*mirror.Endpoint]bool
+}
+
+func (b *Box) newMirroredRepository(ctx context.Context, named reference.Named, registry string) (*mirroredRepository, error) {
+	r := &mirroredRepository{
+		pool:      b.mirrors,
+		endpoints: b.mirrors.Endpoints(registry),
+		failed:    make(map[*mirror.Endpoint]bool),
+	}
+	for _, endpoint := range r.endpoints {
+		repo, err := client.NewRepository(ctx, named, registryURL(endpoint.Host), b.registryTransport(endpoint.Host, named.Name()))
+		if err != nil {
+			return nil, err
+		}
+		r.repos = append(r.repos, repo)
+		if endpoint.Primary {
+			r.Repository = repo
+		}
+	}
+	return r, nil
+}
+
+// do calls fn with repositories in the order of endpoints
+func (r *mirroredRepository) do(ctx context.Context, order []int, fn func(endpoint *mirror.Endpoint, repo distribution.Repository) error) error {
+	endpoints := make([]*mirror.Endpoint, 0, len(order))
+	for _, i := range order {
+		endpoints = append(endpoints, r.endpoints[i])
+	}
+	return r.pool.Do(ctx, endpoints, func(i int) error {
+		return fn(endpoints[i], r.repos[order[i]])
+	})
+}
+
+// primaryFirst is the order of resolving tags, the registry is the source of truth
+func (r *mirroredRepository) primaryFirst() []int {
+	order := make([]int, 0, len(r.endpoints))
+	for i, endpoint := range r.endpoints {
+		if endpoint.Primary {
+			order = append([]int{i}, order...)
+		} else {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// mirrorsFirst is the order of fetching content, endpoints failed during this spool go last
+func (r *mirroredRepository) mirrorsFirst() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := make([]int, 0, len(r.endpoints))
+	var failed []int
+	for i, endpoint := range r.endpoints {
+		if r.failed[endpoint] {
+			failed = append(failed, i)
+		} else {
+			order = append(order, i)
+		}
+	}
+	return append(order, failed...)
+}
+
+func (r *mirroredRepository) markFailed(endpoint *mirror.Endpoint) {
+	r.mu.Lock()
+	r.failed[endpoint] = true
+	r.mu.Unlock()
+}
+
+// Resolve returns the digest of the tag. A mirror resolves it only if the registry is unavailable
+func (r *mirroredRepository) Resolve(ctx context.Context, tag string) (dgst digest.Digest, err error) {
+	err = r.do(ctx, r.primaryFirst(), func(endpoint *mirror.Endpoint, repo distribution.Repository) error {
+		descriptor, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("endpoint", endpoint.Host).Warn("unable to resolve the tag")
+			return err
+		}
+		if !endpoint.Primary {
+			log.G(ctx).WithFields(apexlog.Fields{"endpoint": endpoint.Host, "tag": tag}).Warn("the registry is unavailable, the tag is resolved by a mirror")
+		}
+		dgst = descriptor.Digest
+		return nil
+	})
+	return dgst, err
+}
+
+// Manifest fetches the manifest from the first available endpoint and verifies its digest
+func (r *mirroredRepository) Manifest(ctx context.Context, dgst digest.Digest) (m distribution.Manifest, err error) {
+	err = r.do(ctx, r.mirrorsFirst(), func(endpoint *mirror.Endpoint, repo distribution.Repository) error {
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			return err
+		}
+		if m, err = manifests.Get(ctx, dgst); err == nil {
+			err = verifyManifest(m, dgst)
+		}
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("endpoint", endpoint.Host).Warn("unable to fetch the manifest")
+		}
+		return err
+	})
+	return m, err
+}
+
+// verifyManifest checks that the manifest has the digest. Signatures of schema1 manifests aren't hashed
+func verifyManifest(m distribution.Manifest, dgst digest.Digest) error {
+	var payload []byte
+	if signed, ok := m.(*schema1.SignedManifest); ok {
+		payload = signed.Canonical
+	} else {
+		var err error
+		if _, payload, err = m.Payload(); err != nil {
+			return err
+		}
+	}
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	if actual := dgst.Algorithm().FromBytes(payload); actual != dgst {
+		return fmt.Errorf("manifest digest %s doesn't match %s", actual, dgst)
+	}
+	return nil
+}
+
+func (r *mirroredRepository) Blobs(ctx distctx.Context) distribution.BlobStore {
+	return &mirroredBlobStore{BlobStore: r.Repository.Blobs(ctx), repo: r}
+}
+
+// mirroredBlobStore opens blobs from the first endpoint which has them
+type mirroredBlobStore struct {
+	distribution.BlobStore
+	repo *mirroredRepository
+}
+
+func (s *mirroredBlobStore) Open(ctx distctx.Context, dgst digest.Digest) (rsc distribution.ReadSeekCloser, err error) {
+	err = s.repo.do(ctx, s.repo.mirrorsFirst(), func(endpoint *mirror.Endpoint, repo distribution.Repository) error {
+		blobs := repo.Blobs(ctx)
+		// Open is lazy, Stat checks that the endpoint is alive and has the blob
+		if _, err := blobs.Stat(ctx, dgst); err != nil {
+			log.G(ctx).WithError(err).WithFields(apexlog.Fields{"endpoint": endpoint.Host, "digest": dgst}).Warn("unable to open the blob")
+			return err
+		}
+		blob, err := blobs.Open(ctx, dgst)
+		if err != nil {
+			return err
+		}
+		rsc = &endpointReader{ReadSeekCloser: blob, ctx: ctx, endpoint: endpoint, repo: s.repo}
+		return nil
+	})
+	return rsc, err
+}
+
+// endpointReader reports broken downloads to the breaker of the endpoint
+type endpointReader struct {
+	distribution.ReadSeekCloser
+	ctx      context.Context
+	endpoint *mirror.Endpoint
+	repo     *mirroredRepository
+}
+
+func (r *endpointReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeekCloser.Read(p)
+	if err != nil && err != io.EOF && err != transport.ErrWrongCodeForByteRange {
+		r.endpoint.Report(r.ctx, err)
+		if r.ctx.Err() == nil {
+			r.repo.markFailed(r.endpoint)
+		}
+	}
+	return n, err
+}
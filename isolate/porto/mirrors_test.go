@@ -0,0 +1,175 @@
+package porto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/reference"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/mirror"
+)
+
+// fakeContentRegistry serves manifests and blobs of the repository "app"
+type fakeContentRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	tags      map[string]digest.Digest
+	manifests map[digest.Digest][]byte
+	blobs     map[digest.Digest][]byte
+	requests  []string
+}
+
+func newFakeContentRegistry() *fakeContentRegistry {
+	s := &fakeContentRegistry{
+		tags:      make(map[string]digest.Digest),
+		manifests: make(map[digest.Digest][]byte),
+		blobs:     make(map[digest.Digest][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *fakeContentRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v2/app/manifests/"):
+		ref := strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/")
+		dgst, ok := s.tags[ref]
+		if !ok {
+			dgst = digest.Digest(ref)
+		}
+		body, ok := s.manifests[dgst]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if r.Method == "GET" {
+			w.Write(body)
+		}
+	case strings.HasPrefix(r.URL.Path, "/v2/app/blobs/"):
+		dgst := digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/app/blobs/"))
+		body, ok := s.blobs[dgst]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if r.Method == "GET" {
+			w.Write(body)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *fakeContentRegistry) push(tag string, manifest []byte, blobs ...[]byte) digest.Digest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dgst := digest.FromBytes(manifest)
+	s.tags[tag] = dgst
+	s.manifests[dgst] = manifest
+	for _, blob := range blobs {
+		s.blobs[digest.FromBytes(blob)] = blob
+	}
+	return dgst
+}
+
+func (s *fakeContentRegistry) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func newMirrorsTestBox(registry string, mirrors ...string) *Box {
+	return &Box{
+		config:         &portoBoxConfig{},
+		transport:      &http.Transport{},
+		registryTokens: newTokenCache(http.DefaultTransport),
+		mirrors: mirror.NewPool(mirror.Config{
+			Mirrors:          map[string][]string{registry: mirrors},
+			FailureThreshold: 1,
+		}, nil),
+	}
+}
+
+func TestMirroredRepository(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	blob := []byte("layer")
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"digest":%q}]}`, mediaTypeOCIManifest, digest.FromBytes(blob)))
+
+	primary := newFakeContentRegistry()
+	defer primary.Close()
+	dgst := primary.push("latest", manifest)
+	// the first mirror is down
+	down := newFakeContentRegistry()
+	down.Close()
+	// the second one has the image and a tampered manifest under the tag
+	healthy := newFakeContentRegistry()
+	defer healthy.Close()
+	healthy.push(dgst.String(), manifest, blob)
+	tampered := digest.FromBytes([]byte("tampered"))
+	healthy.tags["latest"] = tampered
+	healthy.manifests[tampered] = []byte(`{"schemaVersion":2}`)
+
+	b := newMirrorsTestBox(primary.URL, down.URL, healthy.URL)
+	named, err := reference.ParseNamed("app")
+	require.NoError(err)
+	repo, err := b.newMirroredRepository(ctx, named, primary.URL)
+	require.NoError(err)
+
+	// the registry resolves tags
+	resolved, err := repo.Resolve(ctx, "latest")
+	require.NoError(err)
+	require.Equal(dgst, resolved)
+
+	m, err := repo.Manifest(ctx, resolved)
+	require.NoError(err)
+	require.Len(m.References(), 1)
+
+	rsc, err := repo.Blobs(ctx).Open(ctx, digest.FromBytes(blob))
+	require.NoError(err)
+	content, err := ioutil.ReadAll(rsc)
+	rsc.Close()
+	require.NoError(err)
+	require.Equal(blob, content)
+
+	// content hasn't been requested from the registry
+	require.Equal([]string{"HEAD /v2/app/manifests/latest"}, primary.Requests())
+	require.Equal(mirror.Open, b.mirrors.Endpoints(primary.URL)[0].State())
+
+	// a mirror resolves the tag when the registry is down, the digest of its manifest is verified
+	primary.Close()
+	resolved, err = repo.Resolve(ctx, "latest")
+	require.NoError(err)
+	require.Equal(tampered, resolved)
+	_, err = repo.Manifest(ctx, resolved)
+	require.EqualError(err, fmt.Sprintf("manifest digest %s doesn't match %s", digest.FromBytes([]byte(`{"schemaVersion":2}`)), tampered))
+}
+
+func TestVerifyManifest(t *testing.T) {
+	m, desc, err := distribution.UnmarshalManifest(mediaTypeOCIManifest, []byte(ociManifestJSON))
+	require.NoError(t, err)
+	require.NoError(t, verifyManifest(m, desc.Digest))
+	other := digest.FromBytes([]byte("other"))
+	require.EqualError(t, verifyManifest(m, other), fmt.Sprintf("manifest digest %s doesn't match %s", desc.Digest, other))
+}
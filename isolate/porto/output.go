@@ -0,0 +1,276 @@
+package porto
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	apexlog "github.com/apex/log"
+	porto "github.com/yandex/porto/src/api/go"
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	defaultOutputPollMs    = 1000
+	defaultOutputReadChunk = 1 << 20
+)
+
+var outputStreams = []string{"stdout", "stderr"}
+
+type outputConfig struct {
+	// Period of reading the output of running containers. Zero disables live output,
+	// then the output is sent when a container dies
+	PollMs uint `json:"pollms"`
+	// Max bytes of a stream read with one request
+	ReadChunk uint64 `json:"readchunk"`
+}
+
+// outputFollower streams stdout and stderr of a container to the runtime.
+// Portod keeps only the last stdout_limit bytes of a stream, so streams are read from absolute offsets
+// and bytes dropped by Portod before they have been read are skipped
+type outputFollower struct {
+	ctx    context.Context
+	id     string
+	config outputConfig
+
+	mu     sync.Mutex
+	output io.Writer
+	// offsets of the next unread bytes of streams
+	offsets map[string]uint64
+	// Portod doesn't support reads from offsets, streams are read at once at death
+	legacy    bool
+	following bool
+	flushed   bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newOutputFollower(ctx context.Context, id string, config outputConfig) *outputFollower {
+	if config.ReadChunk == 0 {
+		config.ReadChunk = defaultOutputReadChunk
+	}
+	return &outputFollower{
+		ctx:     ctx,
+		id:      id,
+		config:  config,
+		output:  ioutil.Discard,
+		offsets: make(map[string]uint64, len(outputStreams)),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Follow starts streaming the output of the started container
+func (f *outputFollower) Follow(pool *connPool, output io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.output = output
+	select {
+	case <-f.stop:
+		// the container has already been killed
+		return
+	default:
+	}
+	if f.config.PollMs == 0 {
+		return
+	}
+	f.following = true
+	go f.loop(pool)
+}
+
+func (f *outputFollower) loop(pool *connPool) {
+	defer close(f.done)
+	ticker := time.NewTicker(time.Duration(f.config.PollMs) * time.Millisecond)
+	defer ticker.Stop()
+	logger := log.G(f.ctx).WithField("id", f.id)
+	// the spawn context is canceled when the runtime disconnects, connections are waited for until Flush
+	connCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-f.stop:
+			cancel()
+		case <-connCtx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-f.ctx.Done():
+			// the runtime has gone, nobody reads the output
+			return
+		case <-ticker.C:
+		}
+
+		portoConn, err := pool.Get(connCtx)
+		switch {
+		case err == errPoolClosed, connCtx.Err() != nil:
+			return
+		case err != nil:
+			logger.WithError(err).Debug("unable to get a connection to read the output")
+			continue
+		}
+		f.mu.Lock()
+		_, err = f.read(portoConn)
+		legacy := f.legacy
+		f.mu.Unlock()
+		portoConn.Close()
+
+		switch {
+		case legacy:
+			logger.Warn("Portod doesn't support reads of the output from offsets, the output is sent at death")
+			return
+		case isEqualPortoError(err, portorpc.EError_ContainerDoesNotExist):
+			return
+		case err != nil:
+			outputReadErrorsCounter.Inc(1)
+			logger.WithError(err).Debug("unable to read the output")
+		}
+	}
+}
+
+// Flush stops following and sends the rest of the output. Later calls do nothing
+func (f *outputFollower) Flush(portoConn porto.API) {
+	f.stopOnce.Do(func() { close(f.stop) })
+	f.mu.Lock()
+	following := f.following
+	f.mu.Unlock()
+	if following {
+		<-f.done
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flushed {
+		return
+	}
+	f.flushed = true
+
+	logger := log.G(f.ctx).WithField("id", f.id)
+	sent, err := f.read(portoConn)
+	if err != nil {
+		outputReadErrorsCounter.Inc(1)
+		logger.WithError(err).Warn("unable to read the rest of the output")
+	}
+	for _, stream := range outputStreams {
+		logger.Infof("%d bytes of %s have been sent", sent[stream], stream)
+	}
+}
+
+// read sends new bytes of streams. It must be called under the lock
+func (f *outputFollower) read(portoConn porto.API) (map[string]int, error) {
+	sent := make(map[string]int, len(outputStreams))
+	var (
+		stored map[string]uint64
+		err    error
+	)
+	if !f.legacy {
+		stored, err = f.storedOffsets(portoConn)
+	}
+	if f.legacy {
+		// whole streams are read once at death
+		if !f.flushed {
+			return sent, err
+		}
+		return sent, f.readAll(portoConn, sent)
+	}
+	if err != nil {
+		return sent, err
+	}
+
+	for _, stream := range outputStreams {
+		if sent[stream], err = f.readStream(portoConn, stream, stored[stream]); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func (f *outputFollower) readAll(portoConn porto.API, sent map[string]int) error {
+	for _, stream := range outputStreams {
+		value, err := portoConn.GetData(f.id, stream)
+		if err != nil {
+			return err
+		}
+		f.write(value)
+		sent[stream] = len(value)
+	}
+	return nil
+}
+
+// storedOffsets returns offsets of the first bytes of streams kept by Portod
+func (f *outputFollower) storedOffsets(portoConn porto.API) (map[string]uint64, error) {
+	properties := make([]string, 0, len(outputStreams))
+	for _, stream := range outputStreams {
+		properties = append(properties, stream+"_offset")
+	}
+	data, err := portoConn.Get([]string{f.id}, properties)
+	if isEqualPortoError(err, portorpc.EError_InvalidProperty) {
+		f.legacy = true
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]uint64, len(outputStreams))
+	for _, stream := range outputStreams {
+		value := data[f.id][stream+"_offset"]
+		switch portorpc.EError(value.Error) {
+		case portorpc.EError_Success:
+		case portorpc.EError_InvalidProperty:
+			f.legacy = true
+			return nil, fmt.Errorf("%s_offset is not supported: %s", stream, value.ErrorMsg)
+		default:
+			return nil, &porto.Error{Errno: portorpc.EError(value.Error), ErrName: portorpc.EError(value.Error).String(), Message: value.ErrorMsg}
+		}
+		if stored[stream], err = strconv.ParseUint(value.Value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s_offset %q: %v", stream, value.Value, err)
+		}
+	}
+	return stored, nil
+}
+
+func (f *outputFollower) readStream(portoConn porto.API, stream string, stored uint64) (int, error) {
+	offset := f.offsets[stream]
+	if offset < stored {
+		lost := stored - offset
+		outputTruncatedBytesCounter.Inc(int64(lost))
+		log.G(f.ctx).WithFields(apexlog.Fields{"id": f.id, "stream": stream, "bytes": lost}).
+			Warn("output has been dropped by Portod over stdout_limit before it has been read")
+		offset = stored
+	}
+
+	var sent int
+	defer func() { f.offsets[stream] = offset }()
+	for {
+		value, err := portoConn.GetData(f.id, fmt.Sprintf("%s[%d:%d]", stream, offset, f.config.ReadChunk))
+		if err != nil {
+			return sent, err
+		}
+		f.write(value)
+		sent += len(value)
+		offset += uint64(len(value))
+		if uint64(len(value)) < f.config.ReadChunk {
+			return sent, nil
+		}
+	}
+}
+
+func (f *outputFollower) write(value string) {
+	if len(value) == 0 {
+		return
+	}
+	// TODO: add StringWriter interface to an output
+	f.output.Write([]byte(value))
+	outputBytesCounter.Inc(int64(len(value)))
+}
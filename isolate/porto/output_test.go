@@ -0,0 +1,196 @@
+package porto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	porto "github.com/yandex/porto/src/api/go"
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+	"golang.org/x/net/context"
+)
+
+// fakeOutputConn keeps streams like Portod: the first dropped bytes are counted by the offset
+type fakeOutputConn struct {
+	porto.API
+
+	mu      sync.Mutex
+	data    map[string]string
+	offsets map[string]uint64
+	legacy  bool
+}
+
+func newFakeOutputConn() *fakeOutputConn {
+	return &fakeOutputConn{data: make(map[string]string), offsets: make(map[string]uint64)}
+}
+
+func (f *fakeOutputConn) Append(stream, value string) {
+	f.mu.Lock()
+	f.data[stream] += value
+	f.mu.Unlock()
+}
+
+// Drop drops the first n stored bytes of the stream as stdout_limit does
+func (f *fakeOutputConn) Drop(stream string, n int) {
+	f.mu.Lock()
+	f.data[stream] = f.data[stream][n:]
+	f.offsets[stream] += uint64(n)
+	f.mu.Unlock()
+}
+
+func (f *fakeOutputConn) Get(containers []string, variables []string) (map[string]map[string]porto.TPortoGetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.legacy {
+		return nil, &porto.Error{Errno: portorpc.EError_InvalidProperty, ErrName: "InvalidProperty"}
+	}
+	values := make(map[string]porto.TPortoGetResponse)
+	for _, variable := range variables {
+		stream := strings.TrimSuffix(variable, "_offset")
+		values[variable] = porto.TPortoGetResponse{Value: strconv.FormatUint(f.offsets[stream], 10)}
+	}
+	return map[string]map[string]porto.TPortoGetResponse{containers[0]: values}, nil
+}
+
+func (f *fakeOutputConn) GetData(name string, data string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var (
+		stream         string
+		offset, length uint64
+	)
+	if _, err := fmt.Sscanf(strings.NewReplacer("[", " ", ":", " ", "]", "").Replace(data), "%s %d %d", &stream, &offset, &length); err != nil {
+		if f.legacy {
+			return f.data[data], nil
+		}
+		return "", err
+	}
+	value := f.data[stream][offset-f.offsets[stream]:]
+	if uint64(len(value)) > length {
+		value = value[:length]
+	}
+	return value, nil
+}
+
+func (f *fakeOutputConn) GetVersion() (string, string, error) { return "v4", "rev", nil }
+func (f *fakeOutputConn) Close() error                        { return nil }
+
+type syncBuffer struct {
+	mu   sync.Mutex
+	buff bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buff.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buff.String()
+}
+
+func TestOutputFollowerRead(t *testing.T) {
+	require := require.New(t)
+	conn := newFakeOutputConn()
+	var output syncBuffer
+	f := newOutputFollower(context.Background(), "c", outputConfig{ReadChunk: 4})
+	f.output = &output
+
+	conn.Append("stdout", "hello ")
+	conn.Append("stderr", "oops")
+	sent, err := f.read(conn)
+	require.NoError(err)
+	require.Equal(map[string]int{"stdout": 6, "stderr": 4}, sent)
+	require.Equal("hello oops", output.String())
+
+	// only new bytes are sent
+	conn.Append("stdout", "world")
+	_, err = f.read(conn)
+	require.NoError(err)
+	require.Equal("hello oopsworld", output.String())
+
+	// bytes dropped over the limit are skipped
+	truncated := outputTruncatedBytesCounter.Count()
+	conn.Append("stdout", "0123456789")
+	conn.Drop("stdout", 16)
+	_, err = f.read(conn)
+	require.NoError(err)
+	require.Equal("hello oopsworld56789", output.String())
+	require.Equal(truncated+5, outputTruncatedBytesCounter.Count())
+}
+
+func TestOutputFollowerFlush(t *testing.T) {
+	require := require.New(t)
+	conn := newFakeOutputConn()
+	pool := newConnPool(portoConnConfig{HealthCheckSec: 3600})
+	pool.dial = func() (porto.API, error) { return conn, nil }
+	defer pool.Close()
+
+	var output syncBuffer
+	f := newOutputFollower(context.Background(), "c", outputConfig{PollMs: 1})
+	f.Follow(pool, &output)
+
+	conn.Append("stdout", "live")
+	for i := 0; i < 1000 && output.String() == ""; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal("live", output.String())
+
+	// the rest is sent at death
+	conn.Append("stderr", " dead")
+	f.Flush(conn)
+	require.Equal("live dead", output.String())
+
+	conn.Append("stdout", " again")
+	f.Flush(conn)
+	require.Equal("live dead", output.String())
+}
+
+func TestOutputFollowerLegacy(t *testing.T) {
+	require := require.New(t)
+	conn := newFakeOutputConn()
+	conn.legacy = true
+	conn.Append("stdout", "out")
+	conn.Append("stderr", "err")
+
+	var output syncBuffer
+	f := newOutputFollower(context.Background(), "c", outputConfig{PollMs: 1})
+	f.output = &output
+
+	// streams of old Portod are read only at death
+	_, err := f.read(conn)
+	require.Error(err)
+	require.True(f.legacy)
+	require.Empty(output.String())
+
+	f.Flush(conn)
+	require.Equal("outerr", output.String())
+}
+
+func TestOutputFollowerStopsWithSpawn(t *testing.T) {
+	conn := newFakeOutputConn()
+	pool := newConnPool(portoConnConfig{HealthCheckSec: 3600})
+	pool.dial = func() (porto.API, error) { return conn, nil }
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := newOutputFollower(ctx, "c", outputConfig{PollMs: 1})
+	f.Follow(pool, ioutil.Discard)
+
+	// the loop exits without Flush once the runtime disconnects
+	cancel()
+	select {
+	case <-f.done:
+	case <-time.After(time.Second):
+		t.Fatal("output follower is still running")
+	}
+}
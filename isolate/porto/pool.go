@@ -0,0 +1,264 @@
+package porto
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	porto "github.com/yandex/porto/src/api/go"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	defaultPortoPoolSize       = 16
+	defaultPortoConnectRetries = 10
+	defaultPortoHealthCheckSec = 30
+
+	// waitLoop keeps one connection and kills dead containers with another one
+	minPortoPoolSize = 2
+
+	portoConnectBackoff    = 100 * time.Millisecond
+	maxPortoConnectBackoff = 5 * time.Second
+)
+
+var errPoolClosed = errors.New("porto connection pool is closed")
+
+// portoConnConfig describes connections to Portod
+type portoConnConfig struct {
+	// PoolSize limits the number of connections used at the same time
+	PoolSize       int `json:"poolsize"`
+	ConnectRetries int `json:"connectretries"`
+	// HealthCheckSec is the period of checking idle connections with GetVersion
+	HealthCheckSec uint `json:"healthchecksec"`
+}
+
+type idleConn struct {
+	conn    porto.API
+	checked time.Time
+}
+
+// connPool keeps connections to Portod. Get blocks if PoolSize connections are in use
+type connPool struct {
+	cfg  portoConnConfig
+	dial func() (porto.API, error)
+
+	slots chan struct{}
+	done  chan struct{}
+
+	mu     sync.Mutex
+	idle   []idleConn
+	closed bool
+}
+
+func newConnPool(cfg portoConnConfig) *connPool {
+	switch {
+	case cfg.PoolSize <= 0:
+		cfg.PoolSize = defaultPortoPoolSize
+	case cfg.PoolSize < minPortoPoolSize:
+		cfg.PoolSize = minPortoPoolSize
+	}
+	if cfg.ConnectRetries < 0 {
+		cfg.ConnectRetries = 0
+	}
+	if cfg.HealthCheckSec == 0 {
+		cfg.HealthCheckSec = defaultPortoHealthCheckSec
+	}
+
+	p := &connPool{
+		cfg: cfg,
+		dial: func() (porto.API, error) {
+			return porto.Connect()
+		},
+		slots: make(chan struct{}, cfg.PoolSize),
+		done:  make(chan struct{}),
+	}
+	go p.healthCheckLoop()
+	return p
+}
+
+// Get returns a connection. Close of the connection puts it back to the pool
+func (p *connPool) Get(ctx context.Context) (*pooledConn, error) {
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+		poolWaitTimer.UpdateSince(start)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+		return nil, errPoolClosed
+	}
+
+	conn, err := p.get(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	poolInUseGauge.Update(int64(len(p.slots)))
+	return &pooledConn{API: conn, pool: p}, nil
+}
+
+func (p *connPool) get(ctx context.Context) (porto.API, error) {
+	interval := p.healthCheckInterval()
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			return p.connect(ctx)
+		}
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		poolIdleGauge.Update(int64(len(p.idle)))
+		p.mu.Unlock()
+
+		// the connection has been idle longer than the health check loop sleeps
+		if time.Since(last.checked) > interval && !p.healthy(ctx, last.conn) {
+			continue
+		}
+		return last.conn, nil
+	}
+}
+
+// connect dials Portod retrying with capped exponential backoff
+func (p *connPool) connect(ctx context.Context) (porto.API, error) {
+	backoff := portoConnectBackoff
+	for attempt := 0; ; attempt++ {
+		conn, err := p.dial()
+		if err == nil {
+			poolConnectsCounter.Inc(1)
+			return conn, nil
+		}
+		poolConnectErrorCounter.Inc(1)
+		if attempt >= p.cfg.ConnectRetries {
+			return nil, err
+		}
+
+		log.G(ctx).WithError(err).Warnf("unable to connect to Portod, retry in %v", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.done:
+			return nil, errPoolClosed
+		}
+		if backoff *= 2; backoff > maxPortoConnectBackoff {
+			backoff = maxPortoConnectBackoff
+		}
+	}
+}
+
+func (p *connPool) healthy(ctx context.Context, conn porto.API) bool {
+	if _, _, err := conn.GetVersion(); err != nil {
+		log.G(ctx).WithError(err).Debug("drop broken connection to Portod")
+		poolBrokenCounter.Inc(1)
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+func (p *connPool) healthCheckInterval() time.Duration {
+	return time.Duration(p.cfg.HealthCheckSec) * time.Second
+}
+
+// put returns a connection. Broken connections and connections returned after Close are closed
+func (p *connPool) put(conn porto.API, broken bool) {
+	defer func() {
+		<-p.slots
+		poolInUseGauge.Update(int64(len(p.slots)))
+	}()
+
+	if broken {
+		poolBrokenCounter.Inc(1)
+		conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.cfg.PoolSize {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn: conn, checked: time.Now()})
+	poolIdleGauge.Update(int64(len(p.idle)))
+}
+
+func (p *connPool) healthCheckLoop() {
+	ctx := context.Background()
+	ticker := time.NewTicker(p.healthCheckInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+
+		p.mu.Lock()
+		idle := p.idle
+		p.idle = nil
+		p.mu.Unlock()
+
+		alive := idle[:0]
+		for _, c := range idle {
+			if p.healthy(ctx, c.conn) {
+				alive = append(alive, idleConn{conn: c.conn, checked: time.Now()})
+			}
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			for _, c := range alive {
+				c.conn.Close()
+			}
+		} else {
+			p.idle = append(p.idle, alive...)
+		}
+		poolIdleGauge.Update(int64(len(p.idle)))
+		p.mu.Unlock()
+	}
+}
+
+// Close closes idle connections. Connections in use are closed when they are returned
+func (p *connPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for _, c := range p.idle {
+		c.conn.Close()
+	}
+	p.idle = nil
+	poolIdleGauge.Update(0)
+	return nil
+}
+
+// pooledConn is a connection taken from connPool
+type pooledConn struct {
+	porto.API
+	pool   *connPool
+	once   sync.Once
+	broken bool
+}
+
+// Discard marks the connection as broken, so Close closes it instead of returning to the pool
+func (c *pooledConn) Discard() {
+	c.broken = true
+}
+
+// Close returns the connection to the pool
+func (c *pooledConn) Close() error {
+	c.once.Do(func() {
+		c.pool.put(c.API, c.broken)
+	})
+	return nil
+}
@@ -0,0 +1,152 @@
+package porto
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	porto "github.com/yandex/porto/src/api/go"
+	"golang.org/x/net/context"
+)
+
+type fakePortoConn struct {
+	porto.API
+	broken int32
+	closed int32
+}
+
+func (f *fakePortoConn) GetVersion() (string, string, error) {
+	if atomic.LoadInt32(&f.broken) == 1 {
+		return "", "", errors.New("broken pipe")
+	}
+	return "v4", "rev", nil
+}
+
+func (f *fakePortoConn) Close() error {
+	atomic.StoreInt32(&f.closed, 1)
+	return nil
+}
+
+func newTestPool(size, retries int, dial func() (porto.API, error)) *connPool {
+	p := newConnPool(portoConnConfig{PoolSize: size, ConnectRetries: retries, HealthCheckSec: 3600})
+	p.dial = dial
+	return p
+}
+
+func TestConnPoolReuse(t *testing.T) {
+	require := require.New(t)
+
+	var dials int32
+	p := newTestPool(2, 0, func() (porto.API, error) {
+		atomic.AddInt32(&dials, 1)
+		return &fakePortoConn{}, nil
+	})
+	defer p.Close()
+
+	ctx := context.Background()
+	first, err := p.Get(ctx)
+	require.NoError(err)
+	raw := first.API
+	first.Close()
+	// double Close must not release the slot twice
+	first.Close()
+
+	second, err := p.Get(ctx)
+	require.NoError(err)
+	require.Equal(raw, second.API)
+	second.Discard()
+	second.Close()
+	require.Equal(int32(1), atomic.LoadInt32(&raw.(*fakePortoConn).closed))
+
+	third, err := p.Get(ctx)
+	require.NoError(err)
+	third.Close()
+	require.Equal(int32(2), atomic.LoadInt32(&dials))
+}
+
+func TestConnPoolBounded(t *testing.T) {
+	require := require.New(t)
+
+	// the size is raised to the minimal one
+	p := newTestPool(1, 0, func() (porto.API, error) {
+		return &fakePortoConn{}, nil
+	})
+
+	conn, err := p.Get(context.Background())
+	require.NoError(err)
+	other, err := p.Get(context.Background())
+	require.NoError(err)
+	defer other.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx)
+	require.Equal(context.DeadlineExceeded, err)
+
+	conn.Close()
+	conn, err = p.Get(context.Background())
+	require.NoError(err)
+
+	p.Close()
+	_, err = p.Get(context.Background())
+	require.Equal(errPoolClosed, err)
+
+	// a connection returned after Close is closed
+	raw := conn.API.(*fakePortoConn)
+	conn.Close()
+	require.Equal(int32(1), atomic.LoadInt32(&raw.closed))
+}
+
+func TestConnPoolRetries(t *testing.T) {
+	require := require.New(t)
+
+	var dials int32
+	p := newTestPool(1, 2, func() (porto.API, error) {
+		if atomic.AddInt32(&dials, 1) < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return &fakePortoConn{}, nil
+	})
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.NoError(err)
+	conn.Close()
+	require.Equal(int32(3), atomic.LoadInt32(&dials))
+
+	p.dial = func() (porto.API, error) {
+		return nil, errors.New("connection refused")
+	}
+	p.mu.Lock()
+	p.idle = nil
+	p.mu.Unlock()
+	_, err = p.Get(context.Background())
+	require.Error(err)
+}
+
+func TestConnPoolDropsBrokenIdle(t *testing.T) {
+	require := require.New(t)
+
+	p := newTestPool(1, 0, func() (porto.API, error) {
+		return &fakePortoConn{}, nil
+	})
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	require.NoError(err)
+	broken := conn.API.(*fakePortoConn)
+	atomic.StoreInt32(&broken.broken, 1)
+	conn.Close()
+	// pretend the health check loop has missed the connection
+	p.mu.Lock()
+	p.idle[0].checked = time.Time{}
+	p.mu.Unlock()
+
+	conn, err = p.Get(context.Background())
+	require.NoError(err)
+	require.NotEqual(broken, conn.API)
+	require.Equal(int32(1), atomic.LoadInt32(&broken.closed))
+	conn.Close()
+}
@@ -25,6 +25,10 @@ type Layer struct {
 type Profile struct {
 	Registry   string `msg:"registry"`
 	Repository string `msg:"repository"`
+	// Image is name[:tag] or name@sha256:... in Repository. The app name is used by default
+	Image string `msg:"image"`
+	// Source is file:///path of a docker save archive or an OCI layout to spool instead of Registry
+	Source string `msg:"source"`
 
 	NetworkMode string `msg:"network_mode"`
 	Network map[string]string `msg:"network"`
@@ -360,6 +360,18 @@ func (z *Profile) DecodeMsg(dc *msgp.Reader) (err error) {
 				err = msgp.WrapError(err, "Repository")
 				return
 			}
+		case "image":
+			z.Image, err = dc.ReadString()
+			if err != nil {
+				err = msgp.WrapError(err, "Image")
+				return
+			}
+		case "source":
+			z.Source, err = dc.ReadString()
+			if err != nil {
+				err = msgp.WrapError(err, "Source")
+				return
+			}
 		case "network_mode":
 			z.NetworkMode, err = dc.ReadString()
 			if err != nil {
@@ -555,9 +567,9 @@ func (z *Profile) DecodeMsg(dc *msgp.Reader) (err error) {
 
 // EncodeMsg implements msgp.Encodable
 func (z *Profile) EncodeMsg(en *msgp.Writer) (err error) {
-	// map header, size 10
+	// map header, size 12
 	// write "registry"
-	err = en.Append(0x8a, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
+	err = en.Append(0x8c, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
 	if err != nil {
 		return
 	}
@@ -576,6 +588,26 @@ func (z *Profile) EncodeMsg(en *msgp.Writer) (err error) {
 		err = msgp.WrapError(err, "Repository")
 		return
 	}
+	// write "image"
+	err = en.Append(0xa5, 0x69, 0x6d, 0x61, 0x67, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Image)
+	if err != nil {
+		err = msgp.WrapError(err, "Image")
+		return
+	}
+	// write "source"
+	err = en.Append(0xa6, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Source)
+	if err != nil {
+		err = msgp.WrapError(err, "Source")
+		return
+	}
 	// write "network_mode"
 	err = en.Append(0xac, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x5f, 0x6d, 0x6f, 0x64, 0x65)
 	if err != nil {
@@ -609,9 +641,13 @@ func (z *Profile) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 	// write "extended_info"
+	err = en.Append(0xad, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x5f, 0x69, 0x6e, 0x66, 0x6f)
+	if err != nil {
+		return
+	}
 	// map header, size 1
 	// write "layers"
-	err = en.Append(0xad, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x81, 0xa6, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x73)
+	err = en.Append(0x81, 0xa6, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x73)
 	if err != nil {
 		return
 	}
@@ -721,13 +757,19 @@ func (z *Profile) EncodeMsg(en *msgp.Writer) (err error) {
 // MarshalMsg implements msgp.Marshaler
 func (z *Profile) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
-	// map header, size 10
+	// map header, size 12
 	// string "registry"
-	o = append(o, 0x8a, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
+	o = append(o, 0x8c, 0xa8, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79)
 	o = msgp.AppendString(o, z.Registry)
 	// string "repository"
 	o = append(o, 0xaa, 0x72, 0x65, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x6f, 0x72, 0x79)
 	o = msgp.AppendString(o, z.Repository)
+	// string "image"
+	o = append(o, 0xa5, 0x69, 0x6d, 0x61, 0x67, 0x65)
+	o = msgp.AppendString(o, z.Image)
+	// string "source"
+	o = append(o, 0xa6, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65)
+	o = msgp.AppendString(o, z.Source)
 	// string "network_mode"
 	o = append(o, 0xac, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x5f, 0x6d, 0x6f, 0x64, 0x65)
 	o = msgp.AppendString(o, z.NetworkMode)
@@ -739,9 +781,10 @@ func (z *Profile) MarshalMsg(b []byte) (o []byte, err error) {
 		o = msgp.AppendString(o, za0002)
 	}
 	// string "extended_info"
+	o = append(o, 0xad, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x5f, 0x69, 0x6e, 0x66, 0x6f)
 	// map header, size 1
 	// string "layers"
-	o = append(o, 0xad, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x81, 0xa6, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x73)
+	o = append(o, 0x81, 0xa6, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x73)
 	o = msgp.AppendArrayHeader(o, uint32(len(z.ExtendedInfo.Layers)))
 	for za0003 := range z.ExtendedInfo.Layers {
 		o, err = z.ExtendedInfo.Layers[za0003].MarshalMsg(o)
@@ -816,6 +859,18 @@ func (z *Profile) UnmarshalMsg(bts []byte) (o []byte, err error) {
 				err = msgp.WrapError(err, "Repository")
 				return
 			}
+		case "image":
+			z.Image, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Image")
+				return
+			}
+		case "source":
+			z.Source, bts, err = msgp.ReadStringBytes(bts)
+			if err != nil {
+				err = msgp.WrapError(err, "Source")
+				return
+			}
 		case "network_mode":
 			z.NetworkMode, bts, err = msgp.ReadStringBytes(bts)
 			if err != nil {
@@ -1012,7 +1067,7 @@ func (z *Profile) UnmarshalMsg(bts []byte) (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *Profile) Msgsize() (s int) {
-	s = 1 + 9 + msgp.StringPrefixSize + len(z.Registry) + 11 + msgp.StringPrefixSize + len(z.Repository) + 13 + msgp.StringPrefixSize + len(z.NetworkMode) + 8 + msgp.MapHeaderSize
+	s = 1 + 9 + msgp.StringPrefixSize + len(z.Registry) + 11 + msgp.StringPrefixSize + len(z.Repository) + 6 + msgp.StringPrefixSize + len(z.Image) + 7 + msgp.StringPrefixSize + len(z.Source) + 13 + msgp.StringPrefixSize + len(z.NetworkMode) + 8 + msgp.MapHeaderSize
 	if z.Network != nil {
 		for za0001, za0002 := range z.Network {
 			_ = za0002
@@ -3,14 +3,20 @@ package porto
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
+	apexlog "github.com/apex/log"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/client/transport"
 
+	"github.com/noxiouz/stout/isolate"
 	"github.com/noxiouz/stout/pkg/log"
 	"golang.org/x/net/context"
 )
@@ -21,17 +27,47 @@ type asyncSpoolResult struct {
 }
 
 type BlobRepository interface {
-	Get(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (string, error)
+	Get(ctx context.Context, blobs distribution.BlobProvider, dgst digest.Digest) (string, error)
+	// Pin protects blobs from eviction until the returned function is called
+	Pin(dgsts ...digest.Digest) func()
+	// Remove removes blobs which are neither pinned nor being downloaded
+	Remove(ctx context.Context, dgsts ...digest.Digest)
 }
 
 type BlobRepositoryConfig struct {
 	SpoolPath string `json:"spool"`
+	// MaxSize is the budget of blobs in bytes. Zero means unlimited
+	MaxSize uint64 `json:"maxsize"`
+	// MinFreeSpace makes the repository evict blobs while the spool has less free bytes
+	MinFreeSpace uint64 `json:"minfreespace"`
+	// FetchRetries is the number of resumed attempts to download a blob
+	FetchRetries int `json:"fetchretries"`
+}
+
+const defaultBlobFetchRetries = 3
+
+type blobEntry struct {
+	size       int64
+	lastAccess time.Time
+}
+
+// blobDownload is a fetch of a blob shared by spools waiting for it.
+// It's canceled when all of them have gone away
+type blobDownload struct {
+	waiters map[chan asyncSpoolResult]struct{}
+	cancel  context.CancelFunc
 }
 
 type blobRepo struct {
 	mu sync.Mutex
 	BlobRepositoryConfig
-	inProgress map[digest.Digest][]chan asyncSpoolResult
+	inProgress map[digest.Digest]*blobDownload
+
+	blobs  map[digest.Digest]*blobEntry
+	pinned map[digest.Digest]int
+	size   int64
+
+	freeSpace func(dir string) (uint64, error)
 }
 
 func NewBlobRepository(ctx context.Context, cfg BlobRepositoryConfig) (BlobRepository, error) {
@@ -45,85 +81,380 @@ func NewBlobRepository(ctx context.Context, cfg BlobRepositoryConfig) (BlobRepos
 
 	s := &blobRepo{
 		BlobRepositoryConfig: cfg,
-		inProgress:           make(map[digest.Digest][]chan asyncSpoolResult),
+		inProgress:           make(map[digest.Digest]*blobDownload),
+		blobs:                make(map[digest.Digest]*blobEntry),
+		pinned:               make(map[digest.Digest]int),
+		freeSpace:            isolate.FreeSpace,
 	}
 
+	if err := s.scan(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.evict(ctx, "")
+	s.mu.Unlock()
+
 	return s, nil
 }
 
-func (r *blobRepo) Get(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (string, error) {
+// scan accounts blobs downloaded before. Files which are not named by a digest are ignored
+func (r *blobRepo) scan() error {
+	files, err := ioutil.ReadDir(r.SpoolPath)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		dgst, err := digest.ParseDigest(fi.Name())
+		if err != nil {
+			continue
+		}
+		r.add(dgst, fi.Size(), fi.ModTime())
+	}
+	return nil
+}
+
+func (r *blobRepo) Get(ctx context.Context, blobs distribution.BlobProvider, dgst digest.Digest) (string, error) {
+	ctx = log.WithComponent(ctx, "blobrepo")
 	log.G(ctx).WithField("digest", dgst).Info("get a blob from Repository")
-	path := filepath.Join(r.BlobRepositoryConfig.SpoolPath, dgst.String())
-	_, err := os.Lstat(path)
+	path := r.path(dgst)
+	fi, err := os.Lstat(path)
 	if err == nil {
 		log.G(ctx).WithField("digest", dgst).Info("the blob has already downloaded")
+		blobHitsCounter.Inc(1)
+		r.mu.Lock()
+		r.add(dgst, fi.Size(), time.Now())
+		r.mu.Unlock()
 		return path, nil
 	}
 	if !os.IsNotExist(err) {
 		return "", err
 	}
 
-	return r.download(ctx, repository, dgst)
+	blobMissesCounter.Inc(1)
+	return r.download(ctx, blobs, dgst)
 }
 
-func (r *blobRepo) download(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (string, error) {
-	ch := make(chan asyncSpoolResult, 1)
+func (r *blobRepo) Pin(dgsts ...digest.Digest) func() {
 	r.mu.Lock()
-	downloading, ok := r.inProgress[dgst]
-	r.inProgress[dgst] = append(downloading, ch)
+	for _, dgst := range dgsts {
+		r.pinned[dgst]++
+	}
 	r.mu.Unlock()
-	if !ok {
-		go func() {
-			log.G(ctx).WithField("digest", dgst).Info("fetching blob")
-			path, err := r.fetch(ctx, repository, dgst)
-			res := asyncSpoolResult{path: path, err: err}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
 			r.mu.Lock()
-			log.G(ctx).WithField("digest", dgst).Debug("push notifications")
-			for _, ch := range r.inProgress[dgst] {
-				ch <- res
+			defer r.mu.Unlock()
+			for _, dgst := range dgsts {
+				if r.pinned[dgst]--; r.pinned[dgst] <= 0 {
+					delete(r.pinned, dgst)
+				}
 			}
-			log.G(ctx).WithField("digest", dgst).Debug("clean notifications store")
-			delete(r.inProgress, dgst)
-			r.mu.Unlock()
-		}()
+			// pinned blobs might have kept the repository over the budget
+			r.evict(context.Background(), "")
+		})
+	}
+}
+
+func (r *blobRepo) Remove(ctx context.Context, dgsts ...digest.Digest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, dgst := range dgsts {
+		entry, ok := r.blobs[dgst]
+		if !ok {
+			continue
+		}
+		if _, ok := r.pinned[dgst]; ok {
+			continue
+		}
+		if _, ok := r.inProgress[dgst]; ok {
+			continue
+		}
+		if err := os.Remove(r.path(dgst)); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).WithField("digest", dgst).Error("unable to remove the blob")
+			continue
+		}
+		delete(r.blobs, dgst)
+		r.size -= entry.size
 	}
+	blobBytesGauge.Update(r.size)
+}
+
+func (r *blobRepo) path(dgst digest.Digest) string {
+	return filepath.Join(r.SpoolPath, dgst.String())
+}
+
+// add accounts the blob or updates its last access. It must be called under the lock
+func (r *blobRepo) add(dgst digest.Digest, size int64, accessed time.Time) {
+	if entry, ok := r.blobs[dgst]; ok {
+		r.size += size - entry.size
+		entry.size, entry.lastAccess = size, accessed
+	} else {
+		r.blobs[dgst] = &blobEntry{size: size, lastAccess: accessed}
+		r.size += size
+	}
+	blobBytesGauge.Update(r.size)
+}
+
+func (r *blobRepo) overBudget(ctx context.Context) bool {
+	if r.MaxSize > 0 && uint64(r.size) > r.MaxSize {
+		return true
+	}
+	if r.MinFreeSpace > 0 {
+		free, err := r.freeSpace(r.SpoolPath)
+		if err != nil {
+			log.G(ctx).WithError(err).Warn("unable to get free space of the spool")
+			return false
+		}
+		return free < r.MinFreeSpace
+	}
+	return false
+}
+
+// evict removes the least recently used blobs until the repository fits the budget.
+// Pinned blobs, blobs being downloaded and keep are never evicted. It must be called under the lock
+func (r *blobRepo) evict(ctx context.Context, keep digest.Digest) {
+	for r.overBudget(ctx) {
+		var (
+			victim digest.Digest
+			oldest *blobEntry
+		)
+		for dgst, entry := range r.blobs {
+			if _, ok := r.pinned[dgst]; ok || dgst == keep {
+				continue
+			}
+			if _, ok := r.inProgress[dgst]; ok {
+				continue
+			}
+			if oldest == nil || entry.lastAccess.Before(oldest.lastAccess) {
+				victim, oldest = dgst, entry
+			}
+		}
+		if oldest == nil {
+			log.G(ctx).WithField("bytes", r.size).Warn("blob repository is over the budget, but all blobs are in use")
+			return
+		}
+
+		logger := log.G(ctx).WithFields(apexlog.Fields{"digest": victim, "size": oldest.size, "lastaccess": oldest.lastAccess})
+		if err := os.Remove(r.path(victim)); err != nil && !os.IsNotExist(err) {
+			logger.WithError(err).Error("unable to evict the blob")
+			return
+		}
+		logger.Info("the blob has been evicted")
+		delete(r.blobs, victim)
+		r.size -= oldest.size
+		blobEvictionsCounter.Inc(1)
+		blobBytesGauge.Update(r.size)
+	}
+}
+
+func (r *blobRepo) download(ctx context.Context, blobs distribution.BlobProvider, dgst digest.Digest) (string, error) {
+	ch := make(chan asyncSpoolResult, 1)
+	r.mu.Lock()
+	d, ok := r.inProgress[dgst]
+	if !ok {
+		// the fetch outlives the spool which has started it, only the logger is inherited
+		fetchCtx, cancel := context.WithCancel(log.WithLogger(context.Background(), log.G(ctx)))
+		d = &blobDownload{waiters: make(map[chan asyncSpoolResult]struct{}), cancel: cancel}
+		r.inProgress[dgst] = d
+		go r.share(fetchCtx, blobs, dgst, d)
+	}
+	d.waiters[ch] = struct{}{}
+	r.mu.Unlock()
 
 	log.G(ctx).WithField("digest", dgst).Info("the blob downloading is in progress. Waiting")
 	select {
 	case <-ctx.Done():
+		r.mu.Lock()
+		delete(d.waiters, ch)
+		if len(d.waiters) == 0 && r.inProgress[dgst] == d {
+			log.G(ctx).WithField("digest", dgst).Info("nobody waits for the blob, cancel the download")
+			delete(r.inProgress, dgst)
+			d.cancel()
+		}
+		r.mu.Unlock()
 		return "", ctx.Err()
 	case res := <-ch:
 		return res.path, res.err
 	}
 }
 
-// fetch downloads the blob to a tempfile, renames it to the expected name
-func (r *blobRepo) fetch(ctx context.Context, repository distribution.Repository, dgst digest.Digest) (path string, err error) {
+// share fetches the blob and notifies waiters of the download
+func (r *blobRepo) share(ctx context.Context, blobs distribution.BlobProvider, dgst digest.Digest, d *blobDownload) {
+	defer d.cancel()
+	log.G(ctx).WithField("digest", dgst).Info("fetching blob")
+	path, err := r.fetch(ctx, blobs, dgst)
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = os.Stat(path)
+	}
+	res := asyncSpoolResult{path: path, err: err}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.add(dgst, fi.Size(), time.Now())
+		r.evict(ctx, dgst)
+	}
+	log.G(ctx).WithField("digest", dgst).Debug("push notifications")
+	for ch := range d.waiters {
+		ch <- res
+	}
+	log.G(ctx).WithField("digest", dgst).Debug("clean notifications store")
+	// a canceled download has been replaced already
+	if r.inProgress[dgst] == d {
+		delete(r.inProgress, dgst)
+	}
+}
+
+// partial returns a temp file of the blob left by an interrupted download.
+// A new name is returned if there is none. Extra partial files are removed
+func (r *blobRepo) partial(ctx context.Context, dgst digest.Digest) (string, error) {
+	matches, err := filepath.Glob(r.path(dgst) + "-*")
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		path string
+		size int64 = -1
+	)
+	for _, match := range matches {
+		fi, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if fi.Size() > size {
+			if path != "" {
+				os.Remove(path)
+			}
+			path, size = match, fi.Size()
+		} else {
+			os.Remove(match)
+		}
+	}
+
+	if path == "" {
+		return fmt.Sprintf("%s-%d", r.path(dgst), rand.Int63()), nil
+	}
+	log.G(ctx).WithFields(apexlog.Fields{"digest": dgst, "offset": size}).Info("resume the blob download")
+	return path, nil
+}
+
+// fetch downloads the blob to a tempfile verifying its digest, renames it to the expected name.
+// The tempfile is kept if the download fails, so the next fetch resumes it with a Range request
+func (r *blobRepo) fetch(ctx context.Context, blobs distribution.BlobProvider, dgst digest.Digest) (path string, err error) {
 	defer log.G(ctx).WithField("digest", dgst).Trace("fetch the blob").Stop(&err)
-	tempFilePath := filepath.Join(r.SpoolPath, fmt.Sprintf("%s-%d", dgst.String(), rand.Int63()))
-	f, err := os.Create(tempFilePath)
+	tempFilePath, err := r.partial(ctx, dgst)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(tempFilePath, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	defer os.Remove(tempFilePath)
 
-	blob, err := repository.Blobs(ctx).Open(ctx, dgst)
+	verifier, err := digest.NewDigestVerifier(dgst)
 	if err != nil {
 		return "", err
 	}
-	defer blob.Close()
-
-	if _, err = io.Copy(f, blob); err != nil {
+	// hash downloaded bytes, the file offset is left at the end
+	offset, err := io.Copy(verifier, f)
+	if err != nil {
 		return "", err
 	}
+
+	retries := r.FetchRetries
+	if retries <= 0 {
+		retries = defaultBlobFetchRetries
+	}
+	for attempt := 0; ; attempt++ {
+		var n int64
+		resumed := offset > 0
+		n, err = r.copyBlob(ctx, blobs, dgst, offset, io.MultiWriter(f, verifier))
+		offset += n
+		if err == nil {
+			break
+		}
+
+		if err == transport.ErrWrongCodeForByteRange || (resumed && n == 0 && ctx.Err() == nil && !isTransportError(err)) {
+			// e.g. 416 for a partial file left at the size of the blob
+			log.G(ctx).WithError(err).WithField("digest", dgst).Warn("unable to resume the blob download, download the blob from the start")
+			var restartErr error
+			if verifier, restartErr = r.restart(f, dgst); restartErr != nil {
+				return "", restartErr
+			}
+			offset = 0
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return "", err
+		}
+		blobFetchRetriesCounter.Inc(1)
+		log.G(ctx).WithError(err).WithFields(apexlog.Fields{"digest": dgst, "offset": offset, "attempt": attempt + 1}).Warn("retry the blob download")
+		select {
+		case <-time.After(time.Duration(attempt+1) * time.Second):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
 	f.Close()
-	blob.Close()
 
-	resultFilePath := filepath.Join(r.SpoolPath, dgst.String())
+	if !verifier.Verified() {
+		blobDigestMismatchCounter.Inc(1)
+		os.Remove(tempFilePath)
+		return "", fmt.Errorf("content of the blob doesn't match digest %s", dgst)
+	}
+
+	resultFilePath := r.path(dgst)
 	if err = os.Rename(tempFilePath, resultFilePath); err != nil {
 		return "", err
 	}
 
 	return resultFilePath, nil
 }
+
+// copyBlob copies the blob from the offset
+func (r *blobRepo) copyBlob(ctx context.Context, blobs distribution.BlobProvider, dgst digest.Digest, offset int64, w io.Writer) (int64, error) {
+	blob, err := blobs.Open(ctx, dgst)
+	if err != nil {
+		return 0, err
+	}
+	defer blob.Close()
+
+	if offset > 0 {
+		if _, err = blob.Seek(offset, os.SEEK_SET); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.Copy(w, blob)
+	blobFetchedBytesMeter.Mark(n)
+	return n, err
+}
+
+// isTransportError reports whether the download has been broken by the connection,
+// other errors are returned by the registry and mean that it won't resume the download
+func isTransportError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// restart truncates the tempfile and returns a new verifier
+func (r *blobRepo) restart(f *os.File, dgst digest.Digest) (digest.Verifier, error) {
+	if err := f.Truncate(0); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	return digest.NewDigestVerifier(dgst)
+}
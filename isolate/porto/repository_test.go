@@ -0,0 +1,298 @@
+package porto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	distctx "github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func writeBlob(t *testing.T, dir string, content string, modTime time.Time) digest.Digest {
+	dgst := digest.FromBytes([]byte(content))
+	path := filepath.Join(dir, dgst.String())
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	return dgst
+}
+
+func TestBlobRepositoryEviction(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "blobrepo")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	oldest := writeBlob(t, dir, "aaaa", now.Add(-3*time.Hour))
+	older := writeBlob(t, dir, "bbbb", now.Add(-2*time.Hour))
+	recent := writeBlob(t, dir, "cccc", now.Add(-time.Hour))
+	// files of the download helper are not managed
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "deadbeef"), []byte("helper"), 0644))
+
+	ctx := context.Background()
+	repo, err := NewBlobRepository(ctx, BlobRepositoryConfig{SpoolPath: dir, MaxSize: 8})
+	require.NoError(err)
+	r := repo.(*blobRepo)
+
+	// the oldest blob is evicted at start
+	require.EqualValues(8, r.size)
+	_, err = os.Stat(filepath.Join(dir, oldest.String()))
+	require.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "deadbeef"))
+	require.NoError(err)
+
+	// a hit makes the blob the most recently used one
+	path, err := repo.Get(ctx, nil, older)
+	require.NoError(err)
+	require.Equal(filepath.Join(dir, older.String()), path)
+
+	unpin := repo.Pin(recent)
+	newest := writeBlob(t, dir, "dddd", now)
+	r.mu.Lock()
+	r.add(newest, 4, now)
+	r.evict(ctx, newest)
+	r.mu.Unlock()
+
+	// recent is pinned and newest is kept, so older goes despite the hit
+	_, err = os.Stat(filepath.Join(dir, older.String()))
+	require.True(os.IsNotExist(err))
+	require.EqualValues(8, r.size)
+
+	r.MaxSize = 4
+	unpin()
+	_, err = os.Stat(filepath.Join(dir, recent.String()))
+	require.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, newest.String()))
+	require.NoError(err)
+	require.EqualValues(4, r.size)
+}
+
+func TestBlobRepositoryFreeSpace(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "blobrepo")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	first := writeBlob(t, dir, "aaaa", time.Now().Add(-time.Hour))
+	second := writeBlob(t, dir, "bbbb", time.Now())
+
+	repo, err := NewBlobRepository(context.Background(), BlobRepositoryConfig{SpoolPath: dir})
+	require.NoError(err)
+	r := repo.(*blobRepo)
+
+	// every eviction frees 100 bytes
+	var free uint64 = 50
+	r.MinFreeSpace = 100
+	r.freeSpace = func(string) (uint64, error) {
+		return free + 100*uint64(2-len(r.blobs)), nil
+	}
+	r.mu.Lock()
+	r.evict(context.Background(), "")
+	r.mu.Unlock()
+
+	_, err = os.Stat(filepath.Join(dir, first.String()))
+	require.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, second.String()))
+	require.NoError(err)
+}
+
+type fakeBlobStore struct {
+	distribution.BlobStore
+	content []byte
+	// failures is the number of Opens returning a truncated reader
+	failures int
+	offsets  []int64
+}
+
+// fakeBlobReader doesn't embed bytes.Reader to hide its WriteTo from io.Copy
+type fakeBlobReader struct {
+	reader *bytes.Reader
+	store  *fakeBlobStore
+	limit  int
+}
+
+func (r *fakeBlobReader) Seek(offset int64, whence int) (int64, error) {
+	r.store.offsets[len(r.store.offsets)-1] = offset
+	if offset >= r.reader.Size() {
+		return 0, fmt.Errorf("unexpected status resolving reader: 416 Requested Range Not Satisfiable")
+	}
+	return r.reader.Seek(offset, whence)
+}
+
+func (r *fakeBlobReader) Read(p []byte) (int, error) {
+	if r.limit >= 0 && int(r.reader.Size())-r.reader.Len() >= r.limit {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return r.reader.Read(p[:1])
+}
+
+func (r *fakeBlobReader) Close() error { return nil }
+
+func (s *fakeBlobStore) Open(ctx distctx.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
+	s.offsets = append(s.offsets, 0)
+	limit := -1
+	if s.failures > 0 {
+		s.failures--
+		limit = len(s.content) / 2
+	}
+	return &fakeBlobReader{reader: bytes.NewReader(s.content), store: s, limit: limit}, nil
+}
+
+func TestBlobRepositoryFetchResumes(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "blobrepo")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	repo, err := NewBlobRepository(ctx, BlobRepositoryConfig{SpoolPath: dir, FetchRetries: 1})
+	require.NoError(err)
+	r := repo.(*blobRepo)
+
+	content := []byte("0123456789abcdef")
+	dgst := digest.FromBytes(content)
+	// a partial file left by an interrupted download
+	require.NoError(ioutil.WriteFile(r.path(dgst)+"-1", content[:4], 0644))
+
+	store := &fakeBlobStore{content: content, failures: 1}
+	path, err := r.fetch(ctx, store, dgst)
+	require.NoError(err)
+	require.Equal(r.path(dgst), path)
+	// the first attempt resumes the partial file, the second one resumes the first attempt
+	require.Equal([]int64{4, 8}, store.offsets)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.Equal(content, data)
+
+	matches, err := filepath.Glob(r.path(dgst) + "-*")
+	require.NoError(err)
+	require.Empty(matches)
+}
+
+func TestBlobRepositoryFetchRestartsComplete(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "blobrepo")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	repo, err := NewBlobRepository(ctx, BlobRepositoryConfig{SpoolPath: dir, FetchRetries: 1})
+	require.NoError(err)
+	r := repo.(*blobRepo)
+
+	content := []byte("0123456789abcdef")
+	dgst := digest.FromBytes(content)
+	// a crash between the download and the rename leaves the whole blob
+	require.NoError(ioutil.WriteFile(r.path(dgst)+"-1", content, 0644))
+
+	store := &fakeBlobStore{content: content}
+	path, err := r.fetch(ctx, store, dgst)
+	require.NoError(err)
+	// the registry rejects the range, the blob is downloaded from the start
+	require.Equal([]int64{16, 0}, store.offsets)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.Equal(content, data)
+}
+
+func TestBlobRepositoryFetchDigestMismatch(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "blobrepo")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	repo, err := NewBlobRepository(ctx, BlobRepositoryConfig{SpoolPath: dir})
+	require.NoError(err)
+	r := repo.(*blobRepo)
+
+	dgst := digest.FromBytes([]byte("expected"))
+	_, err = r.fetch(ctx, &fakeBlobStore{content: []byte("tampered")}, dgst)
+	require.Error(err)
+
+	// neither the blob nor the corrupted partial file are kept
+	matches, err := filepath.Glob(r.path(dgst) + "*")
+	require.NoError(err)
+	require.Empty(matches)
+}
+
+// blockingBlobStore opens blobs once released or fails when the fetch is canceled
+type blockingBlobStore struct {
+	fakeBlobStore
+	opened  chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingBlobStore) Open(ctx distctx.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
+	close(s.opened)
+	select {
+	case <-s.release:
+		return s.fakeBlobStore.Open(ctx, dgst)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestBlobRepositoryDownloadShared(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "blobrepo")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	repo, err := NewBlobRepository(context.Background(), BlobRepositoryConfig{SpoolPath: dir, FetchRetries: 1})
+	require.NoError(err)
+	r := repo.(*blobRepo)
+
+	content := []byte("shared")
+	dgst := digest.FromBytes(content)
+	store := &blockingBlobStore{
+		fakeBlobStore: fakeBlobStore{content: content},
+		opened:        make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+
+	// the first spool starts the download and goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.download(ctx, store, dgst)
+		first <- err
+	}()
+	<-store.opened
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := r.download(context.Background(), store, dgst)
+		second <- err
+	}()
+	for waiters := 0; waiters != 2; time.Sleep(time.Millisecond) {
+		r.mu.Lock()
+		waiters = len(r.inProgress[dgst].waiters)
+		r.mu.Unlock()
+	}
+
+	cancel()
+	require.Equal(context.Canceled, <-first)
+	// the other spool still gets the blob
+	close(store.release)
+	require.NoError(<-second)
+	_, err = os.Stat(r.path(dgst))
+	require.NoError(err)
+}
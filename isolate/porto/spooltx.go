@@ -0,0 +1,198 @@
+package porto
+
+import (
+	"sync"
+
+	apexlog "github.com/apex/log"
+	"github.com/docker/distribution/digest"
+	"golang.org/x/net/context"
+
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+// layerStore is the part of a Porto connection a spool needs
+type layerStore interface {
+	ImportLayer(layer string, tarball string, merge bool) error
+	RemoveLayer(layer string) error
+}
+
+// stagedLayers counts spools in flight referencing layers.
+// Staged layers are neither collected by GC nor rolled back by other spools
+type stagedLayers struct {
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+func newStagedLayers() *stagedLayers {
+	return &stagedLayers{refs: make(map[string]int)}
+}
+
+func (s *stagedLayers) add(layer string) {
+	s.mu.Lock()
+	s.refs[layer]++
+	s.mu.Unlock()
+}
+
+// release returns true if no other spool references the layer
+func (s *stagedLayers) release(layer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs[layer]--; s.refs[layer] > 0 {
+		return false
+	}
+	delete(s.refs, layer)
+	return true
+}
+
+// copyTo adds staged layers to the set
+func (s *stagedLayers) copyTo(layers map[string]struct{}) {
+	s.mu.Lock()
+	for layer := range s.refs {
+		layers[layer] = struct{}{}
+	}
+	s.mu.Unlock()
+}
+
+// removeUnused calls remove unless the layer is staged or used reports that it's used.
+// Spools stage layers under the same lock, so a layer reused by a spool isn't removed underneath it
+func (s *stagedLayers) removeUnused(layer string, used func(string) bool, remove func(string) error) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeUnusedLocked(layer, used, remove)
+}
+
+// releaseUnused releases the layer and removes it like removeUnused under the same lock,
+// so another spool can't reuse the layer between the release and the removal
+func (s *stagedLayers) releaseUnused(layer string, used func(string) bool, remove func(string) error) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs[layer]--; s.refs[layer] <= 0 {
+		delete(s.refs, layer)
+	}
+	return s.removeUnusedLocked(layer, used, remove)
+}
+
+func (s *stagedLayers) removeUnusedLocked(layer string, used func(string) bool, remove func(string) error) (bool, error) {
+	if s.refs[layer] > 0 || used(layer) {
+		return false, nil
+	}
+	return true, remove(layer)
+}
+
+type stagedLayer struct {
+	name   string
+	digest string
+	// blob of the layer in the blob repository, it's empty for download helper layers
+	blob digest.Digest
+	// created is set if the layer has been imported by this spool
+	created bool
+}
+
+// spoolTx imports layers of an app. The journal sees them with the manifest of the app only after Commit,
+// Rollback removes layers created by the spool unless another spool, a manifest or a container uses them
+type spoolTx struct {
+	b      *Box
+	app    string
+	store  layerStore
+	layers []stagedLayer
+	done   bool
+}
+
+func (b *Box) beginSpool(app string, store layerStore) *spoolTx {
+	return &spoolTx{b: b, app: app, store: store}
+}
+
+// Import imports the tarball as the layer. An existing layer is reused
+func (tx *spoolTx) Import(ctx context.Context, layer, layerDigest string, blob digest.Digest, tarball string) (err error) {
+	// the layer is staged before the import, so a concurrent rollback doesn't remove it
+	tx.b.staged.add(layer)
+	entry := log.G(ctx).WithField("layer", layer).Trace("Try to import layer")
+	err = tx.store.ImportLayer(layer, tarball, false)
+	if err != nil && !isEqualPortoError(err, portorpc.EError_LayerAlreadyExists) {
+		tx.b.staged.release(layer)
+		entry.Stop(&err)
+		return err
+	}
+	tx.layers = append(tx.layers, stagedLayer{name: layer, digest: layerDigest, blob: blob, created: err == nil})
+	return nil
+}
+
+// Layers returns names of staged layers in order of imports
+func (tx *spoolTx) Layers() []string {
+	names := make([]string, 0, len(tx.layers))
+	for _, layer := range tx.layers {
+		names = append(names, layer.name)
+	}
+	return names
+}
+
+// Commit records layers, the manifest and the image of the app in the journal and writes it.
+// The spool is committed even if the journal can't be written, the periodic dump retries it
+func (tx *spoolTx) Commit(ctx context.Context, image imageRecord) {
+	digests := make(map[string]string, len(tx.layers))
+	for _, layer := range tx.layers {
+		digests[layer.name] = layer.digest
+	}
+	tx.b.journal.CommitSpool(tx.app, tx.Layers(), digests, image)
+	tx.finish()
+	spoolCommitsCounter.Inc(1)
+	// a spooled manifest must survive a crash
+	if err := tx.b.dumpJournal(ctx); err != nil {
+		log.G(ctx).WithError(err).WithField("app", tx.app).Warn("unable to write the journal after the spool")
+	}
+}
+
+// Rollback removes layers created by the spool. It does nothing after Commit
+func (tx *spoolTx) Rollback(ctx context.Context) {
+	if tx.done {
+		return
+	}
+	spoolRollbacksCounter.Inc(1)
+
+	_, inUse := tx.b.runningLayers()
+	var blobs []digest.Digest
+	// layers are removed from the top one
+	for i := len(tx.layers) - 1; i >= 0; i-- {
+		layer := tx.layers[i]
+		if !layer.created {
+			tx.b.staged.release(layer.name)
+			continue
+		}
+
+		logger := log.G(ctx).WithFields(apexlog.Fields{"app": tx.app, "layer": layer.name})
+		removed, err := tx.b.staged.releaseUnused(layer.name, func(name string) bool {
+			_, used := inUse[name]
+			return used || tx.b.journal.Referenced(name)
+		}, func(name string) error {
+			err := tx.store.RemoveLayer(name)
+			if err == nil || isEqualPortoError(err, portorpc.EError_LayerNotFound) {
+				tx.b.journal.RemoveLayer(name)
+				return nil
+			}
+			return err
+		})
+		switch {
+		case !removed:
+			continue
+		case err != nil:
+			logger.WithError(err).Warn("unable to remove the layer of the failed spool")
+			continue
+		}
+		logger.Info("the layer of the failed spool has been removed")
+		spoolLayersRolledBackCounter.Inc(1)
+		if layer.blob != "" {
+			blobs = append(blobs, layer.blob)
+		}
+	}
+	tx.done = true
+	tx.b.blobRepo.Remove(ctx, blobs...)
+}
+
+func (tx *spoolTx) finish() {
+	for _, layer := range tx.layers {
+		tx.b.staged.release(layer.name)
+	}
+	tx.done = true
+}
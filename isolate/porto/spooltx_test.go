@@ -0,0 +1,189 @@
+package porto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	porto "github.com/yandex/porto/src/api/go"
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+	"golang.org/x/net/context"
+)
+
+type fakeLayerStore struct {
+	layers  map[string]struct{}
+	removed []string
+	// onRemove is called before a layer is removed
+	onRemove func(layer string)
+}
+
+func (s *fakeLayerStore) ImportLayer(layer string, tarball string, merge bool) error {
+	if tarball == "broken" {
+		return fmt.Errorf("broken tarball")
+	}
+	if _, ok := s.layers[layer]; ok {
+		return &porto.Error{Errno: portorpc.EError_LayerAlreadyExists, ErrName: "LayerAlreadyExists"}
+	}
+	s.layers[layer] = struct{}{}
+	return nil
+}
+
+func (s *fakeLayerStore) RemoveLayer(layer string) error {
+	if s.onRemove != nil {
+		s.onRemove(layer)
+	}
+	delete(s.layers, layer)
+	s.removed = append(s.removed, layer)
+	return nil
+}
+
+func newSpoolTestBox(t *testing.T) (*Box, string, func()) {
+	dir, err := ioutil.TempDir("", "spooltx")
+	require.NoError(t, err)
+	blobs := filepath.Join(dir, "blobs")
+	require.NoError(t, os.Mkdir(blobs, 0755))
+	return &Box{
+		journal:     newJournal(),
+		journalFile: &journalFile{path: filepath.Join(dir, "portojournal.jrnl")},
+		staged:      newStagedLayers(),
+		containers:  make(map[string]*container),
+	}, blobs, func() { os.RemoveAll(dir) }
+}
+
+func TestSpoolTxCommit(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	b, _, cleanup := newSpoolTestBox(t)
+	defer cleanup()
+
+	store := &fakeLayerStore{layers: map[string]struct{}{"base": {}}}
+	tx := b.beginSpool("app", store)
+	require.NoError(tx.Import(ctx, "base", "sha256:base", "", "base.tar"))
+	require.NoError(tx.Import(ctx, "top", "sha256:top", "", "top.tar"))
+
+	// imported layers are invisible until the commit
+	require.Equal("", b.journal.GetManifestLayers("app"))
+	running := make(map[string]struct{})
+	b.staged.copyTo(running)
+	require.Len(running, 2)
+
+	tx.Commit(ctx, imageRecord{Reference: "app:1.0", Spooled: time.Now()})
+	require.Equal("base;top", b.journal.GetManifestLayers("app"))
+	require.True(b.journal.In("top", "sha256:top"))
+	image, ok := b.journal.Image("app")
+	require.True(ok)
+	require.Equal("app:1.0", image.Reference)
+
+	running = make(map[string]struct{})
+	b.staged.copyTo(running)
+	require.Empty(running)
+
+	// the committed spool is written
+	j, err := b.journalFile.Read(ctx)
+	require.NoError(err)
+	require.Equal("base;top", j.Manifests["app"])
+
+	// a committed spool is never rolled back
+	tx.Rollback(ctx)
+	require.Empty(store.removed)
+}
+
+func TestSpoolTxCommitDumpFailure(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	b, _, cleanup := newSpoolTestBox(t)
+	defer cleanup()
+	b.journalFile = &journalFile{path: filepath.Join(b.journalFile.path, "missing", "portojournal.jrnl")}
+
+	store := &fakeLayerStore{layers: make(map[string]struct{})}
+	tx := b.beginSpool("app", store)
+	require.NoError(tx.Import(ctx, "layer", "sha256:layer", "", "layer.tar"))
+
+	// the spool stays committed for the next dump
+	tx.Commit(ctx, imageRecord{Reference: "app:1.0", Spooled: time.Now()})
+	tx.Rollback(ctx)
+	require.Empty(store.removed)
+	require.Equal("layer", b.journal.GetManifestLayers("app"))
+}
+
+func TestSpoolTxRollback(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	b, blobs, cleanup := newSpoolTestBox(t)
+	defer cleanup()
+
+	created := writeBlob(t, blobs, "created", time.Now())
+	repo, err := NewBlobRepository(ctx, BlobRepositoryConfig{SpoolPath: blobs})
+	require.NoError(err)
+	b.blobRepo = repo
+
+	store := &fakeLayerStore{layers: map[string]struct{}{"existing": {}}}
+	b.journal.Insert("shared", "sha256:shared")
+	b.journal.InsertManifestLayers("other", "shared")
+	b.containers["c"] = &container{appname: "previous", layers: []string{"running"}}
+
+	// another spool in flight imports the same layer
+	concurrent := b.beginSpool("concurrent", store)
+	require.NoError(concurrent.Import(ctx, "inflight", "sha256:inflight", "", "inflight.tar"))
+
+	tx := b.beginSpool("app", store)
+	for _, layer := range []string{"created", "existing", "shared", "running", "inflight"} {
+		blob := created
+		if layer != "created" {
+			blob = ""
+		}
+		require.NoError(tx.Import(ctx, layer, "sha256:"+layer, blob, layer+".tar"))
+	}
+	require.EqualError(tx.Import(ctx, "broken", "sha256:broken", "", "broken"), "broken tarball")
+
+	tx.Rollback(ctx)
+	require.Equal([]string{"created"}, store.removed)
+	_, err = os.Stat(filepath.Join(blobs, created.String()))
+	require.True(os.IsNotExist(err))
+	require.Equal("", b.journal.GetManifestLayers("app"))
+
+	// only layers of the concurrent spool remain staged
+	running := make(map[string]struct{})
+	b.staged.copyTo(running)
+	require.Equal(map[string]struct{}{"inflight": {}}, running)
+
+	tx.Rollback(ctx)
+	require.Len(store.removed, 1)
+}
+
+func TestSpoolTxRollbackConcurrentImport(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	b, blobs, cleanup := newSpoolTestBox(t)
+	defer cleanup()
+	repo, err := NewBlobRepository(ctx, BlobRepositoryConfig{SpoolPath: blobs})
+	require.NoError(err)
+	b.blobRepo = repo
+
+	store := &fakeLayerStore{layers: make(map[string]struct{})}
+	tx := b.beginSpool("app", store)
+	require.NoError(tx.Import(ctx, "layer", "sha256:layer", "", "layer.tar"))
+
+	// another spool stages the same layer while the rollback removes it
+	concurrent := b.beginSpool("concurrent", store)
+	imported := make(chan error, 1)
+	store.onRemove = func(layer string) {
+		go func() {
+			imported <- concurrent.Import(ctx, layer, "sha256:"+layer, "", layer+".tar")
+		}()
+		// give the import a chance to overtake the removal
+		time.Sleep(20 * time.Millisecond)
+	}
+	tx.Rollback(ctx)
+	require.NoError(<-imported)
+
+	// the import waits for the removal and brings the layer back
+	require.Equal([]string{"layer"}, store.removed)
+	require.Contains(store.layers, "layer")
+	concurrent.Commit(ctx, imageRecord{Reference: "concurrent:1.0", Spooled: time.Now()})
+	require.Equal("layer", b.journal.GetManifestLayers("concurrent"))
+}
@@ -0,0 +1,194 @@
+package porto
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	porto "github.com/yandex/porto/src/api/go"
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/events"
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+var errContainerDoesNotExist = errors.New("container does not exist")
+
+const (
+	defaultTrackWaitTimeoutMs = 1000
+
+	stateProperty     = "state"
+	deathTimeProperty = "death_time[raw]"
+	deathTimeLayout   = "2006-01-02 15:04:05"
+)
+
+// tracker detects deaths of tracked containers. It waits for any of them with Wait of Portod
+// and falls back to polling states with batched Get if Portod doesn't support Wait
+type tracker struct {
+	box *Box
+	// timeout of Wait. Containers spawned during Wait are tracked after it
+	timeout time.Duration
+	// period of polling
+	step    time.Duration
+	polling bool
+}
+
+func newTracker(b *Box) *tracker {
+	return &tracker{
+		box:     b,
+		timeout: time.Duration(b.config.TrackWaitTimeoutMs) * time.Millisecond,
+		step:    time.Duration(b.config.WaitLoopStepSec) * time.Second,
+	}
+}
+
+// next handles the next death. An error means that the connection must be dropped
+func (t *tracker) next(ctx context.Context, portoConn porto.API) error {
+	containers := t.box.trackedContainers()
+	if len(containers) == 0 || t.polling {
+		select {
+		case <-t.box.trackWakeup:
+			return nil
+		case <-time.After(t.step):
+		case <-ctx.Done():
+			return nil
+		}
+		return t.check(ctx, portoConn, t.box.trackedContainers())
+	}
+
+	name, err := portoConn.Wait(containers, t.timeout)
+	switch {
+	case err == nil && name == "":
+		// timeout
+		return nil
+	case err == nil:
+		return t.check(ctx, portoConn, []string{name})
+	case isEqualPortoError(err, portorpc.EError_InvalidMethod), isEqualPortoError(err, portorpc.EError_NotSupported):
+		log.G(ctx).WithError(err).Warnf("Wait is not supported by Portod, poll states of containers every %v", t.step)
+		t.polling = true
+		return nil
+	case isEqualPortoError(err, portorpc.EError_ContainerDoesNotExist):
+		// one of containers has gone, find it
+		return t.check(ctx, portoConn, containers)
+	default:
+		return err
+	}
+}
+
+// check handles dead and vanished containers among given ones with one Get
+func (t *tracker) check(ctx context.Context, portoConn porto.API, containers []string) error {
+	if len(containers) == 0 {
+		return nil
+	}
+
+	data, err := portoConn.Get(containers, []string{stateProperty, deathTimeProperty})
+	if isEqualPortoError(err, portorpc.EError_ContainerDoesNotExist) {
+		// old Portod fails the whole request
+		return t.checkOneByOne(ctx, portoConn, containers)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, name := range containers {
+		values, ok := data[name]
+		state := values[stateProperty]
+		switch {
+		case !ok, portorpc.EError(state.Error) == portorpc.EError_ContainerDoesNotExist:
+			t.box.onContainerVanished(ctx, name)
+		case state.Error == 0 && isDeadState(state.Value):
+			if died, ok := parseDeathTime(values[deathTimeProperty]); ok {
+				deathDetectionTimer.UpdateSince(died)
+			}
+			t.box.onContainerDead(ctx, name)
+		}
+	}
+	return nil
+}
+
+func (t *tracker) checkOneByOne(ctx context.Context, portoConn porto.API, containers []string) error {
+	for _, name := range containers {
+		state, err := portoConn.GetProperty(name, stateProperty)
+		switch {
+		case isEqualPortoError(err, portorpc.EError_ContainerDoesNotExist):
+			t.box.onContainerVanished(ctx, name)
+		case err != nil:
+			return err
+		case isDeadState(state):
+			t.box.onContainerDead(ctx, name)
+		}
+	}
+	return nil
+}
+
+// isDeadState reports whether a tracked container is not running anymore.
+// Wait of Portod returns for stopped containers as well as for dead ones
+func isDeadState(state string) bool {
+	return state == "dead" || state == "stopped"
+}
+
+// parseDeathTime accepts both raw unix time and the formatted one of older Portod
+func parseDeathTime(value porto.TPortoGetResponse) (time.Time, bool) {
+	if value.Error != 0 || value.Value == "" {
+		return time.Time{}, false
+	}
+	if sec, err := strconv.ParseInt(value.Value, 10, 64); err == nil {
+		return time.Unix(sec, 0), true
+	}
+	if died, err := time.ParseInLocation(deathTimeLayout, value.Value, time.Local); err == nil {
+		return died, true
+	}
+	return time.Time{}, false
+}
+
+func (b *Box) trackedContainers() []string {
+	b.muContainers.Lock()
+	defer b.muContainers.Unlock()
+	containers := make([]string, 0, len(b.containers))
+	for name := range b.containers {
+		containers = append(containers, name)
+	}
+	return containers
+}
+
+// track wakes up the tracker waiting for containers
+func (b *Box) track() {
+	select {
+	case b.trackWakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Box) untrack(name string) (*container, int, bool) {
+	b.muContainers.Lock()
+	defer b.muContainers.Unlock()
+	container, ok := b.containers[name]
+	if ok {
+		delete(b.containers, name)
+	}
+	return container, len(b.containers), ok
+}
+
+func (b *Box) onContainerDead(ctx context.Context, name string) {
+	container, rest, ok := b.untrack(name)
+	log.G(ctx).Infof("%s container have status dead now.", name)
+	if ok {
+		events.Publish(container.event(events.WorkerDied))
+		if err := container.Kill(); err != nil {
+			log.G(ctx).WithError(err).Errorf("Killing %s error", name)
+		}
+	}
+	log.G(ctx).Infof("%d containers are being tracked now", rest)
+}
+
+func (b *Box) onContainerVanished(ctx context.Context, name string) {
+	container, rest, ok := b.untrack(name)
+	if ok {
+		events.Publish(container.event(events.WorkerDied).WithError(errContainerDoesNotExist))
+		log.G(ctx).Errorf("Container %s does not exist, but try kill anyway.", name)
+		if err := container.Kill(); err != nil {
+			log.G(ctx).WithError(err).Debugf("catch at try kill ContainerDoesNotExist %s", name)
+		}
+	}
+	log.G(ctx).Debugf("%d containers are being tracked now after remove ContainerDoesNotExist %s", rest, name)
+}
@@ -0,0 +1,186 @@
+package porto
+
+import (
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	porto "github.com/yandex/porto/src/api/go"
+	portorpc "github.com/yandex/porto/src/api/go/rpc"
+	"golang.org/x/net/context"
+)
+
+type fakeTrackerConn struct {
+	porto.API
+
+	mu      sync.Mutex
+	states  map[string]string
+	waitErr error
+	killed  []string
+}
+
+func (f *fakeTrackerConn) Wait(containers []string, timeout time.Duration) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.waitErr != nil {
+		return "", f.waitErr
+	}
+	for _, name := range containers {
+		if isDeadState(f.states[name]) {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+func (f *fakeTrackerConn) Get(containers []string, variables []string) (map[string]map[string]porto.TPortoGetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ret := make(map[string]map[string]porto.TPortoGetResponse)
+	for _, name := range containers {
+		state, ok := f.states[name]
+		ret[name] = make(map[string]porto.TPortoGetResponse, len(variables))
+		for _, variable := range variables {
+			if !ok {
+				ret[name][variable] = porto.TPortoGetResponse{Error: int(portorpc.EError_ContainerDoesNotExist)}
+				continue
+			}
+			switch variable {
+			case stateProperty:
+				ret[name][variable] = porto.TPortoGetResponse{Value: state}
+			case deathTimeProperty:
+				ret[name][variable] = porto.TPortoGetResponse{Value: strconv.FormatInt(time.Now().Unix(), 10)}
+			default:
+				// output offsets, nothing has been dropped by the fake
+				ret[name][variable] = porto.TPortoGetResponse{Value: "0"}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (f *fakeTrackerConn) GetData(name string, data string) (string, error) { return "", nil }
+func (f *fakeTrackerConn) GetVersion() (string, string, error)              { return "v4", "rev", nil }
+func (f *fakeTrackerConn) Close() error                                     { return nil }
+
+func (f *fakeTrackerConn) Kill(name string, sig syscall.Signal) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.killed = append(f.killed, name)
+	return &porto.Error{Errno: portorpc.EError_InvalidState, ErrName: "InvalidState"}
+}
+
+func newTrackerTestBox(conn *fakeTrackerConn, names ...string) *Box {
+	pool := newConnPool(portoConnConfig{HealthCheckSec: 3600})
+	pool.dial = func() (porto.API, error) { return conn, nil }
+
+	b := &Box{
+		Name:        "porto",
+		boxName:     "porto",
+		config:      &portoBoxConfig{TrackWaitTimeoutMs: 10, WaitLoopStepSec: 1},
+		containers:  make(map[string]*container),
+		portoPool:   pool,
+		trackWakeup: make(chan struct{}, 1),
+	}
+	for _, name := range names {
+		b.containers[name] = &container{
+			ctx:         context.Background(),
+			containerID: name,
+			output:      newOutputFollower(context.Background(), name, outputConfig{}),
+			pool:        pool,
+		}
+	}
+	return b
+}
+
+func TestTrackerWait(t *testing.T) {
+	require := require.New(t)
+
+	conn := &fakeTrackerConn{states: map[string]string{"alive": "running", "dead": "dead"}}
+	b := newTrackerTestBox(conn, "alive", "dead")
+	defer b.portoPool.Close()
+
+	detected := deathDetectionTimer.Count()
+	tr := newTracker(b)
+	require.NoError(tr.next(context.Background(), conn))
+	require.Equal([]string{"alive"}, b.trackedContainers())
+	require.Equal([]string{"dead"}, conn.killed)
+	require.Equal(detected+1, deathDetectionTimer.Count())
+
+	// timeout
+	require.NoError(tr.next(context.Background(), conn))
+	require.Equal([]string{"alive"}, b.trackedContainers())
+}
+
+func TestTrackerStopped(t *testing.T) {
+	require := require.New(t)
+
+	conn := &fakeTrackerConn{states: map[string]string{"alive": "running", "stopped": "stopped"}}
+	b := newTrackerTestBox(conn, "alive", "stopped")
+	defer b.portoPool.Close()
+
+	// Wait returns at once for the stopped container, it must not be waited for again
+	tr := newTracker(b)
+	require.NoError(tr.next(context.Background(), conn))
+	require.Equal([]string{"alive"}, b.trackedContainers())
+	require.Equal([]string{"stopped"}, conn.killed)
+}
+
+func TestTrackerVanished(t *testing.T) {
+	require := require.New(t)
+
+	conn := &fakeTrackerConn{
+		states:  map[string]string{"alive": "running"},
+		waitErr: &porto.Error{Errno: portorpc.EError_ContainerDoesNotExist, ErrName: "ContainerDoesNotExist"},
+	}
+	b := newTrackerTestBox(conn, "alive", "vanished")
+	defer b.portoPool.Close()
+
+	require.NoError(newTracker(b).next(context.Background(), conn))
+	require.Equal([]string{"alive"}, b.trackedContainers())
+	require.Equal([]string{"vanished"}, conn.killed)
+}
+
+func TestTrackerFallsBackToPolling(t *testing.T) {
+	require := require.New(t)
+
+	conn := &fakeTrackerConn{
+		states:  map[string]string{"dead": "dead"},
+		waitErr: &porto.Error{Errno: portorpc.EError_InvalidMethod, ErrName: "InvalidMethod"},
+	}
+	b := newTrackerTestBox(conn, "dead")
+	defer b.portoPool.Close()
+
+	tr := newTracker(b)
+	require.NoError(tr.next(context.Background(), conn))
+	require.True(tr.polling)
+	require.Equal([]string{"dead"}, b.trackedContainers())
+
+	// the next step polls after WaitLoopStepSec
+	require.NoError(tr.next(context.Background(), conn))
+	require.Empty(b.trackedContainers())
+
+	// a new container wakes the tracker up
+	b.track()
+	start := time.Now()
+	require.NoError(tr.next(context.Background(), conn))
+	require.True(time.Since(start) < time.Second)
+}
+
+func TestParseDeathTime(t *testing.T) {
+	require := require.New(t)
+
+	died, ok := parseDeathTime(porto.TPortoGetResponse{Value: "1500000000"})
+	require.True(ok)
+	require.Equal(int64(1500000000), died.Unix())
+
+	died, ok = parseDeathTime(porto.TPortoGetResponse{Value: "2017-07-14 02:40:00"})
+	require.True(ok)
+	require.Equal(2017, died.Year())
+
+	_, ok = parseDeathTime(porto.TPortoGetResponse{Error: int(portorpc.EError_InvalidProperty)})
+	require.False(ok)
+}
@@ -3,11 +3,9 @@ package porto
 import (
 	"bytes"
 	"encoding/json"
-	"net"
 	"sort"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/docker/distribution"
 	porto "github.com/yandex/porto/src/api/go"
@@ -153,24 +151,6 @@ func (c containerFootprint) String() string {
 	return buff.String()
 }
 
-// NOTE: it's dummy connet_with_retyr implementation
-// It's subject to replace
-func portoConnect() (porto.API, error) {
-	for {
-		conn, err := porto.Connect()
-		if err == nil {
-			return conn, nil
-		}
-
-		if ne, ok := err.(net.Error); ok && ne.Temporary() {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-
-		return nil, err
-	}
-}
-
 type layersOrder func(references []distribution.Descriptor) []distribution.Descriptor
 
 var layerOrderV2 layersOrder = func(references []distribution.Descriptor) []distribution.Descriptor {
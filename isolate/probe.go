@@ -0,0 +1,135 @@
+package isolate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/net/context"
+)
+
+// mtnAllocatorProbeTTL is the period of requests to the MTN allocator made by readiness checks
+const mtnAllocatorProbeTTL = 30 * time.Second
+
+// DefaultMinFreeSpace is the free space required by FreeSpaceProbe unless a box configures it
+const DefaultMinFreeSpace = 1 << 30
+
+// Probe checks whether a dependency of the daemon is usable
+type Probe struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Prober is implemented by boxes which are able to check their dependencies.
+// Probes are run by the readiness endpoint of the daemon
+type Prober interface {
+	Probes() []Probe
+}
+
+// CachedProbe remembers the result of the probe for ttl, so frequent checks don't load the dependency
+func CachedProbe(probe Probe, ttl time.Duration) Probe {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		result  error
+	)
+	check := probe.Check
+	probe.Check = func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checked.IsZero() && time.Since(checked) < ttl {
+			return result
+		}
+		result = check(ctx)
+		checked = time.Now()
+		return result
+	}
+	return probe
+}
+
+// DirWritableProbe checks that a file can be created in the directory
+func DirWritableProbe(name, dir string) Probe {
+	return Probe{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			f, err := ioutil.TempFile(dir, ".probe")
+			if err != nil {
+				return err
+			}
+			f.Close()
+			return os.Remove(f.Name())
+		},
+	}
+}
+
+// FreeSpaceProbe checks that the filesystem of the directory has at least minFree bytes available
+func FreeSpaceProbe(name, dir string, minFree uint64) Probe {
+	return Probe{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			free, err := FreeSpace(dir)
+			if err != nil {
+				return err
+			}
+			if free < minFree {
+				return fmt.Errorf("%s: %d bytes available, %d required", dir, free, minFree)
+			}
+			return nil
+		},
+	}
+}
+
+// FreeSpace returns the number of bytes available to unprivileged users in the filesystem of the directory
+func FreeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// Probes checks that MTN allocations DB is open and the allocator is reachable
+func (c *MtnState) Probes() []Probe {
+	if !c.Cfg.Enable {
+		return nil
+	}
+
+	return []Probe{
+		{
+			Name: "mtn.db",
+			Check: func(ctx context.Context) error {
+				if c.Db == nil {
+					return fmt.Errorf("DB %s is not open", c.Cfg.DbPath)
+				}
+				return c.Db.View(func(tx *bolt.Tx) error { return nil })
+			},
+		},
+		// the allocator replies with all allocations of the scheduler, it isn't asked on every check
+		CachedProbe(Probe{
+			Name: "mtn.allocator",
+			Check: func(ctx context.Context) error {
+				req, err := http.NewRequest("GET", c.Cfg.Url+"?scheduler="+c.Cfg.SchedLabel, nil)
+				if err != nil {
+					return err
+				}
+				for header, value := range c.Cfg.Headers {
+					req.Header.Set(header, value.Value())
+				}
+				resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				if resp.StatusCode >= http.StatusBadRequest {
+					return fmt.Errorf("allocator replied %s", resp.Status)
+				}
+				return nil
+			},
+		}, mtnAllocatorProbeTTL),
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -15,6 +16,7 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/events"
 	"github.com/noxiouz/stout/pkg/log"
 	"github.com/noxiouz/stout/pkg/semaphore"
 
@@ -46,17 +48,19 @@ type workerInfo struct {
 type Box struct {
 	ctx          context.Context
 	cancellation context.CancelFunc
+	// name of the box in the configuration
+	name string
 
 	spoolPath string
 	storage   codeStorage
 
-	state   isolate.GlobalState
+	state isolate.GlobalState
 
 	mu       sync.Mutex
 	children map[int]workerInfo
 	wg       sync.WaitGroup
 
-	spawnSm semaphore.Semaphore
+	spawnSm *semaphore.Weighted
 }
 
 func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalState) (isolate.Box, error) {
@@ -74,13 +78,14 @@ func NewBox(ctx context.Context, cfg isolate.BoxConfig, gstate isolate.GlobalSta
 	box := &Box{
 		ctx:          ctx,
 		cancellation: cancel,
+		name:         isolate.BoxName(ctx, "process"),
 
 		spoolPath: spoolPath,
 		storage:   createCodeStorage(locator),
 
 		children: make(map[int]workerInfo),
 		// NOTE: configurable
-		spawnSm: semaphore.New(10),
+		spawnSm: semaphore.NewWeighted(10, spawnSMMetrics),
 	}
 
 	body, err := json.Marshal(map[string]string{
@@ -156,6 +161,11 @@ func (b *Box) wait() {
 				// But we have to call Wait to close all associated fds and to release other resources
 				pr.Wait()
 				procsWaitedCounter.Inc(1)
+				attrs := map[string]string{"pid": strconv.Itoa(pid), "exitcode": strconv.Itoa(ws.ExitStatus())}
+				if ws.Signaled() {
+					attrs["signal"] = ws.Signal().String()
+				}
+				events.Publish(events.Event{Type: events.WorkerDied, Box: b.name, UUID: pr.uuid, Attrs: attrs})
 			}
 		case err == syscall.EINTR:
 			// NOTE: although man says that EINTR is not possible in this case, let's be on the side
@@ -215,12 +225,12 @@ func (b *Box) Spawn(ctx context.Context, config isolate.SpawnConfig, output io.W
 		spawningQueueSize.Dec(1)
 		return nil, syscall.EAGAIN
 	}
-	err = b.spawnSm.Acquire(ctx)
+	release, err := b.spawnSm.AcquireN(ctx, config.Weight, config.Priority)
 	spawningQueueSize.Dec(1)
 	if err != nil {
 		return nil, isolate.ErrSpawningCancelled
 	}
-	defer b.spawnSm.Release()
+	defer release()
 	// NOTE: once process was put to the map
 	// its waiter responsibility to Wait for it.
 
@@ -294,6 +304,14 @@ func (b *Box) Inspect(ctx context.Context, worker string) ([]byte, error) {
 	return []byte("{}"), nil
 }
 
+// Probes checks that the spool directory is writable and has enough free space
+func (b *Box) Probes() []isolate.Probe {
+	return []isolate.Probe{
+		isolate.DirWritableProbe("spool.writable", b.spoolPath),
+		isolate.FreeSpaceProbe("spool.freespace", b.spoolPath, isolate.DefaultMinFreeSpace),
+	}
+}
+
 func (b *Box) fetch(ctx context.Context, appname string) ([]byte, error) {
 	return b.storage.Spool(ctx, appname)
 }
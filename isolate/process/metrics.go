@@ -4,6 +4,8 @@ import (
 	"expvar"
 
 	"github.com/rcrowley/go-metrics"
+
+	"github.com/noxiouz/stout/pkg/semaphore"
 )
 
 var (
@@ -20,6 +22,8 @@ var (
 	totalSpawnTimer = metrics.NewTimer()
 	procsNewTimer   = metrics.NewTimer()
 
+	spawnSMMetrics = semaphore.NewMetrics()
+
 	zombieWaitTimer = metrics.NewTimer()
 
 	processConfig = expvar.NewString("process_config")
@@ -32,6 +36,7 @@ func init() {
 	registry.Register("procs_errored", procsErroredCounter)
 	registry.Register("procs_waited", procsWaitedCounter)
 	registry.Register("total_spawn_timer", totalSpawnTimer)
+	spawnSMMetrics.Register(registry, "spawn_sm_")
 	registry.Register("procs_new_timer", procsNewTimer)
 	registry.Register("zombie_wait_timer", zombieWaitTimer)
 }
@@ -6,10 +6,15 @@ import (
 	"sync"
 
 	"github.com/tinylib/msgp/msgp"
+
+	"github.com/noxiouz/stout/pkg/semaphore"
 )
 
 const (
 	typeKey = "type"
+
+	spawnWeightKey   = "spawn_weight"
+	spawnPriorityKey = "spawn_priority"
 )
 
 var (
@@ -61,6 +66,34 @@ func (p *cocaineProfile) Type() (string, error) {
 	return t, err
 }
 
+// SpawnWeight returns the number of spawn slots the app takes. It's 1 unless the profile says otherwise
+func (p *cocaineProfile) SpawnWeight() int64 {
+	raw := msgp.Locate(spawnWeightKey, p.buff)
+	if len(raw) == 0 {
+		return 1
+	}
+
+	weight, _, err := msgp.ReadInt64Bytes(raw)
+	if err != nil || weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+// SpawnPriority returns the priority class of spawning the app: `low`, `normal` (default) or `high`
+func (p *cocaineProfile) SpawnPriority() (semaphore.Priority, error) {
+	raw := msgp.Locate(spawnPriorityKey, p.buff)
+	if len(raw) == 0 {
+		return semaphore.PriorityNormal, nil
+	}
+
+	s, _, err := msgp.ReadStringBytes(raw)
+	if err != nil {
+		return semaphore.PriorityNormal, err
+	}
+	return semaphore.ParsePriority(s)
+}
+
 func (p *cocaineProfile) Write(b []byte) (int, error) {
 	p.buff = append(p.buff, b...)
 	return len(b), nil
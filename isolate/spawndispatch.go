@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"sync/atomic"
 
-	"github.com/tinylib/msgp/msgp"
+	"github.com/noxiouz/stout/pkg/events"
 	"github.com/noxiouz/stout/pkg/log"
+	"github.com/tinylib/msgp/msgp"
 
 	"golang.org/x/net/context"
 )
@@ -24,9 +25,11 @@ type spawnDispatch struct {
 	stream  ResponseStream
 	killed  *uint32
 	process <-chan Process
+
+	event events.Event
 }
 
-func newSpawnDispatch(ctx context.Context, cancelSpawn context.CancelFunc, prCh <-chan Process, flagKilled *uint32, stream ResponseStream) *spawnDispatch {
+func newSpawnDispatch(ctx context.Context, cancelSpawn context.CancelFunc, prCh <-chan Process, flagKilled *uint32, stream ResponseStream, event events.Event) *spawnDispatch {
 	return &spawnDispatch{
 		ctx: ctx,
 
@@ -34,6 +37,7 @@ func newSpawnDispatch(ctx context.Context, cancelSpawn context.CancelFunc, prCh
 		cancelSpawn: cancelSpawn,
 		killed:      flagKilled,
 		process:     prCh,
+		event:       event,
 	}
 }
 
@@ -66,7 +70,9 @@ func (d *spawnDispatch) asyncKill() {
 		if atomic.CompareAndSwapUint32(d.killed, 0, 1) {
 			killMeter.Mark(1)
 			log.G(d.ctx).Info("Get kill request from channel in spawnDispatch module.")
-			if err := pr.Kill(); err != nil {
+			err := pr.Kill()
+			events.Publish(withType(d.event, events.WorkerKilled).WithError(err))
+			if err != nil {
 				d.stream.Error(d.ctx, replyKillError, errKillError, err.Error())
 				return
 			}
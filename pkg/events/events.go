@@ -0,0 +1,148 @@
+// Package events provides a bus of lifecycle events of the isolate daemon.
+// Boxes and dispatchers publish events, sinks subscribe to them.
+package events
+
+import (
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// Type of an event
+type Type string
+
+const (
+	SpoolStarted  Type = "spool.started"
+	SpoolFinished Type = "spool.finished"
+	SpoolFailed   Type = "spool.failed"
+
+	SpawnRequested Type = "spawn.requested"
+	SpawnStarted   Type = "spawn.started"
+	SpawnFailed    Type = "spawn.failed"
+
+	WorkerKilled Type = "worker.killed"
+	WorkerDied   Type = "worker.died"
+
+	MtnAllocationUsed  Type = "mtn.allocation.used"
+	MtnAllocationFreed Type = "mtn.allocation.freed"
+
+	GCContainerDestroyed Type = "gc.container.destroyed"
+	GCVolumeUnlinked     Type = "gc.volume.unlinked"
+	GCAllocationFreed    Type = "gc.allocation.freed"
+	GCManifestRemoved    Type = "gc.manifest.removed"
+	GCLayerRemoved       Type = "gc.layer.removed"
+)
+
+// Event describes something which has happened to an app or a worker
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Box  string    `json:"box,omitempty"`
+	App  string    `json:"app,omitempty"`
+	UUID string    `json:"uuid,omitempty"`
+	// Duration of the operation in seconds
+	Duration float64           `json:"duration,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+}
+
+// Since sets Duration of the event
+func (e Event) Since(start time.Time) Event {
+	e.Duration = time.Since(start).Seconds()
+	return e
+}
+
+// WithError sets Error of the event
+func (e Event) WithError(err error) Event {
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+var (
+	publishedMeter = metrics.NewMeter()
+	droppedCounter = metrics.NewCounter()
+
+	registry = metrics.NewPrefixedChildRegistry(metrics.DefaultRegistry, "events_")
+)
+
+func init() {
+	registry.Register("published", publishedMeter)
+	registry.Register("dropped", droppedCounter)
+}
+
+// Bus delivers published events to every subscriber.
+// Publish never blocks: events are dropped for a subscriber which is not keeping up
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBus creates an empty Bus
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// DefaultBus is used by Publish
+var DefaultBus = NewBus()
+
+// Publish sends the event to DefaultBus
+func Publish(e Event) {
+	DefaultBus.Publish(e)
+}
+
+// Publish sends the event to every subscriber
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	publishedMeter.Mark(1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subscribers {
+		select {
+		case s.ch <- e:
+		default:
+			droppedCounter.Inc(1)
+		}
+	}
+}
+
+// Subscribe creates a subscription with a buffer of a given size
+func (b *Bus) Subscribe(size int) *Subscription {
+	s := &Subscription{
+		bus: b,
+		ch:  make(chan Event, size),
+	}
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Subscription receives events from a Bus
+type Subscription struct {
+	bus  *Bus
+	ch   chan Event
+	once sync.Once
+}
+
+// Events returns a channel of events. It's closed by Close
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close unsubscribes from the Bus
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscribers, s)
+		s.bus.mu.Unlock()
+		close(s.ch)
+	})
+}
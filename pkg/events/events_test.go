@@ -0,0 +1,129 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/secret"
+)
+
+func TestBusDropsForSlowSubscriber(t *testing.T) {
+	assertT := require.New(t)
+
+	bus := NewBus()
+	sub := bus.Subscribe(1)
+	bus.Publish(Event{Type: SpawnRequested, App: "echo"})
+	bus.Publish(Event{Type: SpawnStarted, App: "echo"})
+
+	e := <-sub.Events()
+	assertT.Equal(SpawnRequested, e.Type)
+	assertT.False(e.Time.IsZero())
+
+	sub.Close()
+	_, ok := <-sub.Events()
+	assertT.False(ok)
+	bus.Publish(Event{Type: SpawnFailed})
+}
+
+func TestFileSink(t *testing.T) {
+	assertT := require.New(t)
+
+	dir, err := ioutil.TempDir("", "stout-events")
+	assertT.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+
+	sink, err := NewFileSink(path)
+	assertT.NoError(err)
+	assertT.NoError(sink.Handle(context.Background(), Event{Type: SpoolStarted, App: "echo", Box: "porto"}))
+	assertT.NoError(sink.Handle(context.Background(), Event{Type: SpoolFinished, App: "echo", Box: "porto", Duration: 1.5}))
+	assertT.NoError(sink.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assertT.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assertT.Len(lines, 2)
+
+	var e Event
+	assertT.NoError(json.Unmarshal([]byte(lines[1]), &e))
+	assertT.Equal(SpoolFinished, e.Type)
+	assertT.Equal(1.5, e.Duration)
+}
+
+func TestWebhookSinkRetries(t *testing.T) {
+	assertT := require.New(t)
+
+	var calls int32
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		assertT.Equal("token", r.Header.Get("Authorization"))
+		var e Event
+		assertT.NoError(json.NewDecoder(r.Body).Decode(&e))
+		received <- e
+	}))
+	defer srv.Close()
+
+	sink, err := NewWebhookSink(&WebhookConfig{
+		URL:     srv.URL,
+		Headers: map[string]secret.String{"Authorization": "token"},
+		Types:   []Type{SpawnFailed},
+	})
+	assertT.NoError(err)
+	sink.backoff = time.Millisecond
+
+	assertT.NoError(sink.Handle(context.Background(), Event{Type: SpawnStarted}))
+	assertT.Equal(int32(0), atomic.LoadInt32(&calls))
+
+	assertT.NoError(sink.Handle(context.Background(), Event{Type: SpawnFailed, UUID: "uuid"}))
+	assertT.Equal("uuid", (<-received).UUID)
+	assertT.Equal(int32(3), atomic.LoadInt32(&calls))
+
+	sink.retries = 1
+	atomic.StoreInt32(&calls, -10)
+	assertT.Error(sink.Handle(context.Background(), Event{Type: SpawnFailed}))
+}
+
+func TestSSEHandler(t *testing.T) {
+	assertT := require.New(t)
+
+	bus := NewBus()
+	srv := httptest.NewServer(SSEHandler(bus))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?app=echo")
+	assertT.NoError(err)
+	defer resp.Body.Close()
+	assertT.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the handler has subscribed once the headers are flushed
+	bus.Publish(Event{Type: SpawnStarted, App: "other"})
+	bus.Publish(Event{Type: SpawnStarted, App: "echo", UUID: "uuid"})
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	assertT.NoError(err)
+	assertT.Equal("event: spawn.started\n", line)
+	line, err = r.ReadString('\n')
+	assertT.NoError(err)
+	assertT.True(strings.HasPrefix(line, "data: "))
+
+	var e Event
+	assertT.NoError(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+	assertT.Equal("uuid", e.UUID)
+}
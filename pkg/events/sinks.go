@@ -0,0 +1,216 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+	"github.com/noxiouz/stout/pkg/secret"
+)
+
+const (
+	defaultSinkBufferSize  = 1024
+	defaultWebhookRetries  = 3
+	defaultWebhookTimeout  = 5 * time.Second
+	defaultWebhookBackoff  = time.Second
+	maxWebhookBackoffShift = 6
+)
+
+// Config describes `events` section
+type Config struct {
+	// File is a path to an append-only JSON-lines file
+	File     string          `json:"file"`
+	Webhooks []WebhookConfig `json:"webhooks"`
+	// BufferSize is the number of events a sink may lag behind before they are dropped
+	BufferSize int `json:"buffersize"`
+}
+
+// WebhookConfig describes an endpoint events are POSTed to
+type WebhookConfig struct {
+	URL     string                   `json:"url"`
+	Headers map[string]secret.String `json:"headers"`
+	Retries int                      `json:"retries"`
+	// Types limits events sent to the webhook. All events are sent if it's empty
+	Types []Type `json:"types"`
+}
+
+// Sink handles events of a subscription
+type Sink interface {
+	Handle(ctx context.Context, e Event) error
+}
+
+// Start subscribes configured sinks to the bus
+func Start(ctx context.Context, cfg *Config, bus *Bus) error {
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = defaultSinkBufferSize
+	}
+
+	if cfg.File != "" {
+		sink, err := NewFileSink(cfg.File)
+		if err != nil {
+			return err
+		}
+		go func() {
+			Run(ctx, bus.Subscribe(size), sink)
+			sink.Close()
+		}()
+	}
+
+	for i := range cfg.Webhooks {
+		sink, err := NewWebhookSink(&cfg.Webhooks[i])
+		if err != nil {
+			return err
+		}
+		go Run(ctx, bus.Subscribe(size), sink)
+	}
+
+	return nil
+}
+
+// Run passes events of the subscription to the sink until ctx is cancelled
+func Run(ctx context.Context, sub *Subscription, sink Sink) {
+	defer sub.Close()
+	for {
+		select {
+		case e := <-sub.Events():
+			if err := sink.Handle(ctx, e); err != nil {
+				log.G(ctx).WithError(err).WithField("event", e.Type).Error("unable to handle event")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// FileSink appends events to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file io.WriteCloser
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (f *FileSink) Handle(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(data, '\n'))
+	return err
+}
+
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// WebhookSink POSTs every event as JSON retrying with exponential backoff
+type WebhookSink struct {
+	url     string
+	headers map[string]secret.String
+	retries int
+	types   map[Type]struct{}
+	backoff time.Duration
+	client  *http.Client
+}
+
+func NewWebhookSink(cfg *WebhookConfig) (*WebhookSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook url must be specified")
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = defaultWebhookRetries
+	}
+
+	var types map[Type]struct{}
+	if len(cfg.Types) > 0 {
+		types = make(map[Type]struct{}, len(cfg.Types))
+		for _, typ := range cfg.Types {
+			types[typ] = struct{}{}
+		}
+	}
+
+	return &WebhookSink{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		retries: cfg.Retries,
+		types:   types,
+		backoff: defaultWebhookBackoff,
+		client:  &http.Client{Timeout: defaultWebhookTimeout},
+	}, nil
+}
+
+func (w *WebhookSink) Handle(ctx context.Context, e Event) error {
+	if w.types != nil {
+		if _, ok := w.types[e.Type]; !ok {
+			return nil
+		}
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err = w.post(ctx, body); err == nil {
+			return nil
+		}
+		if attempt >= w.retries {
+			return fmt.Errorf("webhook %s: %d attempts failed: %v", w.url, attempt+1, err)
+		}
+
+		shift := uint(attempt)
+		if shift > maxWebhookBackoffShift {
+			shift = maxWebhookBackoffShift
+		}
+		select {
+		case <-time.After(w.backoff << shift):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *WebhookSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for header, value := range w.headers {
+		req.Header.Set(header, value.Value())
+	}
+
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected reply %s", resp.Status)
+	}
+	return nil
+}
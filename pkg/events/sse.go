@@ -0,0 +1,55 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const sseBufferSize = 256
+
+// SSEHandler streams events as Server-Sent Events.
+// Query arguments `app`, `box` and `type` filter the stream.
+func SSEHandler(bus *Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		match := func(e Event) bool {
+			return (query.Get("app") == "" || query.Get("app") == e.App) &&
+				(query.Get("box") == "" || query.Get("box") == e.Box) &&
+				(query.Get("type") == "" || query.Get("type") == string(e.Type))
+		}
+
+		sub := bus.Subscribe(sseBufferSize)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case e := <-sub.Events():
+				if !match(e) {
+					continue
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
@@ -3,6 +3,7 @@ package exportmetrics
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	metrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/net/context"
@@ -21,18 +22,56 @@ func (noopSender) Send(ctx context.Context, registry metrics.Registry) error {
 	return nil
 }
 
-// New create new Sender
-func New(ctx context.Context, configuration *isolate.Config) (Sender, error) {
-	switch name := configuration.Metrics.Type; name {
+// Exporter is a configured Sender which sends metrics every Period
+type Exporter struct {
+	Type   string
+	Period time.Duration
+	Sender Sender
+}
+
+// New creates Exporters for every sender described in the configuration
+func New(ctx context.Context, configuration *isolate.Config) ([]Exporter, error) {
+	exportersCfg := configuration.MetricsExporters()
+	if len(exportersCfg) == 0 {
+		log.G(ctx).Warn("metrics: exporter is not specified")
+		return nil, nil
+	}
+
+	exporters := make([]Exporter, 0, len(exportersCfg))
+	for _, cfg := range exportersCfg {
+		sender, err := NewSender(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, Exporter{
+			Type:   cfg.Type,
+			Period: time.Duration(cfg.Period),
+			Sender: sender,
+		})
+	}
+
+	return exporters, nil
+}
+
+// NewSender creates Sender of a given type
+func NewSender(ctx context.Context, cfg isolate.MetricsExporterConfig) (Sender, error) {
+	switch name := cfg.Type; name {
 	case "graphite":
-		var cfg GraphiteConfig
-		if err := json.Unmarshal(configuration.Metrics.Args, &cfg); err != nil {
+		var graphiteCfg GraphiteConfig
+		if err := json.Unmarshal(cfg.Args, &graphiteCfg); err != nil {
 			log.G(ctx).WithError(err).WithField("name", name).Error("unable to decode graphite exporter config")
 			return nil, err
 		}
-		return NewGraphiteExporter(&cfg)
+		return NewGraphiteExporter(&graphiteCfg)
+	case "statsd":
+		var statsdCfg StatsdConfig
+		if err := json.Unmarshal(cfg.Args, &statsdCfg); err != nil {
+			log.G(ctx).WithError(err).WithField("name", name).Error("unable to decode statsd exporter config")
+			return nil, err
+		}
+		return NewStatsdExporter(&statsdCfg)
 	case "":
-		log.G(ctx).Warn("metrics: exporter is not specified")
+		log.G(ctx).Warn("metrics: exporter type is not specified")
 		return noopSender{}, nil
 	default:
 		log.G(ctx).WithField("exporter", name).Error("unknown exporter")
@@ -1,7 +1,6 @@
 package exportmetrics
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"html/template"
@@ -9,6 +8,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -19,18 +19,33 @@ import (
 
 const defaultPrefix = "{{hostname}}"
 
+const (
+	defaultGraphiteBufferSize = 4 << 20
+	defaultGraphiteTimeout    = 10 * time.Second
+)
+
+// GraphiteExporter keeps a connection to Graphite between intervals.
+// Intervals which could not be sent are buffered and resent after reconnect.
 type GraphiteExporter struct {
 	prefix      string
 	addr        string
 	duStr       string
 	du          time.Duration
 	percentiles []float64
+	bufferSize  int
+
+	mu          sync.Mutex
+	conn        net.Conn
+	pending     [][]byte
+	pendingSize int
 }
 
 type GraphiteConfig struct {
 	Prefix       string `json:"prefix"`
 	Addr         string `json:"addr"`
 	DurationUnit string `json:"duration"`
+	// BufferSize limits the size of unsent metrics in bytes
+	BufferSize int `json:"buffersize"`
 }
 
 var funcMap = template.FuncMap{
@@ -69,32 +84,46 @@ func NewGraphiteExporter(cfg *GraphiteConfig) (*GraphiteExporter, error) {
 		return nil, err
 	}
 
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultGraphiteBufferSize
+	}
+
 	return &GraphiteExporter{
 		prefix:      buff.String(),
 		addr:        cfg.Addr,
 		duStr:       cfg.DurationUnit[1:],
 		du:          du,
 		percentiles: []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+		bufferSize:  cfg.BufferSize,
 	}, nil
 }
 
 func (g *GraphiteExporter) Send(ctx context.Context, r metrics.Registry) error {
-	d := net.Dialer{
-		DualStack: true,
-		Cancel:    ctx.Done(),
-	}
+	chunk := g.render(ctx, r)
 
-	conn, err := d.Dial("tcp", g.addr)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	if deadline, ok := ctx.Deadline(); ok {
-		conn.SetWriteDeadline(deadline)
+	g.enqueue(ctx, chunk)
+	return g.flush(ctx)
+}
+
+// Close closes the connection. Pending metrics are dropped
+func (g *GraphiteExporter) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending, g.pendingSize = nil, 0
+	if g.conn == nil {
+		return nil
 	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
 
-	w := bufio.NewWriter(conn)
+func (g *GraphiteExporter) render(ctx context.Context, r metrics.Registry) []byte {
+	w := new(bytes.Buffer)
 	now := time.Now().Unix()
 	r.Each(func(name string, value interface{}) {
 		switch metric := value.(type) {
@@ -130,7 +159,73 @@ func (g *GraphiteExporter) Send(ctx context.Context, r metrics.Registry) error {
 		default:
 			log.G(ctx).Warnf("Graphite: skip metric `%s` of unknown type %T", name, value)
 		}
-		w.Flush()
 	})
+	return w.Bytes()
+}
+
+// enqueue appends the chunk to the buffer dropping the oldest chunks on overflow.
+// The latest chunk is kept even if it's larger than the buffer.
+func (g *GraphiteExporter) enqueue(ctx context.Context, chunk []byte) {
+	var dropped int
+	for len(g.pending) > 0 && g.pendingSize+len(chunk) > g.bufferSize {
+		g.pendingSize -= len(g.pending[0])
+		g.pending[0] = nil
+		g.pending = g.pending[1:]
+		dropped++
+	}
+	if dropped > 0 {
+		log.G(ctx).WithField("dropped", dropped).Warn("Graphite: buffer is full, the oldest intervals have been dropped")
+	}
+
+	g.pending = append(g.pending, chunk)
+	g.pendingSize += len(chunk)
+}
+
+// flush writes buffered chunks, (re)connecting if needed.
+// Chunks are kept in the buffer until they are written completely.
+func (g *GraphiteExporter) flush(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultGraphiteTimeout)
+	}
+
+	if g.conn == nil {
+		d := net.Dialer{
+			DualStack: true,
+			Deadline:  deadline,
+			Cancel:    ctx.Done(),
+		}
+		conn, err := d.Dial("tcp", g.addr)
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+
+	g.conn.SetWriteDeadline(deadline)
+	for len(g.pending) > 0 {
+		chunk := g.pending[0]
+		n, err := g.conn.Write(chunk)
+		if err != nil {
+			// a partially written line can not be resent, skip it
+			if n > 0 && chunk[n-1] != '\n' {
+				if idx := bytes.IndexByte(chunk[n:], '\n'); idx >= 0 {
+					n += idx + 1
+				} else {
+					n = len(chunk)
+				}
+			}
+			g.pending[0] = chunk[n:]
+			g.pendingSize -= n
+			g.conn.Close()
+			g.conn = nil
+			return err
+		}
+
+		g.pendingSize -= len(chunk)
+		g.pending[0] = nil
+		g.pending = g.pending[1:]
+	}
+	g.pending = nil
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -52,6 +53,70 @@ func TestGraphite(t *testing.T) {
 	if err = gr.Send(ctx, metrics.DefaultRegistry); err != nil {
 		t.Fatalf("gr.Send: %v", err)
 	}
+	gr.Close()
 
 	wg.Wait()
 }
+
+func TestGraphiteBuffer(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	r := metrics.NewRegistry()
+	counter := metrics.NewCounter()
+	r.Register("counter", counter)
+
+	gr, err := NewGraphiteExporter(&GraphiteConfig{
+		Prefix:     "PREFIX",
+		Addr:       addr,
+		BufferSize: 60,
+	})
+	if err != nil {
+		t.Fatalf("NewGraphiteExporter: %v", err)
+	}
+	defer gr.Close()
+
+	// Graphite is down: the buffer holds two intervals, the oldest one is dropped on overflow
+	for i := 1; i <= 3; i++ {
+		counter.Inc(1)
+		if err = gr.Send(context.Background(), r); err == nil {
+			t.Fatalf("gr.Send must fail while Graphite is down")
+		}
+	}
+	if len(gr.pending) != 2 {
+		t.Fatalf("2 intervals are expected to be buffered: %q", gr.pending)
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("unable to listen %s again: %v", addr, err)
+	}
+	defer l.Close()
+
+	counter.Inc(1)
+	if err = gr.Send(context.Background(), r); err != nil {
+		t.Fatalf("gr.Send: %v", err)
+	}
+	gr.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("l.Accept(): %v", err)
+	}
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll: %v", err)
+	}
+
+	var values []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		values = append(values, strings.Fields(line)[1])
+	}
+	if strings.Join(values, ",") != "3,4" {
+		t.Fatalf("unexpected lines: %s", b)
+	}
+}
@@ -0,0 +1,247 @@
+package exportmetrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/isolate"
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	defaultPrometheusNamespace = "isolate"
+)
+
+var defaultPercentiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+// PrometheusConfig describes `metrics.prometheus` section
+type PrometheusConfig struct {
+	// Namespace is prepended to every metric name
+	Namespace   string    `json:"namespace"`
+	Percentiles []float64 `json:"percentiles"`
+}
+
+// PrometheusExporter renders go-metrics registry in Prometheus text exposition format.
+//
+// Names are mapped as follows:
+//
+//	porto_containers_created -> isolate_containers_created_total{box="porto"}
+//	daemon_open_fds          -> isolate_daemon_open_fds
+//
+// Graphite-style tags (`name;app=echo`) become labels as well.
+type PrometheusExporter struct {
+	registry    metrics.Registry
+	namespace   string
+	percentiles []float64
+	boxes       map[string]struct{}
+}
+
+// NewPrometheus creates an exporter from the configuration
+func NewPrometheus(ctx context.Context, configuration *isolate.Config, registry metrics.Registry) (*PrometheusExporter, error) {
+	var cfg PrometheusConfig
+	if raw := configuration.Metrics.Prometheus; len(raw) != 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			log.G(ctx).WithError(err).Error("unable to decode prometheus exporter config")
+			return nil, err
+		}
+	}
+
+	return NewPrometheusExporter(&cfg, registry)
+}
+
+// NewPrometheusExporter returns an exporter for the registry.
+// Metrics prefixed by a registered box type get the `box` label.
+func NewPrometheusExporter(cfg *PrometheusConfig, registry metrics.Registry) (*PrometheusExporter, error) {
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultPrometheusNamespace
+	}
+	if len(cfg.Percentiles) == 0 {
+		cfg.Percentiles = defaultPercentiles
+	}
+	for _, p := range cfg.Percentiles {
+		if p <= 0 || p >= 1 {
+			return nil, fmt.Errorf("percentile must be in (0, 1): %v", p)
+		}
+	}
+
+	boxes := make(map[string]struct{})
+	for _, name := range isolate.RegisteredBoxes() {
+		boxes[name] = struct{}{}
+	}
+
+	return &PrometheusExporter{
+		registry:    registry,
+		namespace:   sanitizePrometheusName(cfg.Namespace),
+		percentiles: cfg.Percentiles,
+		boxes:       boxes,
+	}, nil
+}
+
+type promSample struct {
+	suffix string
+	labels []string
+	value  float64
+}
+
+type promFamily struct {
+	name    string
+	typ     string
+	samples []promSample
+}
+
+func (p *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prometheusContentType)
+	if err := p.Write(w); err != nil {
+		log.G(r.Context()).WithError(err).Error("unable to write prometheus metrics")
+	}
+}
+
+// Write renders all metrics from the registry
+func (p *PrometheusExporter) Write(wr io.Writer) error {
+	families := make(map[string]*promFamily)
+	add := func(name, typ string, s promSample) {
+		f, ok := families[name]
+		if !ok {
+			f = &promFamily{name: name, typ: typ}
+			families[name] = f
+		}
+		f.samples = append(f.samples, s)
+	}
+
+	p.registry.Each(func(rawName string, value interface{}) {
+		name, labels := p.mapName(rawName)
+		switch metric := value.(type) {
+		case metrics.Counter:
+			add(name+"_total", "counter", promSample{labels: labels, value: float64(metric.Count())})
+		case metrics.Gauge:
+			add(name, "gauge", promSample{labels: labels, value: float64(metric.Value())})
+		case metrics.GaugeFloat64:
+			add(name, "gauge", promSample{labels: labels, value: metric.Value()})
+		case metrics.Healthcheck:
+			var healthy float64
+			if metric.Error() == nil {
+				healthy = 1
+			}
+			add(name, "gauge", promSample{labels: labels, value: healthy})
+		case metrics.Meter:
+			m := metric.Snapshot()
+			add(name+"_total", "counter", promSample{labels: labels, value: float64(m.Count())})
+			for _, rate := range []struct {
+				window string
+				value  float64
+			}{{"1m", m.Rate1()}, {"5m", m.Rate5()}, {"15m", m.Rate15()}, {"mean", m.RateMean()}} {
+				add(name+"_rate", "gauge", promSample{labels: withLabel(labels, "window", rate.window), value: rate.value})
+			}
+		case metrics.Timer:
+			t := metric.Snapshot()
+			scale := float64(time.Second)
+			p.addSummary(add, name+"_seconds", labels, t.Percentiles(p.percentiles), t.Count(), t.Mean()*float64(t.Count()), scale)
+		case metrics.Histogram:
+			h := metric.Snapshot()
+			p.addSummary(add, name, labels, h.Percentiles(p.percentiles), h.Count(), h.Mean()*float64(h.Count()), 1)
+		}
+	})
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(wr)
+	for _, name := range names {
+		f := families[name]
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.samples {
+			w.WriteString(f.name)
+			w.WriteString(s.suffix)
+			writeLabels(w, s.labels)
+			w.WriteByte(' ')
+			w.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+			w.WriteByte('\n')
+		}
+	}
+	return w.Flush()
+}
+
+func (p *PrometheusExporter) addSummary(add func(string, string, promSample), name string, labels []string, ps []float64, count int64, sum float64, scale float64) {
+	for i, q := range p.percentiles {
+		add(name, "summary", promSample{
+			labels: withLabel(labels, "quantile", strconv.FormatFloat(q, 'g', -1, 64)),
+			value:  ps[i] / scale,
+		})
+	}
+	add(name, "summary", promSample{suffix: "_sum", labels: labels, value: sum / scale})
+	add(name, "summary", promSample{suffix: "_count", labels: labels, value: float64(count)})
+}
+
+// mapName converts go-metrics name to Prometheus name and a flat list of label pairs
+func (p *PrometheusExporter) mapName(rawName string) (string, []string) {
+	parts := strings.Split(rawName, ";")
+	name := parts[0]
+
+	var labels []string
+	if idx := strings.IndexByte(name, '_'); idx > 0 {
+		if _, ok := p.boxes[name[:idx]]; ok {
+			labels = append(labels, "box", name[:idx])
+			name = name[idx+1:]
+		}
+	}
+
+	for _, tag := range parts[1:] {
+		if kv := strings.SplitN(tag, "=", 2); len(kv) == 2 {
+			labels = append(labels, sanitizePrometheusName(kv[0]), kv[1])
+		}
+	}
+
+	return p.namespace + "_" + sanitizePrometheusName(name), labels
+}
+
+func withLabel(labels []string, name, value string) []string {
+	result := make([]string, len(labels), len(labels)+2)
+	copy(result, labels)
+	return append(result, name, value)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabels(w *bufio.Writer, labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(labels[i])
+		w.WriteString(`="`)
+		w.WriteString(labelValueEscaper.Replace(labels[i+1]))
+		w.WriteByte('"')
+	}
+	w.WriteByte('}')
+}
+
+// sanitizePrometheusName replaces characters which are not allowed in metric and label names
+func sanitizePrometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
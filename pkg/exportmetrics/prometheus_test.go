@@ -0,0 +1,68 @@
+package exportmetrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	assertT := require.New(t)
+
+	r := metrics.NewRegistry()
+	boxRegistry := metrics.NewPrefixedChildRegistry(r, "process_")
+
+	created := metrics.NewCounter()
+	created.Inc(3)
+	boxRegistry.Register("procs_created", created)
+
+	fds := metrics.NewGauge()
+	fds.Update(42)
+	r.Register("daemon_open_fds", fds)
+
+	timer := metrics.NewTimer()
+	timer.Update(time.Second)
+	timer.Update(3 * time.Second)
+	boxRegistry.Register("total_spawn_timer", timer)
+
+	meter := metrics.NewMeter()
+	meter.Mark(5)
+	r.Register("isolate_spawn_meter;app=echo", meter)
+
+	r.Register("daemon_hc_ok", metrics.NewHealthcheck(func(h metrics.Healthcheck) { h.Healthy() }))
+	r.Register("daemon_hc_failed", metrics.NewHealthcheck(func(h metrics.Healthcheck) { h.Unhealthy(errors.New("failed")) }))
+	r.RunHealthchecks()
+
+	exporter, err := NewPrometheusExporter(&PrometheusConfig{Percentiles: []float64{0.5, 0.99}}, r)
+	assertT.NoError(err)
+
+	var buff bytes.Buffer
+	assertT.NoError(exporter.Write(&buff))
+	out := buff.String()
+	t.Log(out)
+
+	for _, line := range []string{
+		"# TYPE isolate_procs_created_total counter",
+		`isolate_procs_created_total{box="process"} 3`,
+		"# TYPE isolate_daemon_open_fds gauge",
+		"isolate_daemon_open_fds 42",
+		"# TYPE isolate_total_spawn_timer_seconds summary",
+		`isolate_total_spawn_timer_seconds{box="process",quantile="0.5"} 2`,
+		`isolate_total_spawn_timer_seconds_sum{box="process"} 4`,
+		`isolate_total_spawn_timer_seconds_count{box="process"} 2`,
+		`isolate_isolate_spawn_meter_total{app="echo"} 5`,
+		`isolate_daemon_hc_ok 1`,
+		`isolate_daemon_hc_failed 0`,
+	} {
+		assertT.Contains(out, line+"\n")
+	}
+	assertT.Equal(1, strings.Count(out, "# TYPE isolate_isolate_spawn_meter_rate gauge"))
+
+	_, err = NewPrometheusExporter(&PrometheusConfig{Percentiles: []float64{99}}, r)
+	assertT.Error(err)
+}
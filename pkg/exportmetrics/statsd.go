@@ -0,0 +1,213 @@
+package exportmetrics
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+// 1500 MTU minus IPv6 and UDP headers
+const defaultStatsdPacketSize = 1432
+
+type StatsdConfig struct {
+	Prefix string `json:"prefix"`
+	Addr   string `json:"addr"`
+	// DogStatsd enables DogStatsD tags. Graphite-style tags of a metric name (`name;app=echo`)
+	// are sent as DogStatsD tags as well
+	DogStatsd     bool              `json:"dogstatsd"`
+	Tags          map[string]string `json:"tags"`
+	MaxPacketSize int               `json:"maxpacketsize"`
+}
+
+// StatsdExporter sends metrics over UDP using StatsD line protocol.
+// StatsD aggregates values itself, so counters are sent as increments since the previous interval
+// and the rest as gauges.
+type StatsdExporter struct {
+	prefix        string
+	addr          string
+	dogstatsd     bool
+	tags          []string
+	maxPacketSize int
+	percentiles   []float64
+
+	mu       sync.Mutex
+	conn     net.Conn
+	counters map[string]int64
+}
+
+func NewStatsdExporter(cfg *StatsdConfig) (*StatsdExporter, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("statsd: `addr` must be specified")
+	}
+
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultPrefix
+	}
+	tmpl, err := template.New("statsdPrefix").Funcs(funcMap).Parse(cfg.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var buff = new(bytes.Buffer)
+	if err = tmpl.Execute(buff, ""); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Tags) > 0 && !cfg.DogStatsd {
+		return nil, fmt.Errorf("statsd: `tags` require `dogstatsd`")
+	}
+	tags := make([]string, 0, len(cfg.Tags))
+	for k, v := range cfg.Tags {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+
+	if cfg.MaxPacketSize <= 0 {
+		cfg.MaxPacketSize = defaultStatsdPacketSize
+	}
+
+	return &StatsdExporter{
+		prefix:        buff.String(),
+		addr:          cfg.Addr,
+		dogstatsd:     cfg.DogStatsd,
+		tags:          tags,
+		maxPacketSize: cfg.MaxPacketSize,
+		percentiles:   []float64{0.5, 0.75, 0.95, 0.99, 0.999},
+		counters:      make(map[string]int64),
+	}, nil
+}
+
+func (s *StatsdExporter) Send(ctx context.Context, r metrics.Registry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.Dial("udp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	p := statsdPacker{conn: s.conn, size: s.maxPacketSize}
+	r.Each(func(rawName string, value interface{}) {
+		name, tags := s.mapName(rawName)
+		switch metric := value.(type) {
+		case metrics.Counter:
+			p.add(name, s.delta(rawName, metric.Count()), "c", tags)
+		case metrics.Gauge:
+			p.gauge(name, float64(metric.Value()), tags)
+		case metrics.GaugeFloat64:
+			p.gauge(name, metric.Value(), tags)
+		case metrics.Meter:
+			m := metric.Snapshot()
+			p.add(name+".count", s.delta(rawName, m.Count()), "c", tags)
+			p.gauge(name+".rate1m", m.Rate1(), tags)
+		case metrics.Timer:
+			t := metric.Snapshot()
+			ps := t.Percentiles(s.percentiles)
+			p.add(name+".count", s.delta(rawName, t.Count()), "c", tags)
+			p.gauge(name+".mean_ms", t.Mean()/1e6, tags)
+			for psIdx, psKey := range s.percentiles {
+				key := strings.Replace(strconv.FormatFloat(psKey*100.0, 'f', -1, 64), ".", "", 1)
+				p.gauge(name+"."+key+"_ms", ps[psIdx]/1e6, tags)
+			}
+			p.gauge(name+".rate1m", t.Rate1(), tags)
+		case metrics.Healthcheck:
+			var healthy float64
+			if metric.Error() == nil {
+				healthy = 1
+			}
+			p.gauge(name, healthy, tags)
+		default:
+			log.G(ctx).Warnf("StatsD: skip metric `%s` of unknown type %T", rawName, value)
+		}
+	})
+
+	return p.flush()
+}
+
+// delta returns an increment of the counter since the previous interval
+func (s *StatsdExporter) delta(name string, count int64) float64 {
+	prev, ok := s.counters[name]
+	s.counters[name] = count
+	if !ok || count < prev {
+		return float64(count)
+	}
+	return float64(count - prev)
+}
+
+// mapName returns StatsD name and the tags suffix
+func (s *StatsdExporter) mapName(rawName string) (string, string) {
+	name := rawName
+	var tags []string
+	if s.dogstatsd {
+		parts := strings.Split(rawName, ";")
+		name = parts[0]
+		tags = append(tags, s.tags...)
+		for _, tag := range parts[1:] {
+			if kv := strings.SplitN(tag, "=", 2); len(kv) == 2 {
+				tags = append(tags, kv[0]+":"+kv[1])
+			}
+		}
+	}
+	name = statsdNameEscaper.Replace(name)
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+
+	if len(tags) == 0 {
+		return name, ""
+	}
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+// `:` and `|` separate a value and a type in the line protocol
+var statsdNameEscaper = strings.NewReplacer(":", "_", "|", "_", "\n", "_")
+
+// statsdPacker batches lines into UDP packets not larger than size
+type statsdPacker struct {
+	conn net.Conn
+	size int
+	buff bytes.Buffer
+	err  error
+}
+
+func (p *statsdPacker) gauge(name string, value float64, tags string) {
+	// a signed value modifies a gauge, so a negative one has to be reset first
+	if value < 0 {
+		p.add(name, 0, "g", tags)
+	}
+	p.add(name, value, "g", tags)
+}
+
+func (p *statsdPacker) add(name string, value float64, typ string, tags string) {
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ + tags
+	if p.buff.Len() > 0 && p.buff.Len()+1+len(line) > p.size {
+		p.flush()
+	}
+	if p.buff.Len() > 0 {
+		p.buff.WriteByte('\n')
+	}
+	p.buff.WriteString(line)
+}
+
+// flush sends the batched lines. The first error is kept and returned
+func (p *statsdPacker) flush() error {
+	if p.buff.Len() > 0 {
+		if _, err := p.conn.Write(p.buff.Bytes()); err != nil && p.err == nil {
+			p.err = err
+		}
+		p.buff.Reset()
+	}
+	return p.err
+}
@@ -0,0 +1,80 @@
+package exportmetrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func readStatsd(t *testing.T, conn net.PacketConn, maxPacketSize int) []string {
+	var lines []string
+	buff := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buff)
+		if err != nil {
+			break
+		}
+		require.True(t, n <= maxPacketSize, "packet is too large: %d", n)
+		lines = append(lines, strings.Split(string(buff[:n]), "\n")...)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestStatsd(t *testing.T) {
+	assertT := require.New(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assertT.NoError(err)
+	defer conn.Close()
+
+	r := metrics.NewRegistry()
+	counter := metrics.NewCounter()
+	r.Register("procs_created;app=echo", counter)
+	gauge := metrics.NewGauge()
+	r.Register("open_fds", gauge)
+	timer := metrics.NewTimer()
+	r.Register("spawn_timer", timer)
+
+	exporter, err := NewStatsdExporter(&StatsdConfig{
+		Prefix:        "isolate",
+		Addr:          conn.LocalAddr().String(),
+		DogStatsd:     true,
+		Tags:          map[string]string{"dc": "sas"},
+		MaxPacketSize: 128,
+	})
+	assertT.NoError(err)
+
+	counter.Inc(3)
+	gauge.Update(-1)
+	timer.Update(20 * time.Millisecond)
+	assertT.NoError(exporter.Send(context.Background(), r))
+	lines := readStatsd(t, conn, 128)
+	t.Log(lines)
+	for _, line := range []string{
+		"isolate.procs_created:3|c|#dc:sas,app:echo",
+		"isolate.open_fds:0|g|#dc:sas",
+		"isolate.open_fds:-1|g|#dc:sas",
+		"isolate.spawn_timer.count:1|c|#dc:sas",
+		"isolate.spawn_timer.mean_ms:20|g|#dc:sas",
+	} {
+		assertT.Contains(lines, line)
+	}
+
+	// counters are sent as increments
+	counter.Inc(2)
+	assertT.NoError(exporter.Send(context.Background(), r))
+	assertT.Contains(readStatsd(t, conn, 128), "isolate.procs_created:2|c|#dc:sas,app:echo")
+}
+
+func TestStatsdTagsRequireDogStatsd(t *testing.T) {
+	_, err := NewStatsdExporter(&StatsdConfig{Addr: "127.0.0.1:8125", Tags: map[string]string{"dc": "sas"}})
+	require.Error(t, err)
+}
@@ -32,3 +32,12 @@ func GetLogger(ctx context.Context) Logger {
 
 	return L
 }
+
+// ComponentKey is a field naming a component which has written an entry.
+// Levels of components are configured separately
+const ComponentKey = "component"
+
+// WithComponent returns ctx with a logger marking entries by the component
+func WithComponent(ctx context.Context, name string) context.Context {
+	return WithLogger(ctx, G(ctx).WithField(ComponentKey, name))
+}
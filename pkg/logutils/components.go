@@ -0,0 +1,206 @@
+package logutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+
+	stoutlog "github.com/noxiouz/stout/pkg/log"
+)
+
+// Components which have their own loggers
+var Components = []string{"daemon", "connection", "porto", "docker", "process", "mtn", "blobrepo"}
+
+func knownComponent(name string) bool {
+	for _, c := range Components {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ComponentLevels keeps the default level and levels of components.
+// A level set with a timeout is reverted to the previous one when the timeout expires.
+// Levels are read without locks, changes replace the whole snapshot of them
+type ComponentLevels struct {
+	// mu serializes changes
+	mu      sync.Mutex
+	current atomic.Value // *levelsSnapshot
+	reverts map[string]*time.Timer
+}
+
+type levelsSnapshot struct {
+	defaultLvl log.Level
+	levels     map[string]log.Level
+}
+
+// NewComponentLevels validates names of components and creates ComponentLevels
+func NewComponentLevels(defaultLvl Level, levels map[string]Level) (*ComponentLevels, error) {
+	snapshot := &levelsSnapshot{
+		defaultLvl: log.Level(defaultLvl),
+		levels:     make(map[string]log.Level, len(levels)),
+	}
+	for name, lvl := range levels {
+		if !knownComponent(name) {
+			return nil, fmt.Errorf("unknown log component %s", name)
+		}
+		snapshot.levels[name] = log.Level(lvl)
+	}
+	c := &ComponentLevels{reverts: make(map[string]*time.Timer)}
+	c.current.Store(snapshot)
+	return c, nil
+}
+
+func (c *ComponentLevels) snapshot() *levelsSnapshot {
+	return c.current.Load().(*levelsSnapshot)
+}
+
+// Level returns the level of the component or the default one
+func (c *ComponentLevels) Level(component string) log.Level {
+	s := c.snapshot()
+	if lvl, ok := s.levels[component]; ok {
+		return lvl
+	}
+	return s.defaultLvl
+}
+
+// Set changes the level of the component. The default level is changed if component is empty.
+// If timeout is positive the previous level is restored after it
+func (c *ComponentLevels) Set(component string, lvl log.Level, timeout time.Duration) error {
+	if component != "" && !knownComponent(component) {
+		return fmt.Errorf("unknown log component %s", component)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if timer, ok := c.reverts[component]; ok {
+		timer.Stop()
+		delete(c.reverts, component)
+	}
+
+	s := c.snapshot()
+	prevLvl, hadLevel := s.defaultLvl, true
+	if component != "" {
+		prevLvl, hadLevel = s.levels[component]
+	}
+	c.set(component, lvl, true)
+
+	if timeout > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// the level has been changed again
+			if c.reverts[component] != timer {
+				return
+			}
+			delete(c.reverts, component)
+			c.set(component, prevLvl, hadLevel)
+		})
+		c.reverts[component] = timer
+	}
+	return nil
+}
+
+// set must be called under the lock. A component without level falls back to the default one
+func (c *ComponentLevels) set(component string, lvl log.Level, hasLevel bool) {
+	prev := c.snapshot()
+	s := &levelsSnapshot{
+		defaultLvl: prev.defaultLvl,
+		levels:     make(map[string]log.Level, len(prev.levels)+1),
+	}
+	for name, componentLvl := range prev.levels {
+		s.levels[name] = componentLvl
+	}
+
+	switch {
+	case component == "":
+		s.defaultLvl = lvl
+	case hasLevel:
+		s.levels[component] = lvl
+	default:
+		delete(s.levels, component)
+	}
+
+	c.current.Store(s)
+}
+
+type levelsReport struct {
+	Default    string            `json:"default"`
+	Components map[string]string `json:"components"`
+}
+
+func (c *ComponentLevels) report() levelsReport {
+	s := c.snapshot()
+	r := levelsReport{
+		Default:    s.defaultLvl.String(),
+		Components: make(map[string]string, len(Components)),
+	}
+	for _, name := range Components {
+		lvl, ok := s.levels[name]
+		if !ok {
+			lvl = s.defaultLvl
+		}
+		r.Components[name] = lvl.String()
+	}
+	return r
+}
+
+// ServeHTTP reports levels on GET.
+// POST changes a level: `?component=porto&level=debug&timeout=10m`.
+// The default level is changed if `component` is omitted, `timeout` is optional
+func (c *ComponentLevels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST", "PUT":
+		query := r.URL.Query()
+		lvl, err := log.ParseLevel(query.Get("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var timeout time.Duration
+		if raw := query.Get("timeout"); raw != "" {
+			if timeout, err = time.ParseDuration(raw); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err = c.Set(query.Get("component"), lvl, timeout); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.report())
+}
+
+type componentHandler struct {
+	log.Handler
+	levels *ComponentLevels
+}
+
+// NewComponentHandler drops entries below the level of the component named by the `component` field.
+// The level of log.Logger must be the lowest one, levels can be lowered at runtime
+func NewComponentHandler(h log.Handler, levels *ComponentLevels) log.Handler {
+	return &componentHandler{Handler: h, levels: levels}
+}
+
+func (ch *componentHandler) HandleLog(entry *log.Entry) error {
+	component, _ := entry.Fields[stoutlog.ComponentKey].(string)
+	if entry.Level < ch.levels.Level(component) {
+		return nil
+	}
+	return ch.Handler.HandleLog(entry)
+}
@@ -0,0 +1,119 @@
+package logutils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/require"
+)
+
+type countingHandler struct {
+	count int
+}
+
+func (h *countingHandler) HandleLog(entry *log.Entry) error {
+	h.count++
+	return nil
+}
+
+func TestComponentHandler(t *testing.T) {
+	assertT := require.New(t)
+
+	levels, err := NewComponentLevels(Level(log.InfoLevel), map[string]Level{"porto": Level(log.ErrorLevel)})
+	assertT.NoError(err)
+
+	h := &countingHandler{}
+	handler := NewComponentHandler(h, levels)
+	for _, c := range []struct {
+		component string
+		level     log.Level
+		passed    bool
+	}{
+		{"porto", log.WarnLevel, false},
+		{"porto", log.ErrorLevel, true},
+		{"mtn", log.DebugLevel, false},
+		{"mtn", log.InfoLevel, true},
+		{"", log.InfoLevel, true},
+	} {
+		before := h.count
+		assertT.NoError(handler.HandleLog(&log.Entry{Fields: log.Fields{"component": c.component}, Level: c.level}))
+		assertT.Equal(c.passed, h.count > before, "%+v", c)
+	}
+
+	_, err = NewComponentLevels(Level(log.InfoLevel), map[string]Level{"unknown": Level(log.ErrorLevel)})
+	assertT.Error(err)
+}
+
+func TestComponentLevelsRevert(t *testing.T) {
+	assertT := require.New(t)
+
+	levels, err := NewComponentLevels(Level(log.InfoLevel), nil)
+	assertT.NoError(err)
+
+	assertT.NoError(levels.Set("porto", log.DebugLevel, 50*time.Millisecond))
+	assertT.NoError(levels.Set("", log.WarnLevel, 50*time.Millisecond))
+	assertT.Equal(log.DebugLevel, levels.Level("porto"))
+	assertT.Equal(log.WarnLevel, levels.Level("mtn"))
+
+	time.Sleep(200 * time.Millisecond)
+	// porto falls back to the default level again
+	assertT.Equal(log.InfoLevel, levels.Level("porto"))
+	assertT.Equal(log.InfoLevel, levels.Level("mtn"))
+
+	// a newer change cancels the revert
+	assertT.NoError(levels.Set("docker", log.DebugLevel, 50*time.Millisecond))
+	assertT.NoError(levels.Set("docker", log.ErrorLevel, 0))
+	time.Sleep(200 * time.Millisecond)
+	assertT.Equal(log.ErrorLevel, levels.Level("docker"))
+
+	assertT.Error(levels.Set("unknown", log.DebugLevel, 0))
+}
+
+func TestComponentLevelsHTTP(t *testing.T) {
+	assertT := require.New(t)
+
+	levels, err := NewComponentLevels(Level(log.InfoLevel), nil)
+	assertT.NoError(err)
+
+	rec := httptest.NewRecorder()
+	levels.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/loglevel?component=porto&level=debug&timeout=1m", nil))
+	assertT.Equal(http.StatusOK, rec.Code)
+
+	var report levelsReport
+	assertT.NoError(json.Unmarshal(rec.Body.Bytes(), &report))
+	assertT.Equal("info", report.Default)
+	assertT.Equal("debug", report.Components["porto"])
+	assertT.Equal("info", report.Components["mtn"])
+
+	for _, query := range []string{"component=porto&level=verbose", "component=porto&level=info&timeout=soon", "component=nope&level=info"} {
+		rec = httptest.NewRecorder()
+		levels.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/loglevel?"+query, nil))
+		assertT.Equal(http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+func TestComponentLevelsConcurrentSet(t *testing.T) {
+	assertT := require.New(t)
+
+	levels, err := NewComponentLevels(Level(log.InfoLevel), nil)
+	assertT.NoError(err)
+	logger := &log.Logger{Level: log.DebugLevel, Handler: NewComponentHandler(&countingHandler{}, levels)}
+
+	// levels are changed while entries are being logged
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			log.NewEntry(logger).WithField("component", "porto").Debug("entry")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assertT.NoError(levels.Set("porto", log.Level(i%2), 0))
+	}
+	<-done
+	assertT.Equal(log.InfoLevel, levels.Level("porto"))
+}
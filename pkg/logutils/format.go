@@ -0,0 +1,50 @@
+package logutils
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/apex/log"
+)
+
+// Formats of `logger.format`
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatSyslog = "syslog"
+)
+
+// ValidFormat reports whether format is supported. Empty format means FormatText
+func ValidFormat(format string) bool {
+	switch format {
+	case "", FormatText, FormatJSON, FormatSyslog:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewHandler creates a handler for the format writing to output.
+// For FormatSyslog output is a path to a syslog socket.
+// The returned io.Closer implements Reopener if output is a regular file
+func NewHandler(format, output string) (log.Handler, io.Closer, error) {
+	switch format {
+	case "", FormatText, FormatJSON:
+		w, err := NewLogFileOutput(output)
+		if err != nil {
+			return nil, nil, err
+		}
+		if format == FormatJSON {
+			return NewJSONHandler(w), w, nil
+		}
+		return NewLogHandler(w), w, nil
+	case FormatSyslog:
+		h, err := NewSyslogHandler(output, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		return h, h.(io.Closer), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %s", format)
+	}
+}
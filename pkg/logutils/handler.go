@@ -50,22 +50,32 @@ func NewLogHandler(w io.Writer) log.Handler {
 }
 
 func (lh *logHandler) HandleLog(entry *log.Entry) error {
-	keys := make([]string, 0, len(entry.Fields))
-	for k := range entry.Fields {
-		keys = append(keys, k)
-	}
-
-	sort.Strings(keys)
-
 	buf := bytes.NewBuffer(entry.Timestamp.AppendFormat(getBytesFromPool(), timeFormat))
 	buf.WriteByte('\t')
 	buf.WriteString(getLevel(entry.Level))
 	buf.WriteByte('\t')
+	writeMessage(buf, entry)
+	buf.WriteByte('\n')
+
+	lh.mu.Lock()
+	_, err := buf.WriteTo(lh.Writer)
+	lh.mu.Unlock()
+	bytesPool.Put(buf.Bytes())
+	return err
+}
+
+// writeMessage writes the message followed by sorted fields: `message\t[a: 1, b: 2]`
+func writeMessage(buf *bytes.Buffer, entry *log.Entry) {
 	buf.WriteString(entry.Message)
 	if i := len(entry.Fields); i > 0 {
+		keys := make([]string, 0, len(entry.Fields))
+		for k := range entry.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
 		buf.WriteByte('\t')
 		buf.WriteByte('[')
-
 		for _, k := range keys {
 			buf.WriteString(fmt.Sprintf("%s: %v", k, entry.Fields[k]))
 			i--
@@ -76,11 +86,4 @@ func (lh *logHandler) HandleLog(entry *log.Entry) error {
 		}
 		buf.WriteByte(']')
 	}
-	buf.WriteByte('\n')
-
-	lh.mu.Lock()
-	_, err := buf.WriteTo(lh.Writer)
-	lh.mu.Unlock()
-	bytesPool.Put(buf.Bytes())
-	return err
 }
@@ -1,10 +1,19 @@
 package logutils
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkLog4FieldsEntry(b *testing.B) {
@@ -18,3 +27,61 @@ func BenchmarkLog4FieldsEntry(b *testing.B) {
 		}
 	})
 }
+
+func TestJSONHandler(t *testing.T) {
+	assertT := require.New(t)
+
+	var buf bytes.Buffer
+	entry := &log.Entry{
+		Fields: log.Fields{
+			"box":     "porto",
+			"error":   errors.New("failed"),
+			"message": "collision",
+		},
+		Level:     log.WarnLevel,
+		Timestamp: time.Now(),
+		Message:   "unable to spawn",
+	}
+	assertT.NoError(NewJSONHandler(&buf).HandleLog(entry))
+
+	var record map[string]interface{}
+	assertT.NoError(json.Unmarshal(buf.Bytes(), &record))
+	assertT.Equal("WARN", record["level"])
+	assertT.Equal("unable to spawn", record["message"])
+	assertT.Equal("collision", record["field.message"])
+	assertT.Equal("porto", record["box"])
+	assertT.Equal("failed", record["error"])
+}
+
+func TestSyslogHandler(t *testing.T) {
+	assertT := require.New(t)
+
+	dir, err := ioutil.TempDir("", "stout-syslog")
+	assertT.NoError(err)
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "log.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	assertT.NoError(err)
+	defer conn.Close()
+
+	h, err := NewSyslogHandler(addr, "isolate")
+	assertT.NoError(err)
+
+	entry := &log.Entry{
+		Fields:  log.Fields{"app": "echo"},
+		Level:   log.ErrorLevel,
+		Message: "spawn failed",
+	}
+	assertT.NoError(h.HandleLog(entry))
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assertT.NoError(err)
+	msg := string(buf[:n])
+	// LOG_DAEMON|LOG_ERR
+	assertT.True(strings.HasPrefix(msg, "<27>"), msg)
+	assertT.Contains(msg, "isolate[")
+	assertT.Contains(msg, "spawn failed\t[app: echo]")
+}
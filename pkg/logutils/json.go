@@ -0,0 +1,67 @@
+package logutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/apex/log"
+)
+
+type jsonHandler struct {
+	mu sync.Mutex
+	io.Writer
+}
+
+// NewJSONHandler returns log.Handler writing an entry per line as a JSON object.
+// Fields are put next to `time`, `level` and `message`, colliding ones get the `field.` prefix
+func NewJSONHandler(w io.Writer) log.Handler {
+	return &jsonHandler{Writer: w}
+}
+
+func (jh *jsonHandler) HandleLog(entry *log.Entry) error {
+	record := make(map[string]interface{}, len(entry.Fields)+3)
+	for k, v := range entry.Fields {
+		switch k {
+		case "time", "level", "message":
+			k = "field." + k
+		}
+		record[k] = jsonValue(v)
+	}
+	record["time"] = entry.Timestamp.Format(timeFormat)
+	record["level"] = getLevel(entry.Level)
+	record["message"] = entry.Message
+
+	buf := bytes.NewBuffer(getBytesFromPool())
+	if err := json.NewEncoder(buf).Encode(record); err != nil {
+		bytesPool.Put(buf.Bytes())
+		return err
+	}
+
+	jh.mu.Lock()
+	_, err := buf.WriteTo(jh.Writer)
+	jh.mu.Unlock()
+	bytesPool.Put(buf.Bytes())
+	return err
+}
+
+// jsonValue converts a field to a value encoding/json is able to marshal
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	case error:
+		return v.Error()
+	case json.Marshaler:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	default:
+		if _, err := json.Marshal(v); err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return v
+	}
+}
@@ -0,0 +1,43 @@
+package logutils
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+
+	"github.com/noxiouz/stout/pkg/secret"
+)
+
+type redactHandler struct {
+	log.Handler
+}
+
+// NewRedactHandler returns log.Handler which replaces known secrets
+// in a message and in fields before passing an entry to h
+func NewRedactHandler(h log.Handler) log.Handler {
+	return &redactHandler{Handler: h}
+}
+
+func (rh *redactHandler) HandleLog(entry *log.Entry) error {
+	redacted := *entry
+	redacted.Message = secret.Redact(entry.Message)
+	if len(entry.Fields) > 0 {
+		redacted.Fields = make(log.Fields, len(entry.Fields))
+		for k, v := range entry.Fields {
+			switch v := v.(type) {
+			case string:
+				redacted.Fields[k] = secret.Redact(v)
+			case error:
+				redacted.Fields[k] = secret.Redact(v.Error())
+			case fmt.Stringer:
+				redacted.Fields[k] = secret.Redact(v.String())
+			case int, int64, uint, uint64, float64, bool, nil:
+				redacted.Fields[k] = v
+			default:
+				redacted.Fields[k] = secret.Redact(fmt.Sprintf("%v", v))
+			}
+		}
+	}
+
+	return rh.Handler.HandleLog(&redacted)
+}
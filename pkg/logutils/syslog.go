@@ -0,0 +1,54 @@
+package logutils
+
+import (
+	"bytes"
+	"log/syslog"
+
+	"github.com/apex/log"
+)
+
+type syslogHandler struct {
+	w *syslog.Writer
+}
+
+// NewSyslogHandler returns log.Handler sending entries to a local syslog daemon or journald
+// over a unix datagram socket. The default socket (/dev/log) is used if addr is empty
+func NewSyslogHandler(addr, tag string) (log.Handler, error) {
+	var (
+		w   *syslog.Writer
+		err error
+	)
+	if addr == "" {
+		w, err = syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	} else {
+		w, err = syslog.Dial("unixgram", addr, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHandler{w: w}, nil
+}
+
+func (sh *syslogHandler) HandleLog(entry *log.Entry) error {
+	buf := bytes.NewBuffer(getBytesFromPool())
+	writeMessage(buf, entry)
+	msg := buf.String()
+	bytesPool.Put(buf.Bytes())
+
+	switch entry.Level {
+	case log.DebugLevel:
+		return sh.w.Debug(msg)
+	case log.InfoLevel:
+		return sh.w.Info(msg)
+	case log.WarnLevel:
+		return sh.w.Warning(msg)
+	case log.ErrorLevel:
+		return sh.w.Err(msg)
+	default:
+		return sh.w.Crit(msg)
+	}
+}
+
+func (sh *syslogHandler) Close() error {
+	return sh.w.Close()
+}
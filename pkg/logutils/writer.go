@@ -1,10 +1,16 @@
 package logutils
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
 )
 
+// rotationCheckInterval limits how often FileOutput looks for a rotated file
+const rotationCheckInterval = time.Second
+
 type nopCloser struct {
 	io.Writer
 }
@@ -15,6 +21,11 @@ func newNopCloser(w io.Writer) io.WriteCloser {
 	return nopCloser{w}
 }
 
+// Reopener is implemented by outputs which can be reopened after rotation
+type Reopener interface {
+	Reopen() error
+}
+
 func NewLogFileOutput(filepath string) (io.WriteCloser, error) {
 	switch filepath {
 	case os.Stderr.Name():
@@ -22,6 +33,79 @@ func NewLogFileOutput(filepath string) (io.WriteCloser, error) {
 	case os.Stdout.Name():
 		return newNopCloser(os.Stdout), nil
 	default:
-		return os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		return NewFileOutput(filepath)
+	}
+}
+
+// FileOutput appends to a file and reopens it when the file has been moved or removed
+// (e.g. by logrotate) or when Reopen is called
+type FileOutput struct {
+	path string
+
+	mu        sync.Mutex
+	file      *os.File
+	lastCheck time.Time
+}
+
+func NewFileOutput(path string) (*FileOutput, error) {
+	f := &FileOutput{path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileOutput) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
 	}
+	f.file = file
+	f.lastCheck = time.Now()
+	return nil
+}
+
+// Reopen opens the file by its path again. The current file is kept on error
+func (f *FileOutput) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open()
+}
+
+func (f *FileOutput) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now := time.Now(); now.Sub(f.lastCheck) >= rotationCheckInterval {
+		f.lastCheck = now
+		if f.rotated() {
+			if err := f.open(); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to reopen rotated log file %s: %v\n", f.path, err)
+			}
+		}
+	}
+
+	return f.file.Write(p)
+}
+
+// rotated reports whether the path points to another file than the opened one
+func (f *FileOutput) rotated() bool {
+	current, err := f.file.Stat()
+	if err != nil {
+		return true
+	}
+	actual, err := os.Stat(f.path)
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(current, actual)
+}
+
+func (f *FileOutput) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
 }
@@ -0,0 +1,49 @@
+package logutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileOutputReopen(t *testing.T) {
+	assertT := require.New(t)
+
+	dir, err := ioutil.TempDir("", "stout-log")
+	assertT.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "isolate.log")
+
+	output, err := NewFileOutput(path)
+	assertT.NoError(err)
+	defer output.Close()
+
+	_, err = output.Write([]byte("first\n"))
+	assertT.NoError(err)
+
+	// logrotate moves the file and sends SIGUSR1
+	assertT.NoError(os.Rename(path, path+".1"))
+	assertT.NoError(output.Reopen())
+	_, err = output.Write([]byte("second\n"))
+	assertT.NoError(err)
+
+	// the file is moved without a signal: the rotation is detected on write
+	assertT.NoError(os.Rename(path, path+".2"))
+	output.lastCheck = time.Now().Add(-rotationCheckInterval)
+	_, err = output.Write([]byte("third\n"))
+	assertT.NoError(err)
+
+	for name, expected := range map[string]string{
+		path + ".1": "first\n",
+		path + ".2": "second\n",
+		path:        "third\n",
+	} {
+		data, err := ioutil.ReadFile(name)
+		assertT.NoError(err)
+		assertT.Equal(expected, string(data))
+	}
+}
@@ -0,0 +1,283 @@
+// Package mirror tracks health of registries and their mirrors.
+// Every endpoint has a circuit breaker: it opens after consecutive failures,
+// rejects requests for a while and then lets one trial request through
+package mirror
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultOpenSec          = 30
+)
+
+// Config lists mirrors of registries and configures breakers of endpoints
+type Config struct {
+	// Mirrors of a registry are tried in order, the registry itself is the last resort
+	Mirrors map[string][]string `json:"mirrors"`
+	// FailureThreshold is the number of consecutive failures opening a breaker
+	FailureThreshold int `json:"failurethreshold"`
+	// OpenSec is how long an open breaker rejects requests before a trial one
+	OpenSec uint `json:"opensec"`
+}
+
+// Metrics of a Pool. Create it once and register in a box registry
+type Metrics struct {
+	// Failovers counts requests retried with the next endpoint
+	Failovers metrics.Counter
+	// Rejected counts requests skipped by open breakers
+	Rejected metrics.Counter
+	Opened   metrics.Counter
+	Open     metrics.Gauge
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Failovers: metrics.NewCounter(),
+		Rejected:  metrics.NewCounter(),
+		Opened:    metrics.NewCounter(),
+		Open:      metrics.NewGauge(),
+	}
+}
+
+// Register registers metrics with the prefix
+func (m *Metrics) Register(r metrics.Registry, prefix string) {
+	r.Register(prefix+"failovers", m.Failovers)
+	r.Register(prefix+"rejected", m.Rejected)
+	r.Register(prefix+"opened", m.Opened)
+	r.Register(prefix+"open", m.Open)
+}
+
+// State of a breaker
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Endpoint is a registry or a mirror with its breaker
+type Endpoint struct {
+	Host string
+	// Primary is set for the registry mirrors belong to
+	Primary bool
+
+	pool *Pool
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	// trial is set while the request of a half-open breaker is in flight
+	trial bool
+}
+
+// Allow tells if a request to the endpoint may be sent.
+// A half-open breaker allows one request at a time, its result must be reported
+func (e *Endpoint) Allow() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failures < e.pool.threshold {
+		return true
+	}
+	if e.pool.now().Before(e.openUntil) || e.trial {
+		return false
+	}
+	e.trial = true
+	return true
+}
+
+// Report records the result of a request. Failures caused by the cancelled ctx are not counted,
+// client errors are replies of a healthy endpoint
+func (e *Endpoint) Report(ctx context.Context, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.trial = false
+	switch {
+	case !IsFailure(err):
+		if e.failures >= e.pool.threshold {
+			e.pool.metrics.Open.Update(e.pool.open(-1))
+		}
+		e.failures = 0
+	case ctx.Err() != nil:
+	default:
+		e.failures++
+		if e.failures == e.pool.threshold {
+			e.pool.metrics.Opened.Inc(1)
+			e.pool.metrics.Open.Update(e.pool.open(1))
+		}
+		if e.failures >= e.pool.threshold {
+			e.openUntil = e.pool.now().Add(e.pool.openFor)
+		}
+	}
+}
+
+// ClientError marks an error replied by a healthy endpoint, it isn't counted by the breaker
+type ClientError struct {
+	Err error
+}
+
+func (e *ClientError) Error() string {
+	return e.Err.Error()
+}
+
+// IsFailure tells if the error means that the endpoint is unhealthy: transport errors, 5xx and 429.
+// Unknown manifests and blobs or denied access are client errors
+func IsFailure(err error) bool {
+	switch err := err.(type) {
+	case nil, *ClientError:
+		return false
+	case errcode.Errors:
+		for _, e := range err {
+			if IsFailure(e) {
+				return true
+			}
+		}
+		return false
+	case errcode.Error:
+		return isFailureStatus(err.Code.Descriptor().HTTPStatusCode)
+	case errcode.ErrorCode:
+		return isFailureStatus(err.Descriptor().HTTPStatusCode)
+	case *client.UnexpectedHTTPResponseError:
+		return isFailureStatus(err.StatusCode)
+	case distribution.ErrTagUnknown, distribution.ErrManifestUnknown, distribution.ErrManifestUnknownRevision:
+		return false
+	}
+	return err != distribution.ErrBlobUnknown
+}
+
+func isFailureStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
+
+// State returns the current state of the breaker
+func (e *Endpoint) State() State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case e.failures < e.pool.threshold:
+		return Closed
+	case e.pool.now().Before(e.openUntil):
+		return Open
+	}
+	return HalfOpen
+}
+
+// Pool keeps endpoints of registries, so their health is shared by all spools
+type Pool struct {
+	mirrors   map[string][]string
+	threshold int
+	openFor   time.Duration
+	metrics   *Metrics
+	now       func() time.Time
+
+	mu        sync.Mutex
+	endpoints map[string]*Endpoint
+	opened    int64
+}
+
+// NewPool creates a Pool. m may be nil
+func NewPool(cfg Config, m *Metrics) *Pool {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.OpenSec == 0 {
+		cfg.OpenSec = defaultOpenSec
+	}
+	if m == nil {
+		m = NewMetrics()
+	}
+	return &Pool{
+		mirrors:   cfg.Mirrors,
+		threshold: cfg.FailureThreshold,
+		openFor:   time.Duration(cfg.OpenSec) * time.Second,
+		metrics:   m,
+		now:       time.Now,
+		endpoints: make(map[string]*Endpoint),
+	}
+}
+
+func (p *Pool) open(delta int64) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opened += delta
+	return p.opened
+}
+
+func (p *Pool) endpoint(host string, primary bool) *Endpoint {
+	key := host
+	if primary {
+		key = "primary:" + host
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.endpoints[key]
+	if !ok {
+		e = &Endpoint{Host: host, Primary: primary, pool: p}
+		p.endpoints[key] = e
+	}
+	return e
+}
+
+// Endpoints returns mirrors of the registry in the configured order followed by the registry
+func (p *Pool) Endpoints(registry string) []*Endpoint {
+	mirrors := p.mirrors[registry]
+	endpoints := make([]*Endpoint, 0, len(mirrors)+1)
+	for _, host := range mirrors {
+		endpoints = append(endpoints, p.endpoint(host, false))
+	}
+	return append(endpoints, p.endpoint(registry, true))
+}
+
+// Do calls fn with indexes of endpoints in order until it succeeds and reports results to breakers.
+// Endpoints with open breakers are skipped. The error of the last tried endpoint is returned
+func (p *Pool) Do(ctx context.Context, endpoints []*Endpoint, fn func(i int) error) error {
+	var (
+		err     error
+		tried   bool
+		skipped []string
+	)
+	for i, e := range endpoints {
+		if !e.Allow() {
+			p.metrics.Rejected.Inc(1)
+			skipped = append(skipped, e.Host)
+			continue
+		}
+		if tried {
+			p.metrics.Failovers.Inc(1)
+		}
+		tried = true
+		err = fn(i)
+		e.Report(ctx, err)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	if !tried {
+		return fmt.Errorf("all endpoints are unavailable: %s", strings.Join(skipped, ", "))
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package mirror
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/client"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func newTestPool(now *time.Time) *Pool {
+	p := NewPool(Config{
+		Mirrors:          map[string][]string{"registry": {"mirror1", "mirror2"}},
+		FailureThreshold: 2,
+		OpenSec:          10,
+	}, nil)
+	p.now = func() time.Time { return *now }
+	return p
+}
+
+func TestEndpointsOrder(t *testing.T) {
+	now := time.Now()
+	p := newTestPool(&now)
+
+	endpoints := p.Endpoints("registry")
+	require.Len(t, endpoints, 3)
+	require.Equal(t, "mirror1", endpoints[0].Host)
+	require.Equal(t, "mirror2", endpoints[1].Host)
+	require.Equal(t, "registry", endpoints[2].Host)
+	require.True(t, endpoints[2].Primary)
+	// health is shared between calls
+	require.True(t, endpoints[0] == p.Endpoints("registry")[0])
+
+	endpoints = p.Endpoints("other")
+	require.Len(t, endpoints, 1)
+	require.True(t, endpoints[0].Primary)
+}
+
+func TestBreaker(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	p := newTestPool(&now)
+	e := p.Endpoints("registry")[0]
+
+	failure := errors.New("failure")
+	e.Report(ctx, failure)
+	require.Equal(Closed, e.State())
+	require.True(e.Allow())
+	e.Report(ctx, failure)
+	require.Equal(Open, e.State())
+	require.False(e.Allow())
+	require.EqualValues(1, p.metrics.Opened.Count())
+	require.EqualValues(1, p.metrics.Open.Value())
+
+	// one trial request after the timeout
+	now = now.Add(11 * time.Second)
+	require.Equal(HalfOpen, e.State())
+	require.True(e.Allow())
+	require.False(e.Allow())
+	e.Report(ctx, failure)
+	require.Equal(Open, e.State())
+
+	now = now.Add(11 * time.Second)
+	require.True(e.Allow())
+	e.Report(ctx, nil)
+	require.Equal(Closed, e.State())
+	require.EqualValues(0, p.metrics.Open.Value())
+
+	// cancelled requests aren't failures
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	e.Report(cancelled, failure)
+	e.Report(cancelled, failure)
+	require.Equal(Closed, e.State())
+}
+
+func TestIsFailure(t *testing.T) {
+	require := require.New(t)
+
+	require.False(IsFailure(nil))
+	require.True(IsFailure(errors.New("connection refused")))
+	require.True(IsFailure(&client.UnexpectedHTTPStatusError{Status: "502 Bad Gateway"}))
+	require.True(IsFailure(errcode.ErrorCodeTooManyRequests.WithMessage("slow down")))
+	require.True(IsFailure(&client.UnexpectedHTTPResponseError{StatusCode: 503}))
+
+	require.False(IsFailure(distribution.ErrBlobUnknown))
+	require.False(IsFailure(distribution.ErrTagUnknown{Tag: "latest"}))
+	require.False(IsFailure(errcode.Errors{v2.ErrorCodeManifestUnknown.WithMessage("unknown")}))
+	require.False(IsFailure(errcode.ErrorCodeUnauthorized.WithMessage("denied")))
+	require.False(IsFailure(&client.UnexpectedHTTPResponseError{StatusCode: 404}))
+	require.False(IsFailure(&ClientError{Err: errors.New("not found")}))
+}
+
+func TestBreakerClientErrors(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	p := newTestPool(&now)
+	e := p.Endpoints("registry")[0]
+
+	// a mistyped tag doesn't open the breaker
+	for i := 0; i < 3; i++ {
+		e.Report(ctx, errcode.Errors{v2.ErrorCodeManifestUnknown.WithMessage("unknown")})
+	}
+	require.Equal(Closed, e.State())
+
+	// and replies of a healthy endpoint reset failures
+	e.Report(ctx, errors.New("failure"))
+	e.Report(ctx, distribution.ErrBlobUnknown)
+	e.Report(ctx, errors.New("failure"))
+	require.Equal(Closed, e.State())
+}
+
+func TestDoFailover(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	p := newTestPool(&now)
+	endpoints := p.Endpoints("registry")
+
+	var calls []string
+	err := p.Do(ctx, endpoints, func(i int) error {
+		calls = append(calls, endpoints[i].Host)
+		if endpoints[i].Primary {
+			return nil
+		}
+		return errors.New("mirror is down")
+	})
+	require.NoError(err)
+	require.Equal([]string{"mirror1", "mirror2", "registry"}, calls)
+	require.EqualValues(2, p.metrics.Failovers.Count())
+
+	// open breakers of mirrors skip them
+	calls = calls[:0]
+	require.NoError(p.Do(ctx, endpoints, func(i int) error {
+		calls = append(calls, endpoints[i].Host)
+		if endpoints[i].Primary {
+			return nil
+		}
+		return errors.New("mirror is down")
+	}))
+	require.Equal([]string{"mirror1", "mirror2", "registry"}, calls)
+	require.NoError(p.Do(ctx, endpoints, func(i int) error {
+		require.True(endpoints[i].Primary)
+		return nil
+	}))
+	require.EqualValues(2, p.metrics.Rejected.Count())
+
+	err = p.Do(ctx, endpoints, func(i int) error { return errors.New("down") })
+	require.EqualError(err, "down")
+	err = p.Do(ctx, endpoints, func(i int) error { return errors.New("down") })
+	require.EqualError(err, "down")
+	err = p.Do(ctx, endpoints, func(i int) error { return nil })
+	require.EqualError(err, "all endpoints are unavailable: mirror1, mirror2, registry")
+}
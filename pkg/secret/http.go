@@ -0,0 +1,63 @@
+package secret
+
+import (
+	"net/http"
+)
+
+// Handler wraps debug HTTP handlers to redact known secrets from their replies
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &redactingWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+		rw.flushPending()
+	})
+}
+
+type redactingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	// tail of the body which might be the beginning of a secret split between writes
+	pending []byte
+}
+
+func (w *redactingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		// Redaction changes the length of the body
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.pending = append(w.pending, p...)
+	redacted, rest := redactStream(w.pending, false)
+	if len(redacted) > 0 {
+		if _, err := w.ResponseWriter.Write(redacted); err != nil {
+			return 0, err
+		}
+	}
+	w.pending = append(w.pending[:0], rest...)
+	return len(p), nil
+}
+
+func (w *redactingWriter) flushPending() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	redacted, _ := redactStream(w.pending, true)
+	w.pending = w.pending[:0]
+	_, err := w.ResponseWriter.Write(redacted)
+	return err
+}
+
+// Flush supports streaming handlers
+func (w *redactingWriter) Flush() {
+	w.flushPending()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
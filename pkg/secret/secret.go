@@ -0,0 +1,188 @@
+// Package secret marks configuration values holding credentials
+// and keeps them out of expvar, logs and debug HTTP endpoints.
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted is a placeholder printed instead of a secret value
+const Redacted = "***"
+
+// minLength is the shortest value to be tracked by the registry.
+// Shorter values would redact unrelated output.
+const minLength = 4
+
+// String holds sensitive data like a token or a password.
+// fmt, encoding/json and expvar render it as Redacted.
+// Value must be used to get the actual data.
+type String string
+
+// Value returns the actual secret value
+func (s String) Value() string {
+	return string(s)
+}
+
+func (s String) String() string {
+	return Redacted
+}
+
+// GoString is used by %#v
+func (s String) GoString() string {
+	return Redacted
+}
+
+// MarshalJSON implements json.Marshaler
+func (s String) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Redacted)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (s String) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
+
+// UnmarshalJSON decodes the value and registers it as a known secret
+func (s *String) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*s = String(value)
+	Register(value)
+	return nil
+}
+
+var (
+	mu    sync.RWMutex
+	known = make(map[string]struct{})
+	// replacer and sorted are rebuilt on every Register. They're nil if there are no secrets
+	replacer *strings.Replacer
+	// sorted are known secrets, the longest ones go first
+	sorted []string
+)
+
+// Register adds values to the set of known secrets which are
+// replaced by Redacted in Redact.
+func Register(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var added bool
+	for _, value := range values {
+		if len(value) < minLength {
+			continue
+		}
+		if _, ok := known[value]; !ok {
+			known[value] = struct{}{}
+			added = true
+		}
+	}
+
+	if added {
+		sorted = sortedKnown()
+		replacer = newReplacer(sorted)
+	}
+}
+
+func sortedKnown() []string {
+	values := make([]string, 0, len(known))
+	for value := range known {
+		values = append(values, value)
+	}
+	// Longer values go first to redact a secret completely
+	// if it contains another one
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+func newReplacer(values []string) *strings.Replacer {
+	oldnew := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		oldnew = append(oldnew, value, Redacted)
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// Known returns registered secrets. It's intended for tests
+func Known() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	values := make([]string, 0, len(known))
+	for value := range known {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Redact replaces every known secret in s with Redacted
+func Redact(s string) string {
+	mu.RLock()
+	r := replacer
+	mu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// redactStream redacts data written by parts. Unless final, the last bytes which might be
+// the beginning of a secret split between parts are returned as rest to be prepended to the next part
+func redactStream(data []byte, final bool) (redacted []byte, rest []byte) {
+	mu.RLock()
+	values := sorted
+	mu.RUnlock()
+	if len(values) == 0 {
+		return data, nil
+	}
+
+	end := len(data)
+	if !final {
+		// values[0] is the longest secret, a secret starting before end fits into data
+		end -= len(values[0]) - 1
+		if end <= 0 {
+			return nil, data
+		}
+	}
+
+	var buff bytes.Buffer
+	i := 0
+	for i < end {
+		if value := secretAt(values, data[i:]); value != "" {
+			buff.WriteString(Redacted)
+			i += len(value)
+			continue
+		}
+		buff.WriteByte(data[i])
+		i++
+	}
+	return buff.Bytes(), data[i:]
+}
+
+func secretAt(values []string, data []byte) string {
+	for _, value := range values {
+		if value[0] == data[0] && bytes.HasPrefix(data, []byte(value)) {
+			return value
+		}
+	}
+	return ""
+}
+
+var stringType = reflect.TypeOf(String(""))
+
+// DecodeHook is a mapstructure.DecodeHookFuncType.
+// It registers every value decoded to String.
+func DecodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to == stringType {
+		if value, ok := data.(string); ok {
+			Register(value)
+		}
+	}
+	return data, nil
+}
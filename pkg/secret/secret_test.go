@@ -0,0 +1,68 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringIsRedacted(t *testing.T) {
+	assertT := require.New(t)
+
+	var cfg struct {
+		Auth map[string]String `json:"auth"`
+	}
+	assertT.NoError(json.Unmarshal([]byte(`{"auth": {"registry": "OAuth secret-token"}}`), &cfg))
+	assertT.Equal("OAuth secret-token", cfg.Auth["registry"].Value())
+	assertT.Contains(Known(), "OAuth secret-token")
+
+	body, err := json.Marshal(cfg)
+	assertT.NoError(err)
+	assertT.Equal(`{"auth":{"registry":"***"}}`, string(body))
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		assertT.NotContains(fmt.Sprintf(format, cfg), "secret-token", format)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	assertT := require.New(t)
+
+	Register("abc", "longsecretvalue", "secretvalue")
+	assertT.NotContains(Known(), "abc")
+	assertT.Equal("abc ***, ***", Redact("abc longsecretvalue, secretvalue"))
+}
+
+func TestHandler(t *testing.T) {
+	assertT := require.New(t)
+	Register("handlersecret")
+
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "25")
+		fmt.Fprint(w, "token is handlersecret...")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	assertT.Equal("token is ***...", w.Body.String())
+	assertT.Empty(w.Header().Get("Content-Length"))
+}
+
+func TestHandlerSplitSecret(t *testing.T) {
+	assertT := require.New(t)
+	Register("splitsecret")
+
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "token is split")
+		fmt.Fprint(w, "sec")
+		fmt.Fprint(w, "ret, tail")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	assertT.Equal("token is ***, tail", w.Body.String())
+}
@@ -0,0 +1,55 @@
+// Package secrettest provides helpers to check that secrets
+// do not leak to debug output
+package secrettest
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Checker looks for secrets in debug output
+type Checker struct {
+	t       testing.TB
+	secrets []string
+}
+
+// New returns Checker which fails t if any of secrets is found
+func New(t testing.TB, secrets ...string) *Checker {
+	return &Checker{t: t, secrets: secrets}
+}
+
+// Bytes checks arbitrary output, e.g. captured logs. name is used in a failure message
+func (c *Checker) Bytes(name string, output []byte) *Checker {
+	c.t.Helper()
+	for _, s := range c.secrets {
+		if s != "" && bytes.Contains(output, []byte(s)) {
+			c.t.Errorf("secret %q leaked to %s: %s", s, name, output)
+		}
+	}
+	return c
+}
+
+// Expvar checks every published expvar variable
+func (c *Checker) Expvar() *Checker {
+	c.t.Helper()
+	expvar.Do(func(kv expvar.KeyValue) {
+		c.Bytes(fmt.Sprintf("expvar %s", kv.Key), []byte(kv.Value.String()))
+	})
+	return c
+}
+
+// HTTP checks replies of handler for GET requests to paths
+func (c *Checker) HTTP(handler http.Handler, paths ...string) *Checker {
+	c.t.Helper()
+	for _, path := range paths {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		c.Bytes(fmt.Sprintf("HTTP %s", path), w.Body.Bytes())
+	}
+	return c
+}
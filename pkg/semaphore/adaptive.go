@@ -0,0 +1,196 @@
+package semaphore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apexlog "github.com/apex/log"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+
+	"github.com/noxiouz/stout/pkg/log"
+)
+
+const (
+	defaultAdaptiveWindow       = 10
+	defaultAdaptiveBackoff      = 0.7
+	defaultAdaptiveMaxErrorRate = 0.1
+	defaultAdaptiveMaxFactor    = 4
+)
+
+// AdaptiveConfig describes AIMD limiter of a semaphore size.
+// The limit grows by one slot after a window of fast and successful observations
+// if the semaphore was saturated, and shrinks by Backoff after a slow or failing window
+type AdaptiveConfig struct {
+	Enabled bool  `json:"enabled"`
+	Min     int64 `json:"min"`
+	Max     int64 `json:"max"`
+	// TargetLatencyMs is the highest acceptable average latency of a window
+	TargetLatencyMs uint    `json:"targetlatencyms"`
+	MaxErrorRate    float64 `json:"maxerrorrate"`
+	// Window is the number of observations a decision is based on
+	Window  int     `json:"window"`
+	Backoff float64 `json:"backoff"`
+}
+
+// AdaptiveMetrics of a limiter. Create it once and register in a box registry
+type AdaptiveMetrics struct {
+	Limit     metrics.Gauge
+	Increases metrics.Counter
+	Decreases metrics.Counter
+	Errors    metrics.Counter
+	Latency   metrics.Timer
+}
+
+func NewAdaptiveMetrics() *AdaptiveMetrics {
+	return &AdaptiveMetrics{
+		Limit:     metrics.NewGauge(),
+		Increases: metrics.NewCounter(),
+		Decreases: metrics.NewCounter(),
+		Errors:    metrics.NewCounter(),
+		Latency:   metrics.NewTimer(),
+	}
+}
+
+// Register registers metrics with the prefix
+func (m *AdaptiveMetrics) Register(r metrics.Registry, prefix string) {
+	r.Register(prefix+"limit", m.Limit)
+	r.Register(prefix+"increases", m.Increases)
+	r.Register(prefix+"decreases", m.Decreases)
+	r.Register(prefix+"errors", m.Errors)
+	r.Register(prefix+"latency", m.Latency)
+}
+
+// Adaptive resizes a Weighted semaphore according to observed latency and errors
+type Adaptive struct {
+	sm      *Weighted
+	cfg     AdaptiveConfig
+	target  time.Duration
+	metrics *AdaptiveMetrics
+
+	mu        sync.Mutex
+	limit     int64
+	samples   int
+	failures  int
+	latency   time.Duration
+	saturated bool
+}
+
+// NewAdaptive creates a limiter of sm starting with its current size. m may be nil.
+// Max defaults to 4 times the initial size
+func NewAdaptive(sm *Weighted, cfg AdaptiveConfig, m *AdaptiveMetrics) (*Adaptive, error) {
+	initial := sm.Stats().Size
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = initial * defaultAdaptiveMaxFactor
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultAdaptiveWindow
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultAdaptiveBackoff
+	}
+	if cfg.MaxErrorRate <= 0 {
+		cfg.MaxErrorRate = defaultAdaptiveMaxErrorRate
+	}
+
+	switch {
+	case cfg.TargetLatencyMs == 0:
+		return nil, fmt.Errorf("adaptive concurrency: targetlatencyms must be specified")
+	case cfg.Min > cfg.Max:
+		return nil, fmt.Errorf("adaptive concurrency: min %d is greater than max %d", cfg.Min, cfg.Max)
+	case cfg.Backoff >= 1:
+		return nil, fmt.Errorf("adaptive concurrency: backoff must be less than 1")
+	}
+
+	if m == nil {
+		m = NewAdaptiveMetrics()
+	}
+	a := &Adaptive{
+		sm:      sm,
+		cfg:     cfg,
+		target:  time.Duration(cfg.TargetLatencyMs) * time.Millisecond,
+		metrics: m,
+		limit:   clamp(initial, cfg.Min, cfg.Max),
+	}
+	sm.Resize(a.limit)
+	m.Limit.Update(a.limit)
+	return a, nil
+}
+
+// Limit returns the current size of the semaphore
+func (a *Adaptive) Limit() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.limit
+}
+
+// Observe records the latency and the result of an operation done under the semaphore.
+// It's safe to call Observe of nil Adaptive
+func (a *Adaptive) Observe(ctx context.Context, latency time.Duration, err error) {
+	if a == nil {
+		return
+	}
+
+	a.metrics.Latency.Update(latency)
+	if err != nil {
+		a.metrics.Errors.Inc(1)
+	}
+	stats := a.sm.Stats()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.samples++
+	a.latency += latency
+	if err != nil {
+		a.failures++
+	}
+	// the limit is raised only if it has been reached, otherwise it grows without load
+	if stats.Queued > 0 || stats.Used >= stats.Size {
+		a.saturated = true
+	}
+	if a.samples < a.cfg.Window {
+		return
+	}
+
+	avg := a.latency / time.Duration(a.samples)
+	errorRate := float64(a.failures) / float64(a.samples)
+	prev := a.limit
+	switch {
+	case avg > a.target || errorRate > a.cfg.MaxErrorRate:
+		a.limit = clamp(int64(float64(a.limit)*a.cfg.Backoff), a.cfg.Min, a.cfg.Max)
+	case a.saturated:
+		a.limit = clamp(a.limit+1, a.cfg.Min, a.cfg.Max)
+	}
+	a.samples, a.failures, a.latency, a.saturated = 0, 0, 0, false
+
+	if a.limit == prev {
+		return
+	}
+	if a.limit > prev {
+		a.metrics.Increases.Inc(1)
+	} else {
+		a.metrics.Decreases.Inc(1)
+	}
+	a.metrics.Limit.Update(a.limit)
+	a.sm.Resize(a.limit)
+
+	log.G(ctx).WithFields(apexlog.Fields{
+		"from": prev, "to": a.limit, "latency": avg, "errorrate": errorRate,
+	}).Info("semaphore limit has been changed")
+}
+
+func clamp(v, min, max int64) int64 {
+	switch {
+	case v < min:
+		return min
+	case v > max:
+		return max
+	default:
+		return v
+	}
+}
@@ -0,0 +1,88 @@
+package semaphore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestAdaptiveConfig(t *testing.T) {
+	if _, err := NewAdaptive(NewWeighted(4, nil), AdaptiveConfig{}, nil); err == nil {
+		t.Fatal("targetlatencyms must be required")
+	}
+	if _, err := NewAdaptive(NewWeighted(4, nil), AdaptiveConfig{TargetLatencyMs: 10, Min: 5, Max: 2}, nil); err == nil {
+		t.Fatal("min greater than max must be rejected")
+	}
+
+	sm := NewWeighted(10, nil)
+	a, err := NewAdaptive(sm, AdaptiveConfig{TargetLatencyMs: 10, Max: 5}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if a.Limit() != 5 || sm.Stats().Size != 5 {
+		t.Fatalf("initial size must be limited by max: %d", a.Limit())
+	}
+}
+
+func TestAdaptiveAIMD(t *testing.T) {
+	ctx := context.Background()
+	m := NewAdaptiveMetrics()
+	sm := NewWeighted(4, nil)
+	a, err := NewAdaptive(sm, AdaptiveConfig{TargetLatencyMs: 100, Min: 2, Max: 6, Window: 2, Backoff: 0.5}, m)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	observe := func(latency time.Duration, err error) {
+		for i := 0; i < 2; i++ {
+			a.Observe(ctx, latency, err)
+		}
+	}
+
+	// fast, but the semaphore is idle
+	observe(time.Millisecond, nil)
+	if a.Limit() != 4 {
+		t.Fatalf("limit must not grow without load: %d", a.Limit())
+	}
+
+	release := mustAcquire(t, sm, 4, PriorityNormal)
+	observe(time.Millisecond, nil)
+	if a.Limit() != 5 {
+		t.Fatalf("limit must grow by one: %d", a.Limit())
+	}
+	releaseMore := mustAcquire(t, sm, 1, PriorityNormal)
+	observe(time.Millisecond, nil)
+	releaseMore()
+	release()
+	// saturated, but max is reached
+	release = mustAcquire(t, sm, 6, PriorityNormal)
+	observe(time.Millisecond, nil)
+	release()
+	if a.Limit() != 6 || sm.Stats().Size != 6 {
+		t.Fatalf("limit must grow up to max: %d", a.Limit())
+	}
+	if m.Increases.Count() != 2 {
+		t.Fatalf("unexpected number of increases %d", m.Increases.Count())
+	}
+
+	observe(time.Second, nil)
+	if a.Limit() != 3 {
+		t.Fatalf("limit must be halved on high latency: %d", a.Limit())
+	}
+
+	observe(time.Millisecond, errors.New("boom"))
+	if a.Limit() != 2 || sm.Stats().Size != 2 {
+		t.Fatalf("limit must not fall below min: %d", a.Limit())
+	}
+	if m.Decreases.Count() != 2 || m.Limit.Value() != 2 || m.Errors.Count() != 2 {
+		t.Fatalf("unexpected metrics: decreases %d, limit %d, errors %d",
+			m.Decreases.Count(), m.Limit.Value(), m.Errors.Count())
+	}
+}
+
+func TestAdaptiveNil(t *testing.T) {
+	var a *Adaptive
+	a.Observe(context.Background(), time.Second, nil)
+}
@@ -0,0 +1,283 @@
+package semaphore
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+// Priority class of a waiter. Waiters of a higher class are served first,
+// waiters of the same class are served in FIFO order
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+const numPriorities = 3
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// ParsePriority converts `low`, `normal` or `high` to Priority. Empty string means PriorityNormal
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	default:
+		return PriorityNormal, fmt.Errorf("unknown priority %s", s)
+	}
+}
+
+func (p Priority) index() int {
+	switch {
+	case p < PriorityLow:
+		p = PriorityLow
+	case p > PriorityHigh:
+		p = PriorityHigh
+	}
+	return int(PriorityHigh - p)
+}
+
+// Metrics of a Weighted semaphore. Create it once and register in a box registry
+type Metrics struct {
+	Size    metrics.Gauge
+	Used    metrics.Gauge
+	Holders metrics.Gauge
+	Queued  metrics.Gauge
+	// WaitTimer measures time spent waiting for slots
+	WaitTimer metrics.Timer
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Size:      metrics.NewGauge(),
+		Used:      metrics.NewGauge(),
+		Holders:   metrics.NewGauge(),
+		Queued:    metrics.NewGauge(),
+		WaitTimer: metrics.NewTimer(),
+	}
+}
+
+// Register registers metrics with the prefix
+func (m *Metrics) Register(r metrics.Registry, prefix string) {
+	r.Register(prefix+"size", m.Size)
+	r.Register(prefix+"used", m.Used)
+	r.Register(prefix+"holders", m.Holders)
+	r.Register(prefix+"queued", m.Queued)
+	r.Register(prefix+"wait_timer", m.WaitTimer)
+}
+
+// Stats describes the current state of a Weighted semaphore
+type Stats struct {
+	Size    int64 `json:"size"`
+	Used    int64 `json:"used"`
+	Holders int   `json:"holders"`
+	Queued  int   `json:"queued"`
+}
+
+type waiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// Weighted is a fair semaphore with weighted acquisition and priority classes.
+// A waiter which doesn't fit blocks waiters behind it and waiters of lower classes,
+// so heavy acquisitions are not starved by light ones
+type Weighted struct {
+	mu      sync.Mutex
+	size    int64
+	used    int64
+	holders int
+	queues  [numPriorities]list.List
+	queued  int
+
+	metrics *Metrics
+}
+
+var _ Semaphore = &Weighted{}
+
+// NewWeighted creates a semaphore with size slots. m may be nil
+func NewWeighted(size int64, m *Metrics) *Weighted {
+	if size <= 0 {
+		panic("Semaphore: size must be positive")
+	}
+	if m == nil {
+		m = NewMetrics()
+	}
+	s := &Weighted{
+		size:    size,
+		metrics: m,
+	}
+	s.updateMetrics()
+	return s
+}
+
+// Acquire takes a slot with normal priority
+func (s *Weighted) Acquire(ctx context.Context) error {
+	_, err := s.acquire(ctx, 1, PriorityNormal)
+	return err
+}
+
+// Release releases a slot taken by Acquire
+func (s *Weighted) Release() {
+	s.release(1)
+}
+
+// AcquireN takes n slots. n is limited by the size of the semaphore, so heavy acquisitions
+// take the whole semaphore instead of blocking forever. The returned function releases the slots
+func (s *Weighted) AcquireN(ctx context.Context, n int64, prio Priority) (func(), error) {
+	n, err := s.acquire(ctx, n, prio)
+	if err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.release(n) })
+	}, nil
+}
+
+func (s *Weighted) acquire(ctx context.Context, n int64, prio Priority) (int64, error) {
+	start := time.Now()
+	defer s.metrics.WaitTimer.UpdateSince(start)
+
+	if n <= 0 {
+		n = 1
+	}
+
+	s.mu.Lock()
+	if n > s.size {
+		n = s.size
+	}
+	if s.used+n <= s.size && !s.hasWaiters(prio) {
+		s.used += n
+		s.holders++
+		s.updateMetrics()
+		s.mu.Unlock()
+		return n, nil
+	}
+
+	w := &waiter{n: n, ready: make(chan struct{})}
+	queue := &s.queues[prio.index()]
+	elem := queue.PushBack(w)
+	s.queued++
+	s.updateMetrics()
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.n, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-w.ready:
+			// acquired concurrently with the cancellation
+			s.used -= w.n
+			s.holders--
+		default:
+			queue.Remove(elem)
+			s.queued--
+		}
+		s.notify()
+		s.updateMetrics()
+		return 0, ctx.Err()
+	}
+}
+
+func (s *Weighted) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= n
+	s.holders--
+	if s.used < 0 || s.holders < 0 {
+		panic("Semaphore: released more than held")
+	}
+	s.notify()
+	s.updateMetrics()
+}
+
+// Resize changes the number of slots. Holders are not affected if the size is decreased,
+// new waiters are blocked until enough slots are released
+func (s *Weighted) Resize(size int64) {
+	if size <= 0 {
+		panic("Semaphore: size must be positive")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	s.notify()
+	s.updateMetrics()
+}
+
+// Stats returns the current state
+func (s *Weighted) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{
+		Size:    s.size,
+		Used:    s.used,
+		Holders: s.holders,
+		Queued:  s.queued,
+	}
+}
+
+// hasWaiters reports whether there are waiters of the priority or higher
+func (s *Weighted) hasWaiters(prio Priority) bool {
+	for i := 0; i <= prio.index(); i++ {
+		if s.queues[i].Len() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes waiters which fit in free slots. It must be called under the lock
+func (s *Weighted) notify() {
+	for i := range s.queues {
+		queue := &s.queues[i]
+		for queue.Len() > 0 {
+			front := queue.Front()
+			w := front.Value.(*waiter)
+			if w.n > s.size {
+				w.n = s.size
+			}
+			if s.used+w.n > s.size {
+				return
+			}
+			s.used += w.n
+			s.holders++
+			s.queued--
+			queue.Remove(front)
+			close(w.ready)
+		}
+	}
+}
+
+func (s *Weighted) updateMetrics() {
+	s.metrics.Size.Update(s.size)
+	s.metrics.Used.Update(s.used)
+	s.metrics.Holders.Update(int64(s.holders))
+	s.metrics.Queued.Update(int64(s.queued))
+}
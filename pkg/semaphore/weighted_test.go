@@ -0,0 +1,160 @@
+package semaphore
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func mustAcquire(t *testing.T, sm *Weighted, n int64, prio Priority) func() {
+	release, err := sm.AcquireN(context.Background(), n, prio)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return release
+}
+
+// acquireAsync starts a waiter and blocks until it's queued
+func acquireAsync(t *testing.T, sm *Weighted, n int64, prio Priority, order chan<- int, id int) {
+	queued := sm.Stats().Queued
+	go func() {
+		release, err := sm.AcquireN(context.Background(), n, prio)
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+			return
+		}
+		order <- id
+		release()
+	}()
+	for sm.Stats().Queued == queued {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWeightedFIFO(t *testing.T) {
+	sm := NewWeighted(1, nil)
+	release := mustAcquire(t, sm, 1, PriorityNormal)
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		acquireAsync(t, sm, 1, PriorityNormal, order, i)
+	}
+	release()
+
+	for i := 0; i < 3; i++ {
+		if id := <-order; id != i {
+			t.Fatalf("waiter %d has been served instead of %d", id, i)
+		}
+	}
+}
+
+func TestWeightedPriority(t *testing.T) {
+	sm := NewWeighted(1, nil)
+	release := mustAcquire(t, sm, 1, PriorityNormal)
+
+	order := make(chan int, 3)
+	acquireAsync(t, sm, 1, PriorityLow, order, 2)
+	acquireAsync(t, sm, 1, PriorityNormal, order, 1)
+	acquireAsync(t, sm, 1, PriorityHigh, order, 0)
+	release()
+
+	for i := 0; i < 3; i++ {
+		if id := <-order; id != i {
+			t.Fatalf("waiter %d has been served instead of %d", id, i)
+		}
+	}
+}
+
+func TestWeightedHeavyIsNotStarved(t *testing.T) {
+	sm := NewWeighted(3, nil)
+	release := mustAcquire(t, sm, 1, PriorityNormal)
+
+	order := make(chan int, 1)
+	acquireAsync(t, sm, 3, PriorityNormal, order, 0)
+
+	// a light waiter must queue behind the heavy one even though it fits
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := sm.AcquireN(ctx, 1, PriorityNormal); err != context.DeadlineExceeded {
+		t.Fatalf("light waiter has overtaken the heavy one: %v", err)
+	}
+
+	release()
+	if id := <-order; id != 0 {
+		t.Fatalf("unexpected waiter %d", id)
+	}
+}
+
+func TestWeightedClampsToSize(t *testing.T) {
+	sm := NewWeighted(2, nil)
+	release := mustAcquire(t, sm, 10, PriorityNormal)
+	if stats := sm.Stats(); stats.Used != 2 || stats.Holders != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	release()
+	release()
+	if stats := sm.Stats(); stats.Used != 0 || stats.Holders != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestWeightedCancel(t *testing.T) {
+	m := NewMetrics()
+	sm := NewWeighted(1, m)
+	release := mustAcquire(t, sm, 1, PriorityNormal)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		_, err := sm.AcquireN(ctx, 1, PriorityHigh)
+		errC <- err
+	}()
+	for sm.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if q := m.Queued.Value(); q != 1 {
+		t.Fatalf("queued gauge must be 1, not %d", q)
+	}
+
+	cancel()
+	if err := <-errC; err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if stats := sm.Stats(); stats.Queued != 0 || stats.Used != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	release()
+	mustAcquire(t, sm, 1, PriorityLow)()
+}
+
+func TestWeightedResize(t *testing.T) {
+	sm := NewWeighted(1, nil)
+	release := mustAcquire(t, sm, 1, PriorityNormal)
+
+	order := make(chan int, 1)
+	acquireAsync(t, sm, 1, PriorityNormal, order, 0)
+	sm.Resize(2)
+	if id := <-order; id != 0 {
+		t.Fatalf("unexpected waiter %d", id)
+	}
+
+	sm.Resize(1)
+	release()
+	if stats := sm.Stats(); stats.Size != 1 || stats.Used != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for _, prio := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		parsed, err := ParsePriority(prio.String())
+		if err != nil || parsed != prio {
+			t.Fatalf("%s has been parsed as %s: %v", prio, parsed, err)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Fatal("error is expected")
+	}
+}